@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// afterResponseContextKey is the key used to store an `afterResponseQueue` instance in a `context.Context`.
+type afterResponseContextKey struct{}
+
+// afterResponseQueue is the list of functions to run once the response to a request, or Lambda invocation, has been sent.
+type afterResponseQueue struct {
+	mu    *sync.Mutex
+	funcs []func(context.Context)
+	done  bool
+}
+
+// AfterResponse queues 'fn' to be run once the response to the request associated with 'ctx' has been sent to the client.
+// This is intended for work, like flushing analytics or writing to a cache, which should not delay the response but which
+// must not be lost either. Functions are run in the order they were queued and are passed a context which carries the
+// values of 'ctx' but which is not cancelled when the request completes.
+//
+// For the `lambda://`, `functionurl://` and `lambdawebsocket://` servers queued functions are run after the response has been
+// posted to the Lambda Runtime API but before the next invocation is requested, since the function may be frozen, and any
+// goroutines it started suspended, as soon as it does. They are passed a context with the invocation's deadline. The exception
+// is the deprecated "go1.x" runtime, which invokes functions using RPC rather than the Runtime API, and handlers which are
+// invoked directly using their `Invoke` method (for example by `InvokeLambdaEvent` or the `lambdaemulator://` server). In
+// both cases the response is not returned until the handler has been closed, so queued functions are run before the response
+// is returned and their duration is added to the response time. For the `http://` server queued functions are run in the
+// background and the server waits for them to complete when it shuts down. For all other servers, or if 'ctx' is not associated
+// with a request, 'fn' is run in a new goroutine.
+func AfterResponse(ctx context.Context, fn func(context.Context)) {
+
+	if q, ok := ctx.Value(afterResponseContextKey{}).(*afterResponseQueue); ok && q.add(fn) {
+		return
+	}
+
+	go fn(context.WithoutCancel(ctx))
+}
+
+// withAfterResponseQueue returns a copy of 'ctx' with a new `afterResponseQueue` instance, which is also returned.
+func withAfterResponseQueue(ctx context.Context) (context.Context, *afterResponseQueue) {
+
+	q := &afterResponseQueue{
+		mu:    new(sync.Mutex),
+		funcs: make([]func(context.Context), 0),
+	}
+
+	return context.WithValue(ctx, afterResponseContextKey{}, q), q
+}
+
+// add appends 'fn' to 'q'. It returns false if 'q' has already been run.
+func (q *afterResponseQueue) add(fn func(context.Context)) bool {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.done {
+		return false
+	}
+
+	q.funcs = append(q.funcs, fn)
+	return true
+}
+
+// len returns the number of functions waiting to be run in 'q'.
+func (q *afterResponseQueue) len() int {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.funcs)
+}
+
+// run runs the functions in 'q', including any that are queued by those functions, with 'ctx'. Panics are
+// recovered and logged using 'logger'. Once it returns any functions subsequently added to the queue
+// are run in their own goroutine.
+func (q *afterResponseQueue) run(ctx context.Context, logger *slog.Logger) {
+
+	count := 0
+	t1 := time.Now()
+
+	for {
+
+		q.mu.Lock()
+
+		if len(q.funcs) == 0 {
+			q.done = true
+			q.mu.Unlock()
+			break
+		}
+
+		fn := q.funcs[0]
+		q.funcs = q.funcs[1:]
+
+		q.mu.Unlock()
+
+		func() {
+
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Error("After response function panicked", "error", r)
+				}
+			}()
+
+			fn(ctx)
+		}()
+
+		count += 1
+	}
+
+	if count > 0 {
+		logger.Debug("Ran after response functions", "count", count, "duration", time.Since(t1))
+	}
+}
+
+// newAfterResponseHandler returns an `http.Handler` that serves requests using 'next' and then runs any functions
+// queued with `AfterResponse` in the background, tracking them with 'wg'.
+func newAfterResponseHandler(next http.Handler, wg *sync.WaitGroup, logger *slog.Logger) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx, q := withAfterResponseQueue(req.Context())
+		next.ServeHTTP(rsp, req.WithContext(ctx))
+
+		if q.len() == 0 {
+			q.run(ctx, logger)
+			return
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			q.run(context.WithoutCancel(ctx), logger)
+		}()
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// afterResponseReader implements the `io.Reader` and `io.Closer` interfaces for Lambda responses. The Lambda runtime
+// closes responses once they have been posted to the Runtime API and before it requests the next invocation, at which
+// point the functions queued with `AfterResponse` are run. When the handler is invoked using RPC, or its `Invoke` method,
+// the response is read and closed before it is returned so the functions are run first.
+type afterResponseReader struct {
+	io.Reader
+	content_type string
+	queue        *afterResponseQueue
+	ctx          context.Context
+	logger       *slog.Logger
+}
+
+// ContentType returns the content type of the response, which is passed to the Lambda Runtime API.
+func (r *afterResponseReader) ContentType() string {
+	return r.content_type
+}
+
+// Close closes the underlying response, if necessary, and runs any functions queued with `AfterResponse`.
+func (r *afterResponseReader) Close() error {
+
+	var err error
+
+	if c, ok := r.Reader.(io.Closer); ok {
+		err = c.Close()
+	}
+
+	r.queue.run(r.ctx, r.logger)
+	return err
+}
+
+// MarshalJSON returns an error so that the Lambda runtime sends the response as-is rather than encoding it as JSON.
+func (r *afterResponseReader) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("Response is not JSON")
+}
+
+// withAfterResponse returns a Lambda handler function that invokes 'fn' with a context that functions can be queued on
+// using `AfterResponse` and returns its response as an `afterResponseReader`. 'fn' may return a `[]byte` response, which
+// is sent as-is, a streaming (`io.Reader`) response or any other value, which is encoded as JSON.
+func withAfterResponse[E any, T any](fn func(context.Context, E) (T, error), logger *slog.Logger) func(context.Context, E) (io.Reader, error) {
+
+	return func(ctx context.Context, event E) (io.Reader, error) {
+
+		ctx, q := withAfterResponseQueue(ctx)
+
+		rsp, err := fn(ctx, event)
+
+		if err != nil {
+			q.run(ctx, logger)
+			return nil, err
+		}
+
+		r := &afterResponseReader{
+			queue:  q,
+			ctx:    ctx,
+			logger: logger,
+		}
+
+		switch v := any(rsp).(type) {
+		case []byte:
+
+			r.Reader = bytes.NewReader(v)
+			r.content_type = "application/octet-stream"
+
+		case io.Reader:
+
+			r.Reader = v
+			r.content_type = "application/octet-stream"
+
+			if ct, ok := v.(interface{ ContentType() string }); ok {
+				r.content_type = ct.ContentType()
+			}
+
+		default:
+
+			var buf bytes.Buffer
+
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+
+			err := enc.Encode(v)
+
+			if err != nil {
+				q.run(ctx, logger)
+				return nil, fmt.Errorf("Failed to marshal response, %w", err)
+			}
+
+			buf.Truncate(buf.Len() - 1)
+
+			r.Reader = &buf
+			r.content_type = "application/json"
+		}
+
+		return r, nil
+	}
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambda/messages"
+)
+
+func TestAfterResponseHTTPServer(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := NewServer(ctx, "http://localhost:8085")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	ran := new(atomic.Int32)
+	queued := make(chan struct{})
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		AfterResponse(req.Context(), func(ctx context.Context) {
+
+			close(queued)
+			time.Sleep(250 * time.Millisecond)
+
+			if ctx.Err() == nil {
+				ran.Add(1)
+			}
+		})
+
+		rsp.Write([]byte("ok"))
+	}
+
+	done := make(chan error)
+
+	go func() {
+		done <- s.ListenAndServe(ctx, http.HandlerFunc(handler))
+	}()
+
+	var rsp *http.Response
+
+	for i := 0; i < 50; i++ {
+
+		rsp, err = http.Get("http://localhost:8085")
+
+		if err == nil {
+			break
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if err != nil {
+		t.Fatalf("Failed to GET request, %v", err)
+	}
+
+	body, _ := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+
+	if string(body) != "ok" {
+		t.Fatalf("Unexpected response, %s", body)
+	}
+
+	<-queued
+
+	if ran.Load() != 0 {
+		t.Fatalf("Expected after response function to still be running")
+	}
+
+	// Shutting down the server waits for the after response function to complete
+
+	cancel()
+
+	select {
+	case err := <-done:
+
+		if err != nil {
+			t.Fatalf("Failed to serve, %v", err)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Server did not shut down")
+	}
+
+	if ran.Load() != 1 {
+		t.Fatalf("Expected after response function to complete before shutdown")
+	}
+}
+
+func TestAfterResponseLambda(t *testing.T) {
+
+	ctx := context.Background()
+
+	ran := make([]string, 0)
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		AfterResponse(req.Context(), func(ctx context.Context) {
+
+			ran = append(ran, "first")
+
+			// Functions queued by other functions are run too
+
+			AfterResponse(ctx, func(ctx context.Context) {
+				ran = append(ran, "nested")
+			})
+		})
+
+		AfterResponse(req.Context(), func(ctx context.Context) {
+			panic("oops")
+		})
+
+		AfterResponse(req.Context(), func(ctx context.Context) {
+			ran = append(ran, "second")
+		})
+
+		rsp.Write([]byte("ok"))
+	}
+
+	for _, uri := range []string{"lambda://", "functionurl://", "functionurl://?invoke_mode=response_stream"} {
+
+		ran = make([]string, 0)
+
+		s, err := NewServer(ctx, uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create server %s, %v", uri, err)
+		}
+
+		lambda_handler := s.(LambdaHandlerServer).LambdaHandler(http.HandlerFunc(handler))
+
+		rsp, err := InvokeLambdaEvent(ctx, lambda_handler, []byte(`{"version":"2.0","rawPath":"/","requestContext":{"http":{"method":"GET"}}}`))
+
+		if err != nil {
+			t.Fatalf("Failed to invoke %s, %v", uri, err)
+		}
+
+		if !strings.Contains(string(rsp), `"body": "ok"`) {
+			t.Fatalf("Unexpected response for %s: %s", uri, rsp)
+		}
+
+		if strings.Join(ran, ",") != "first,second,nested" {
+			t.Fatalf("Unexpected after response functions for %s: %v", uri, ran)
+		}
+	}
+}
+
+func TestAfterResponseLambdaRPC(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "lambda://")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	// Under the "go1.x" runtime functions are invoked using RPC and the response is only returned once
+	// the queued functions have been run
+
+	var rpc_rsp messages.InvokeResponse
+	var ran bool
+	var payload_set bool
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		AfterResponse(req.Context(), func(ctx context.Context) {
+			ran = true
+			payload_set = rpc_rsp.Payload != nil
+		})
+
+		rsp.Write([]byte("ok"))
+	}
+
+	lambda_handler := s.(LambdaHandlerServer).LambdaHandler(http.HandlerFunc(handler))
+
+	rpc_req := &messages.InvokeRequest{
+		Payload:   []byte(`{"version":"2.0","rawPath":"/","requestContext":{"http":{"method":"GET"}}}`),
+		RequestId: "test",
+		Deadline: messages.InvokeRequest_Timestamp{
+			Seconds: time.Now().Add(time.Minute).Unix(),
+		},
+	}
+
+	err = lambda.NewFunction(lambda_handler).Invoke(rpc_req, &rpc_rsp)
+
+	if err != nil || rpc_rsp.Error != nil {
+		t.Fatalf("Failed to invoke function, %v %v", err, rpc_rsp.Error)
+	}
+
+	if !strings.Contains(string(rpc_rsp.Payload), `"body":"ok"`) {
+		t.Fatalf("Unexpected response: %s", rpc_rsp.Payload)
+	}
+
+	if !ran || payload_set {
+		t.Fatalf("Expected after response function to run before the response was returned")
+	}
+}
+
+func TestAfterResponseNoQueue(t *testing.T) {
+
+	ran := make(chan struct{})
+
+	AfterResponse(context.Background(), func(ctx context.Context) {
+		close(ran)
+	})
+
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected function to run")
+	}
+}
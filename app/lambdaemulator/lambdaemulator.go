@@ -0,0 +1,51 @@
+// Package lambdaemulator implements the `lambda-emulator` command-line tool, which runs an application's handler locally
+// through the same Lambda event translation path that the `lambda://` and `functionurl://` servers use in production. It
+// has two subcommands:
+//
+//   - `serve` (the default) listens for HTTP requests and translates them in to Lambda events using the `lambdaemulator://` server.
+//   - `replay` sends one or more captured Lambda event JSON files through the handler and prints, or compares against golden
+//     files, the resulting Lambda responses (see `server.ReplayLambdaFixtures`).
+//
+// Applications build their own copy of the tool, so that it serves and replays events through their handlers, by calling
+// `Run` with their handler from a `main` function. For example:
+//
+//	func main() {
+//		ctx := context.Background()
+//		err := lambdaemulator.Run(ctx, myapp.NewHandler())
+//		...
+//	}
+package lambdaemulator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Run runs the `lambda-emulator` tool for 'mux' with the subcommand and flags in `os.Args`.
+func Run(ctx context.Context, mux http.Handler) error {
+	return RunWithArgs(ctx, mux, os.Args[1:])
+}
+
+// RunWithArgs runs the `lambda-emulator` tool for 'mux' with the subcommand and flags in 'args'. If the first
+// argument is not a subcommand the `serve` subcommand is used.
+func RunWithArgs(ctx context.Context, mux http.Handler, args []string) error {
+
+	subcommand := "serve"
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		subcommand = args[0]
+		args = args[1:]
+	}
+
+	switch subcommand {
+	case "serve":
+		return serve(ctx, mux, args)
+	case "replay":
+		return replay(ctx, mux, args)
+	default:
+		return fmt.Errorf("Invalid subcommand '%s'. Valid subcommands are: serve, replay", subcommand)
+	}
+}
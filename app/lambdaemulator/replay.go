@@ -0,0 +1,80 @@
+package lambdaemulator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aaronland/go-http-server/v2"
+	"github.com/sfomuseum/go-flags/flagset"
+)
+
+func replay(ctx context.Context, mux http.Handler, args []string) error {
+
+	var server_uri string
+	var golden_dir string
+	var update bool
+
+	flags := flagset.NewFlagSet("replay")
+
+	flags.StringVar(&server_uri, "server-uri", "lambda://", "A valid aaronland/go-http-server lambda:// or functionurl:// URI.")
+	flags.StringVar(&golden_dir, "golden-dir", "", "The directory containing golden response files. Golden files are named {EVENT}.golden.json where {EVENT} is the name of the event file without its extension. If empty golden files are expected to be in the same directory as their event files.")
+	flags.BoolVar(&update, "update", false, "If true write the responses to their golden files rather than comparing them.")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: lambda-emulator replay [options] event.json [event.json ...]\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	err := flagset.SetFlagsFromEnvVars(flags, "AARONLAND")
+
+	if err != nil {
+		return fmt.Errorf("Failed to set flags from environment variables, %w", err)
+	}
+
+	paths := flags.Args()
+
+	if len(paths) == 0 {
+		flags.Usage()
+		return fmt.Errorf("No event files specified")
+	}
+
+	opts := &server.LambdaReplayOptions{
+		ServerURI: server_uri,
+		GoldenDir: golden_dir,
+		Update:    update,
+	}
+
+	results, err := server.ReplayLambdaFixtures(ctx, mux, paths, opts)
+
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+
+	for _, r := range results {
+
+		switch {
+		case update:
+			fmt.Printf("Updated %s\n", r.GoldenPath)
+		case !r.Golden:
+			fmt.Printf("# %s\n%s", r.Path, r.Response)
+		case r.Matched:
+			fmt.Printf("ok\t%s\n", r.Path)
+		default:
+			failures += 1
+			fmt.Printf("FAIL\t%s\n", r.Path)
+			fmt.Print(r.Diff)
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d responses did not match their golden files", failures, len(paths))
+	}
+
+	return nil
+}
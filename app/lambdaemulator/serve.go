@@ -0,0 +1,64 @@
+package lambdaemulator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/aaronland/go-http-server/v2"
+	"github.com/sfomuseum/go-flags/flagset"
+)
+
+func serve(ctx context.Context, mux http.Handler, args []string) error {
+
+	var address string
+	var server_uri string
+	var event_type string
+
+	fs := flagset.NewFlagSet("serve")
+
+	fs.StringVar(&address, "address", "localhost:8080", "The address and port to listen for HTTP requests on.")
+	fs.StringVar(&server_uri, "server-uri", "lambda://", "A valid aaronland/go-http-server lambda:// or functionurl:// URI.")
+	fs.StringVar(&event_type, "event-type", "", "The type of Lambda event to translate requests in to. Valid options are: apigateway_v1, apigateway_v2, alb (lambda://) and functionurl (functionurl://). If empty the first event type supported by the server is used.")
+
+	fs.Parse(args)
+
+	err := flagset.SetFlagsFromEnvVars(fs, "AARONLAND")
+
+	if err != nil {
+		return fmt.Errorf("Failed to set flags from environment variables, %w", err)
+	}
+
+	q := url.Values{}
+	q.Set("server", server_uri)
+
+	if event_type != "" {
+		q.Set("event", event_type)
+	}
+
+	emulator_u := url.URL{
+		Scheme:   "lambdaemulator",
+		Host:     address,
+		RawQuery: q.Encode(),
+	}
+
+	emulator_uri := emulator_u.String()
+
+	s, err := server.NewServer(ctx, emulator_uri)
+
+	if err != nil {
+		return fmt.Errorf("Unable to create server (%s), %w", emulator_uri, err)
+	}
+
+	log.Printf("Emulating %s on %s", server_uri, s.Address())
+
+	err = s.ListenAndServe(ctx, mux)
+
+	if err != nil {
+		return fmt.Errorf("Failed to start server, %w", err)
+	}
+
+	return nil
+}
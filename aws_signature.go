@@ -0,0 +1,185 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsCredentials are the AWS credentials used to sign requests.
+type awsCredentials struct {
+	access_key_id     string
+	secret_access_key string
+	session_token     string
+}
+
+// awsCredentialsFromEnv returns the AWS credentials defined by the `AWS_ACCESS_KEY_ID`, `AWS_SECRET_ACCESS_KEY` and
+// `AWS_SESSION_TOKEN` environment variables, which the Lambda runtime sets using the function's execution role.
+func awsCredentialsFromEnv() (*awsCredentials, error) {
+
+	creds := &awsCredentials{
+		access_key_id:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret_access_key: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		session_token:     os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if creds.access_key_id == "" || creds.secret_access_key == "" {
+		return nil, errors.New("Missing AWS credentials, the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables must be set")
+	}
+
+	return creds, nil
+}
+
+// signAWSRequest signs 'req', whose body is 'body', for 'service' in 'region' at time 'now' using AWS Signature Version 4
+// and 'creds'. The "Host" header, the "Content-Type" header and any "X-Amz-*" headers are signed.
+func signAWSRequest(req *http.Request, body []byte, creds *awsCredentials, region string, service string, now time.Time) {
+
+	now = now.UTC()
+
+	amz_date := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amz_date)
+
+	if creds.session_token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.session_token)
+	}
+
+	host := req.Host
+
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{
+		"host": host,
+	}
+
+	for k, v := range req.Header {
+
+		k = strings.ToLower(k)
+
+		if k != "content-type" && !strings.HasPrefix(k, "x-amz-") {
+			continue
+		}
+
+		values := make([]string, len(v))
+
+		for idx, str_v := range v {
+			values[idx] = strings.Join(strings.Fields(str_v), " ")
+		}
+
+		headers[k] = strings.Join(values, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+
+	for k := range headers {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	var canonical_headers strings.Builder
+
+	for _, k := range names {
+		canonical_headers.WriteString(k + ":" + headers[k] + "\n")
+	}
+
+	signed_headers := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+
+	if path == "" {
+		path = "/"
+	}
+
+	canonical_request := strings.Join([]string{
+		req.Method,
+		awsURIEncode(path, false),
+		awsCanonicalQuery(req.URL.Query()),
+		canonical_headers.String(),
+		signed_headers,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+
+	string_to_sign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amz_date,
+		scope,
+		sha256Hex([]byte(canonical_request)),
+	}, "\n")
+
+	key := []byte("AWS4" + creds.secret_access_key)
+
+	for _, v := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+
+	signature := hex.EncodeToString(hmacSHA256(key, string_to_sign))
+
+	auth := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.access_key_id, scope, signed_headers, signature)
+	req.Header.Set("Authorization", auth)
+}
+
+// awsCanonicalQuery returns 'q' encoded, and sorted, as the canonical query string of an AWS Signature Version 4 request.
+func awsCanonicalQuery(q url.Values) string {
+
+	pairs := make([]string, 0)
+
+	for k, values := range q {
+
+		for _, v := range values {
+			pairs = append(pairs, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode percent-encodes every byte in 's' other than the unreserved characters defined by RFC 3986 and,
+// if 'encode_slash' is false, "/".
+func awsURIEncode(s string, encode_slash bool) string {
+
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+
+		c := s[i]
+
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encode_slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// sha256Hex returns the hex-encoded SHA-256 hash of 'body'.
+func sha256Hex(body []byte) string {
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of 'data' using 'key'.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignAWSRequest(t *testing.T) {
+
+	// The example request from the AWS Signature Version 4 documentation
+
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+
+	if err != nil {
+		t.Fatalf("Failed to create request, %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	creds := &awsCredentials{
+		access_key_id:     "AKIDEXAMPLE",
+		secret_access_key: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	signAWSRequest(req, nil, creds, "us-east-1", "iam", now)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+
+	if req.Header.Get("Authorization") != expected {
+		t.Fatalf("Unexpected Authorization header: %s", req.Header.Get("Authorization"))
+	}
+
+	if req.Header.Get("X-Amz-Date") != "20150830T123600Z" {
+		t.Fatalf("Unexpected X-Amz-Date header: %s", req.Header.Get("X-Amz-Date"))
+	}
+}
+
+func TestAWSURIEncode(t *testing.T) {
+
+	tests := map[string]string{
+		"/prod/@connections/abc=": "/prod/%40connections/abc%3D",
+		"/a%2Fb":                  "/a%252Fb",
+		"a b~c":                   "a%20b~c",
+	}
+
+	for input, expected := range tests {
+
+		if v := awsURIEncode(input, false); v != expected {
+			t.Fatalf("Unexpected encoding for '%s': %s", input, v)
+		}
+	}
+
+	if v := awsURIEncode("a/b", true); v != "a%2Fb" {
+		t.Fatalf("Unexpected encoding with slashes: %s", v)
+	}
+}
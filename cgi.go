@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/cgi"
+	"net/url"
+)
+
+func init() {
+	ctx := context.Background()
+	RegisterServer(ctx, "cgi", NewCGIServer)
+}
+
+// CGIServer implements the `Server` interface for serving a single request from the
+// Common Gateway Interface (CGI) environment of the current process.
+type CGIServer struct {
+	Server
+	url    *url.URL
+	logger *slog.Logger
+}
+
+// NewCGIServer returns a new `CGIServer` instance configured by 'uri' which is
+// expected to be defined in the form of:
+//
+//	cgi://
+//
+// The server will read the request from the CGI environment variables and standard
+// input of the current process and write the response to standard output. It will
+// exit after serving that request.
+func NewCGIServer(ctx context.Context, uri string) (Server, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	server := CGIServer{
+		url:    u,
+		logger: LoggerFromContext(ctx),
+	}
+
+	return &server, nil
+}
+
+// Address returns the fully-qualified URI used to instantiate 's'.
+func (s *CGIServer) Address() string {
+	return "cgi://"
+}
+
+// ListenAndServe serves the current CGI request using 'mux' for routing.
+func (s *CGIServer) ListenAndServe(ctx context.Context, mux http.Handler) error {
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+		req = req.WithContext(WithLogger(req.Context(), s.logger))
+		mux.ServeHTTP(rsp, req)
+	}
+
+	err := cgi.Serve(http.HandlerFunc(handler))
+
+	if err != nil {
+		return fmt.Errorf("Failed to serve CGI request, %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestCGIServerProcess is not a real test. It is invoked as a CGI program by TestCGIServer.
+func TestCGIServerProcess(t *testing.T) {
+
+	if os.Getenv("GO_WANT_CGI_SERVER") != "1" {
+		return
+	}
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "cgi://")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	err = s.ListenAndServe(ctx, testHandler())
+
+	if err != nil {
+		t.Fatalf("Failed to serve request, %v", err)
+	}
+
+	// Exit now so that the test runner does not append its own output to the response
+	os.Exit(0)
+}
+
+func TestCGIServer(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "cgi://")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	if s.Address() != "cgi://" {
+		t.Fatalf("Unexpected address: %s", s.Address())
+	}
+
+	h := &cgi.Handler{
+		Path: os.Args[0],
+		Args: []string{"-test.run=^TestCGIServerProcess$"},
+		Env:  []string{"GO_WANT_CGI_SERVER=1"},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	rsp := httptest.NewRecorder()
+
+	h.ServeHTTP(rsp, req)
+
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", rsp.Code)
+	}
+
+	if rsp.Body.String() != "Hello world" {
+		t.Fatalf("Unexpected response: '%s'", rsp.Body.String())
+	}
+}
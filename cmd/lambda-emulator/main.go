@@ -0,0 +1,48 @@
+// lambda-emulator is a command-line tool to run a handler locally through the same Lambda event translation
+// path that the `lambda://` and `functionurl://` servers use in production. This version serves a simple
+// "hello world" handler; applications build their own version, which serves and replays events through their
+// own handlers, using the `app/lambdaemulator` package.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/aaronland/go-http-server/v2"
+	"github.com/aaronland/go-http-server/v2/app/lambdaemulator"
+)
+
+func NewHandler() http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		msg := fmt.Sprintf("Hello, %s", req.Host)
+
+		id, ok := server.LambdaIdentityFromContext(req.Context())
+
+		if ok {
+			msg = fmt.Sprintf("%s (%s event, request ID %s)", msg, id.EventType, id.RequestID)
+		}
+
+		rsp.Write([]byte(msg))
+	}
+
+	h := http.HandlerFunc(fn)
+	return h
+}
+
+func main() {
+
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.Handle("/", NewHandler())
+
+	err := lambdaemulator.Run(ctx, mux)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
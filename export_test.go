@@ -0,0 +1,13 @@
+package server
+
+import (
+	"io"
+)
+
+// SetStdioServerStreams replaces the standard input and output of 's', which must be a `StdioServer`, so that
+// tests outside of this package can serve requests over other streams.
+func SetStdioServerStreams(s Server, stdin io.ReadCloser, stdout io.WriteCloser) {
+	stdio_s := s.(*StdioServer)
+	stdio_s.stdin = stdin
+	stdio_s.stdout = stdout
+}
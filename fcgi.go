@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+func init() {
+	ctx := context.Background()
+	RegisterServer(ctx, "fcgi", NewFCGIServer)
+}
+
+// FCGIServer implements the `Server` interface for a FastCGI server.
+type FCGIServer struct {
+	Server
+	url     *url.URL
+	network string
+	address string
+	logger  *slog.Logger
+}
+
+// NewFCGIServer returns a new `FCGIServer` instance configured by 'uri' which is
+// expected to be defined in the form of:
+//
+//	fcgi://{ADDRESS}:{PORT}
+//	fcgi:///{PATH}
+//	fcgi://
+//
+// Where {ADDRESS} and {PORT} are the address and port to listen for FastCGI requests on
+// and {PATH} is the path of a Unix domain socket to listen for FastCGI requests on. If
+// neither are present then the server will accept connections on the listening socket
+// passed to the current process as standard input, which is how most web servers spawn
+// FastCGI applications.
+func NewFCGIServer(ctx context.Context, uri string) (Server, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	server := FCGIServer{
+		url:    u,
+		logger: LoggerFromContext(ctx),
+	}
+
+	switch {
+	case u.Host != "" && u.Path != "":
+		return nil, errors.New("URI must define either a host or a socket path, but not both")
+	case u.Host != "":
+		server.network = "tcp"
+		server.address = u.Host
+	case u.Path != "":
+		server.network = "unix"
+		server.address = u.Path
+	default:
+		// Accept connections from os.Stdin
+	}
+
+	return &server, nil
+}
+
+// Address returns the fully-qualified URI where the server instance can be contacted.
+func (s *FCGIServer) Address() string {
+
+	u, _ := url.Parse(s.url.String())
+	u.RawQuery = ""
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+// ListenAndServe starts the server and listens for FastCGI requests using 'mux' for routing.
+func (s *FCGIServer) ListenAndServe(ctx context.Context, mux http.Handler) error {
+
+	// A nil listener tells fcgi.Serve to accept connections from os.Stdin
+
+	var listener net.Listener
+
+	if s.network != "" {
+
+		l, err := net.Listen(s.network, s.address)
+
+		if err != nil {
+			return fmt.Errorf("Failed to listen on %s, %w", s.Address(), err)
+		}
+
+		listener = l
+	}
+
+	done_ch := make(chan struct{})
+	defer close(done_ch)
+
+	if listener != nil {
+
+		go func() {
+
+			sigint := make(chan os.Signal, 1)
+			signal.Notify(sigint, os.Interrupt)
+			defer signal.Stop(sigint)
+
+			select {
+			case <-sigint:
+			case <-ctx.Done():
+			case <-done_ch:
+				return
+			}
+
+			// We received an interrupt signal, shut down.
+
+			err := listener.Close()
+
+			if err != nil {
+				s.logger.Error("Failed to shut down server", "address", s.Address(), "error", err)
+			}
+		}()
+	}
+
+	// Keep track of in-flight requests so they can be drained during shutdown. Requests on connections that were accepted
+	// before the listener was closed may still arrive after fcgi.Serve returns so they are counted under a lock, and rejected
+	// once draining has started, rather than racing with inflight.Wait.
+
+	inflight := new(sync.WaitGroup)
+	inflight_mu := new(sync.Mutex)
+	draining := false
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		inflight_mu.Lock()
+
+		if draining {
+			inflight_mu.Unlock()
+			http.Error(rsp, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		inflight.Add(1)
+		inflight_mu.Unlock()
+
+		defer inflight.Done()
+
+		req = req.WithContext(WithLogger(req.Context(), s.logger))
+		mux.ServeHTTP(rsp, req)
+	}
+
+	err := fcgi.Serve(listener, http.HandlerFunc(handler))
+
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+
+	inflight_mu.Lock()
+	draining = true
+	inflight_mu.Unlock()
+
+	inflight.Wait()
+	return nil
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// FastCGI record types used by fcgiGet
+const (
+	fcgiBeginRequest uint8 = 1
+	fcgiEndRequest   uint8 = 3
+	fcgiParams       uint8 = 4
+	fcgiStdin        uint8 = 5
+	fcgiStdout       uint8 = 6
+)
+
+func TestFCGIServer(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root, err := os.MkdirTemp("", "fcgi")
+
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, %v", err)
+	}
+
+	defer os.RemoveAll(root)
+
+	sock := filepath.Join(root, "server.sock")
+	uri := fmt.Sprintf("fcgi://%s", sock)
+
+	s, err := NewServer(ctx, uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	if s.Address() != uri {
+		t.Fatalf("Unexpected address: %s", s.Address())
+	}
+
+	done_ch := make(chan error)
+
+	go func() {
+		done_ch <- s.ListenAndServe(ctx, testHandler())
+	}()
+
+	var conn net.Conn
+
+	for i := 0; i < 50; i++ {
+
+		conn, err = net.Dial("unix", sock)
+
+		if err == nil {
+			break
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if err != nil {
+		t.Fatalf("Failed to connect to server, %v", err)
+	}
+
+	defer conn.Close()
+
+	headers, body, err := fcgiGet(conn, "/")
+
+	if err != nil {
+		t.Fatalf("Failed to request document, %v", err)
+	}
+
+	if headers.Get("Status") != "" && headers.Get("Status") != "200 OK" {
+		t.Fatalf("Unexpected status: %s", headers.Get("Status"))
+	}
+
+	if string(body) != "Hello world" {
+		t.Fatalf("Unexpected response: '%s'", string(body))
+	}
+
+	cancel()
+
+	select {
+	case err := <-done_ch:
+
+		if err != nil {
+			t.Fatalf("Server returned an error, %v", err)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for server to shut down")
+	}
+}
+
+func TestFCGIServerAddress(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "fcgi://localhost:9000?foo=bar")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	if s.Address() != "fcgi://localhost:9000" {
+		t.Fatalf("Unexpected address: %s", s.Address())
+	}
+}
+
+// fcgiGet is a minimal FastCGI client which issues a single GET request for 'path' over 'conn'.
+func fcgiGet(conn net.Conn, path string) (textproto.MIMEHeader, []byte, error) {
+
+	var req_id uint16 = 1
+
+	write := func(rec_type uint8, content []byte) error {
+
+		header := []byte{1, rec_type, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], req_id)
+		binary.BigEndian.PutUint16(header[4:], uint16(len(content)))
+
+		_, err := conn.Write(append(header, content...))
+		return err
+	}
+
+	// Role 1 is "responder"; flags 0 means close the connection when done
+	begin := []byte{0, 1, 0, 0, 0, 0, 0, 0}
+
+	err := write(fcgiBeginRequest, begin)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params := map[string]string{
+		"REQUEST_METHOD":  "GET",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+		"REQUEST_URI":     path,
+		"HTTP_HOST":       "localhost",
+	}
+
+	var buf bytes.Buffer
+
+	for k, v := range params {
+		// All of the names and values above are shorter than 128 bytes
+		buf.WriteByte(byte(len(k)))
+		buf.WriteByte(byte(len(v)))
+		buf.WriteString(k)
+		buf.WriteString(v)
+	}
+
+	for _, rec := range []struct {
+		rec_type uint8
+		content  []byte
+	}{
+		{fcgiParams, buf.Bytes()},
+		{fcgiParams, nil},
+		{fcgiStdin, nil},
+	} {
+
+		err := write(rec.rec_type, rec.content)
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var stdout bytes.Buffer
+
+	for {
+
+		header := make([]byte, 8)
+
+		_, err := io.ReadFull(conn, header)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		content_len := binary.BigEndian.Uint16(header[4:])
+		padding_len := header[6]
+
+		content := make([]byte, int(content_len)+int(padding_len))
+
+		_, err = io.ReadFull(conn, content)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if header[1] == fcgiStdout {
+			stdout.Write(content[:content_len])
+		}
+
+		if header[1] == fcgiEndRequest {
+			break
+		}
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(&stdout))
+
+	headers, err := tp.ReadMIMEHeader()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err := io.ReadAll(tp.R)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return headers, body, nil
+}
@@ -5,6 +5,7 @@ go 1.23.3
 require (
 	github.com/aaronland/go-roster v1.0.0
 	github.com/akrylysov/algnhsa v1.1.0
+	github.com/andybalholm/brotli v1.2.6
 	github.com/aws/aws-lambda-go v1.47.0
 	github.com/sfomuseum/go-flags v0.10.0
 )
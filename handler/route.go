@@ -1,44 +1,22 @@
 package handler
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
-	"regexp"
-	"sort"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
-)
-
-// Regular expression to match "{label}" style substitutions in URL patterns
-var re_braces = regexp.MustCompile(`\{([^\}]+)\}`)
-
-// Regular expression to match Go 1.22 "[METHOD] [HOST]/[PATH]" URL definitions. This is probably
-// not as robust as it could be.
-var re_route = regexp.MustCompile(`^(?:(?:(GET|POST|PUT|HEAD|OPTION|DELETE)\s)?([^\/]+)?)?(.*)$`)
-
-var re_mutex = new(sync.RWMutex)
-
-// Regular expression to replace "{label}" style strings with "([^\/]+)".
-func not_a_slash(s string) string {
-	return fmt.Sprintf(`([^\/]+)`)
-}
+	"time"
 
-// Local cache of wildcard regular expressions that have been derived from patterns with "{label}" style substitutions
-var wildcard_matches = make(map[string]*regexp.Regexp)
-
-// Local struct for return key value pairs that have been derived from URL with "{label}" style substitutions
-// and their subsequent wildcard matches
-type pathValue struct {
-	Key   string
-	Value string
-}
-
-// String returns the key and value pair in a pathValue as a "key=value" formatted string.
-func (pv *pathValue) String() string {
-	return fmt.Sprintf("%s=%s", pv.Key, pv.Value)
-}
+	"github.com/aaronland/go-http-server/v2"
+)
 
 // RouteHandlerFunc returns an `http.Handler` instance.
 type RouteHandlerFunc func(context.Context) (http.Handler, error)
@@ -47,8 +25,56 @@ type RouteHandlerFunc func(context.Context) (http.Handler, error)
 // for use the RouteHandlerWithOptions method.
 type RouteHandlerOptions struct {
 	// Handlers is a map whose keys are `http.ServeMux` style routing patterns and whose keys
-	// are functions that when invoked return `http.Handler` instances.
+	// are functions that when invoked return `http.Handler` instances. Patterns support "{name}", "{name...}" and "{$}"
+	// wildcards, methods (with "GET" patterns also matching "HEAD" requests) and hosts, and requests are routed as they
+	// would be by `http.ServeMux`: the most specific pattern wins, unclean paths and paths missing a trailing slash are
+	// redirected and requests matching a pattern with a different method receive a "405 Method Not Allowed" response.
+	// Wildcard values are available using the request's `PathValue` method. Invalid or conflicting patterns cause
+	// `RouteHandlerWithOptions` to return an error listing all of them.
 	Handlers map[string]RouteHandlerFunc
+	// Logger is an optional `*slog.Logger` instance used to log routing decisions and errors. If nil the logger
+	// associated with each request's context (see `server.LoggerFromContext`) is used.
+	Logger *slog.Logger
+	// InitRoutes is an optional list of patterns (keys in `Handlers`) whose handlers are initialized when the route handler
+	// is created, for example during the AWS Lambda INIT phase, rather than when they are first requested. An error initializing
+	// any of these handlers is returned by `RouteHandlerWithOptions`.
+	InitRoutes []string
+	// WarmRoutes is an optional list of patterns (keys in `Handlers`) whose handlers are initialized when the route handler is
+	// "warmed" (see `server.Warmer`), for example by the "lambda://" and "functionurl://" servers in response to a warm-up ping.
+	// Route handlers which are mounted inside another handler must be registered using `server.WithWarmers` to be warmed.
+	WarmRoutes []string
+	// InitTimeout is the maximum amount of time a `RouteHandlerFunc` has to initialize a handler. Handler functions are passed a
+	// context which is cancelled when it elapses, rather than when the request which triggered them is. Only one handler function
+	// is invoked at a time for any given pattern; concurrent requests wait for it to complete. Default is `ROUTE_INIT_TIMEOUT`.
+	InitTimeout time.Duration
+	// InitBackoff is the amount of time to wait before invoking a `RouteHandlerFunc` again after it has failed. It doubles with each
+	// consecutive failure. In the meantime requests for the pattern receive a "503 Service Unavailable" response with a "Retry-After"
+	// header. Default is `ROUTE_INIT_BACKOFF`.
+	InitBackoff time.Duration
+	// InitMaxBackoff is the maximum amount of time to wait before invoking a `RouteHandlerFunc` again after it has failed. Default
+	// is `ROUTE_INIT_MAX_BACKOFF`.
+	InitMaxBackoff time.Duration
+	// IdleTTL is the optional amount of time after which a handler that has not been requested is evicted from the cache of
+	// initialized handlers. If 0 handlers are never evicted for being idle. Otherwise a goroutine checks for idle handlers
+	// every IdleTTL/2, whether or not any requests are being served, until the handler returned by `RouteHandlerWithOptions`,
+	// which implements the `io.Closer` interface, is closed. The time a handler was last requested is recorded to within a
+	// second, or a quarter of IdleTTL if that is shorter, so handlers which are being requested are never considered idle.
+	IdleTTL time.Duration
+	// MaxHandlers is the optional maximum number of initialized handlers to cache. When it is exceeded the least recently
+	// requested handler, to within a second (or a quarter of IdleTTL if that is shorter), is evicted. If 0 there is no limit. Evicted handlers which implement the
+	// `io.Closer` interface are closed once any requests that are using them have completed.
+	MaxHandlers int
+}
+
+// RouteReloader is an interface for handlers created by `RouteHandlerWithOptions` whose cached handlers can be discarded, so
+// that they are initialized again the next time they are requested. As with evicted handlers, discarded handlers which implement
+// the `io.Closer` interface are closed once any requests that are using them have completed.
+type RouteReloader interface {
+	// Reload discards the cached handler for a pattern, if it has been initialized, and clears any backoff from previous
+	// failures to initialize it.
+	Reload(string) error
+	// ReloadAll discards all the cached handlers and clears any backoff from previous failures to initialize them.
+	ReloadAll()
 }
 
 // RouteHandler create a new `http.Handler` instance that will serve requests using handlers defined in 'handlers'.
@@ -74,233 +100,458 @@ func RouteHandler(handlers map[string]RouteHandlerFunc) (http.Handler, error) {
 //  2. You don't want to refactor in to (n) atomic Lambda functions. That is you want to be able to re-use the same
 //     code in both a plain-vanilla HTTP server configuration as well as Lambda + API Gateway configuration.
 //
-// URL patterns for handlers (passed in the `RouteHandlerOptions` struct) are parsed using a custom implementation
-// of Go 1.22's "URL pattern matching". While this implementation has been tested on common patterns it is likely that
-// there are still edge-cases, or simply sufficiently sophisticated cases, that will not match. This is considered a
-// "known known" and those cases will need to be addressed as they arise. This custom implementation uses regular
-// expressions and has not been benchmarked against the Go 1.22 implementation. I would prefer to use the native
-// implementation but it is a) code that is private to [net/http] and sufficiently involved that cloning it in to this
-// package, and then tracking the changes, seems like a bad idea.
+// URL patterns for handlers are parsed, and requests are matched against them, using the same rules as Go 1.22's
+// `http.ServeMux`; see the `RouteHandlerOptions` fields for details of routing and of how handlers are initialized, cached
+// and evicted. The handler returned implements the `server.Warmer`, `RouteReloader` and `io.Closer` interfaces.
 func RouteHandlerWithOptions(opts *RouteHandlerOptions) (http.Handler, error) {
 
-	matches := new(sync.Map)
-	patterns := make([]string, 0)
+	// Patterns are parsed, and checked for conflicts, in sorted order so that errors are stable
+
+	patterns := make([]*routePattern, 0)
+	errs := make([]error, 0)
+
+	for _, str_p := range slices.Sorted(maps.Keys(opts.Handlers)) {
+
+		p, err := parseRoutePattern(str_p)
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Invalid route pattern '%s', %w", str_p, err))
+			continue
+		}
+
+		for _, other := range patterns {
+
+			if other.conflictsWith(p) {
+				errs = append(errs, fmt.Errorf("Route pattern '%s' conflicts with '%s', %s", other, p, other.describeConflict(p)))
+			}
+		}
 
-	for p, _ := range opts.Handlers {
 		patterns = append(patterns, p)
 	}
 
-	// Sort longest to shortest
-	// https://cs.opensource.google/go/go/+/refs/tags/go1.20.4:src/net/http/server.go;l=2533
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
 
-	sort.Slice(patterns, func(i, j int) bool {
-		return len(patterns[i]) > len(patterns[j])
-	})
+	for _, p := range append(opts.InitRoutes, opts.WarmRoutes...) {
+
+		_, ok := opts.Handlers[p]
+
+		if !ok {
+			return nil, fmt.Errorf("Invalid route '%s', no handler defined for pattern", p)
+		}
+	}
+
+	// Each pattern gets its own entry up front so that looking up, and caching, initialized handlers never
+	// modifies the map and it can be read without any locking
 
-	fn := func(rsp http.ResponseWriter, req *http.Request) {
+	// The time each handler was last requested only needs to be precise enough that handlers which are still being
+	// requested are never mistaken for idle ones
 
-		logger := slog.Default()
-		logger = logger.With("path", req.URL.Path)
+	touch_interval := time.Second
 
-		derive_rsp, err := deriveHandler(req, opts.Handlers, matches, patterns)
+	if opts.IdleTTL > 0 {
+		touch_interval = min(touch_interval, opts.IdleTTL/4)
+	}
+
+	entries := make(map[string]*routeEntry)
+
+	for str_p, handler_func := range opts.Handlers {
+		entries[str_p] = newRouteEntry(str_p, handler_func, touch_interval)
+	}
+
+	init_opts := &routeInitOptions{
+		timeout:     ROUTE_INIT_TIMEOUT,
+		backoff:     ROUTE_INIT_BACKOFF,
+		max_backoff: ROUTE_INIT_MAX_BACKOFF,
+	}
+
+	if opts.InitTimeout > 0 {
+		init_opts.timeout = opts.InitTimeout
+	}
+
+	if opts.InitBackoff > 0 {
+		init_opts.backoff = opts.InitBackoff
+	}
+
+	if opts.InitMaxBackoff > 0 {
+		init_opts.max_backoff = opts.InitMaxBackoff
+	}
+
+	init_opts.max_backoff = max(init_opts.backoff, init_opts.max_backoff)
+
+	h := &routeHandler{
+		entries:      entries,
+		tree:         newRouteTree(patterns),
+		init_opts:    init_opts,
+		idle_ttl:     opts.IdleTTL,
+		max_handlers: opts.MaxHandlers,
+		logger:       opts.Logger,
+		warm:         opts.WarmRoutes,
+	}
+
+	if len(opts.InitRoutes) > 0 {
+
+		err := h.initRoutes(context.Background(), opts.InitRoutes)
 
 		if err != nil {
-			logger.Error("Failed to derive handler", "error", err)
-			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
-			return
+			return nil, err
 		}
+	}
 
-		if derive_rsp == nil {
-			logger.Debug("Route handler not found")
-			http.Error(rsp, "Not found", http.StatusNotFound)
-			return
-		}
+	if h.idle_ttl > 0 {
+		h.stop_ch = make(chan struct{})
+		go h.sweepIdle(h.idle_ttl / 2)
+	}
 
-		if derive_rsp.Method != "" && derive_rsp.Method != req.Method {
-			logger.Debug("Invalid method for route handler", "method", req.Method, "require", derive_rsp.Method)
-			http.Error(rsp, "Method not allow", http.StatusMethodNotAllowed)
-			return
-		}
+	return h, nil
+}
 
-		if derive_rsp.Host != "" && derive_rsp.Host != req.Host {
-			logger.Debug("Invalid host for route handler", "host", req.Host, "require", derive_rsp.Host)
-			http.Error(rsp, "Not found", http.StatusNotFound)
-			return
-		}
+// routeHandler implements the `http.Handler`, `io.Closer`, `server.Warmer` and `RouteReloader` interfaces for handlers created by `RouteHandlerWithOptions`.
+type routeHandler struct {
+	entries      map[string]*routeEntry
+	tree         *routeNode
+	init_opts    *routeInitOptions
+	idle_ttl     time.Duration
+	max_handlers int
+	// stop_ch is closed to stop the goroutine that evicts idle handlers.
+	stop_ch   chan struct{}
+	stop_once sync.Once
+	// evict_mu ensures that only one goroutine at a time evicts handlers to enforce the `MaxHandlers` option.
+	evict_mu sync.Mutex
+	logger   *slog.Logger
+	warm     []string
+}
 
-		if derive_rsp.PathValues != nil {
+// ServeHTTP serves 'req' using the handler whose pattern matches it, initializing that handler if necessary.
+func (h *routeHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
 
-			for _, pv := range derive_rsp.PathValues {
-				req.SetPathValue(pv.Key, pv.Value)
-			}
+	if req.RequestURI == "*" {
+
+		if req.ProtoAtLeast(1, 1) {
+			rsp.Header().Set("Connection", "close")
 		}
 
-		derive_rsp.Handler.ServeHTTP(rsp, req)
+		rsp.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	return http.HandlerFunc(fn), nil
-}
+	// The request logger is only derived when something needs to be logged so that successful requests don't allocate one
 
-type deriveHandlerResults struct {
-	MatchingPattern string
-	Handler         http.Handler
-	Method          string
-	Host            string
-	PathValues      []*pathValue
-}
+	logger := func() *slog.Logger {
+		return h.requestLogger(req.Context()).With("method", req.Method, "path", req.URL.Path)
+	}
+
+	p, values, redirect, allow := h.findPattern(req)
 
-func deriveHandler(req *http.Request, handlers map[string]RouteHandlerFunc, matches *sync.Map, patterns []string) (*deriveHandlerResults, error) {
+	if redirect != nil {
+		logger().Debug("Redirect for route handler", "location", redirect.String())
+		http.Redirect(rsp, req, redirect.String(), http.StatusTemporaryRedirect)
+		return
+	}
 
-	ctx := req.Context()
-	path := req.URL.Path
+	if p == nil && len(allow) > 0 {
+		logger().Debug("Invalid method for route handler", "allow", allow)
+		rsp.Header().Set("Allow", strings.Join(allow, ", "))
+		http.Error(rsp, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
 
-	// Basically do what the default http.ServeMux does but inflate the
-	// handler (func) on demand at run-time. Handler is cached above.
-	// https://cs.opensource.google/go/go/+/refs/tags/go1.20.4:src/net/http/server.go;l=2363
+	if p == nil {
+		logger().Debug("Route handler not found")
+		http.NotFound(rsp, req)
+		return
+	}
 
-	// That was before Go 1.22 's pattern routing which makes everything more complicated. What
-	// follows is less complicated (or at least less twisty) than the net/http code which is all
-	// private and internal and too much to clone in to this package. What follows may still contain
-	// gotchas.
-	// https://cs.opensource.google/go/go/+/refs/tags/go1.22.0:src/net/http/server.go;l=2320
+	ref, _, err := h.initHandler(req.Context(), p.str)
 
-	var matching_pattern string
-	var host string
-	var method string
+	if err != nil {
 
-	var path_values []*pathValue
+		logger().Error("Failed to derive handler", "pattern", p.str, "error", err)
 
-	for _, p := range patterns {
+		var init_err *RouteInitError
 
-		// slog.Info("ROUTE", "pattern", p)
+		if errors.As(err, &init_err) {
+			rsp.Header().Set("Retry-After", strconv.Itoa(init_err.RetryAfter()))
+		}
 
-		// First just try the simple prefix-based approach
+		http.Error(rsp, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
 
-		if strings.HasPrefix(path, p) {
-			matching_pattern = p
-			break
+	defer ref.release()
+
+	req.Pattern = p.str
+
+	i := 0
+
+	for _, seg := range p.segments {
+
+		if seg.wild && seg.s != "" {
+			req.SetPathValue(seg.s, values[i])
+			i += 1
 		}
+	}
 
-		// Next try to parse out [METHOD] [HOST]/[PATH]
+	ref.handler.ServeHTTP(rsp, req)
+}
 
-		route_m := re_route.FindStringSubmatch(p)
+// findPattern returns the pattern which matches 'req' and the values of its wildcards. If 'req' should be redirected,
+// because its path is not clean or because it is missing a trailing slash, it returns the URL to redirect to instead.
+// If no pattern matches 'req' it returns the (sorted) list of methods, if any, that would have matched.
+func (h *routeHandler) findPattern(req *http.Request) (*routePattern, []string, *url.URL, []string) {
 
-		if len(route_m) == 0 {
-			continue
+	var p *routePattern
+	var values []string
+	var redirect *url.URL
+
+	host := req.URL.Host
+	escaped_path := req.URL.EscapedPath()
+	clean_path := escaped_path
+
+	if req.Method == http.MethodConnect {
+
+		// CONNECT requests are not canonicalized but are still redirected if they are missing a trailing slash
+
+		_, _, redirect = h.matchOrRedirect(host, req.Method, escaped_path, req.URL)
+
+		if redirect != nil {
+			return nil, nil, redirect, nil
 		}
 
-		method = route_m[1]
-		host = route_m[2]
-		route_path := route_m[3]
+		host = req.Host
+		p, values, _ = h.matchOrRedirect(host, req.Method, escaped_path, nil)
 
-		// slog.Info("ROUTE", "path", route_path)
+	} else {
 
-		if !re_braces.MatchString(route_path) {
+		host = stripRouteHostPort(req.Host)
+		clean_path = cleanRoutePath(escaped_path)
 
-			if strings.HasPrefix(path, route_path) {
-				matching_pattern = p
-				break
-			} else {
-				continue
-			}
+		p, values, redirect = h.matchOrRedirect(host, req.Method, clean_path, req.URL)
+
+		if redirect != nil {
+			return nil, nil, redirect, nil
 		}
 
-		// If there are replace them with a match-up-to-next-forward-slash capture
-		// and then use the result to build a new regular expression
+		if clean_path != escaped_path {
+			return nil, nil, routeURL(clean_path, req.URL.RawQuery), nil
+		}
+	}
 
-		re_mutex.Lock()
+	if p == nil {
+		return nil, nil, nil, h.tree.allowedMethods(host, clean_path)
+	}
 
-		re_wildcard, exists := wildcard_matches[route_path]
+	return p, values, nil, nil
+}
 
-		if !exists {
+// matchOrRedirect returns the pattern with the highest precedence which matches 'host', 'method' and 'escaped_path'
+// and the values of its wildcards. If 'u' is not nil and there is no exact match for 'escaped_path' but there is for
+// 'escaped_path' with a trailing slash it returns the URL to redirect to.
+func (h *routeHandler) matchOrRedirect(host string, method string, escaped_path string, u *url.URL) (*routePattern, []string, *url.URL) {
 
-			str_wildcard := re_braces.ReplaceAllStringFunc(route_path, not_a_slash)
-			re, err := regexp.Compile(str_wildcard)
+	p, values := h.tree.match(host, method, escaped_path)
 
-			if err != nil {
-				re_mutex.Unlock()
-				return nil, fmt.Errorf("Failed to compile wildcard regexp, %w", err)
-			}
+	if (p == nil || !p.exactMatch(escaped_path)) && u != nil && escaped_path != "" && !strings.HasSuffix(escaped_path, "/") {
 
-			re_wildcard = re
-			wildcard_matches[route_path] = re_wildcard
+		slash_path := escaped_path + "/"
+		slash_p, _ := h.tree.match(host, method, slash_path)
+
+		if slash_p != nil && slash_p.exactMatch(slash_path) {
+			return nil, nil, routeURL(slash_path, u.RawQuery)
 		}
+	}
 
-		re_mutex.Unlock()
+	return p, values, nil
+}
 
-		// Does the current path (like the actual request being processed) match the wildcard?
+// Warm initializes the handlers for the patterns listed in the `WarmRoutes` option, if they have not already been
+// initialized. It is invoked by the "lambda://" and "functionurl://" servers in response to warm-up pings.
+func (h *routeHandler) Warm(ctx context.Context) error {
+	return h.initRoutes(ctx, h.warm)
+}
 
-		path_m := re_wildcard.FindStringSubmatch(path)
+// initRoutes initializes the handlers for 'patterns', logging how long each one took.
+func (h *routeHandler) initRoutes(ctx context.Context, patterns []string) error {
 
-		if len(path_m) == 0 {
-			continue
+	logger := h.requestLogger(ctx)
+
+	for _, p := range patterns {
+
+		t1 := time.Now()
+
+		ref, initialized, err := h.initHandler(ctx, p)
+
+		if err != nil {
+			logger.Error("Failed to initialize route handler", "pattern", p, "error", err)
+			return err
 		}
 
-		// If it does extract all the curly-substitution braces and then use the two matches
-		// to build a set of key,value pairs to populate the request's PathValue lookup table
+		ref.release()
 
-		key_m := re_braces.FindAllStringSubmatch(route_path, -1)
+		if initialized {
+			logger.Info("Initialized route handler", "pattern", p, "duration", time.Since(t1))
+		}
+	}
 
-		count_k := len(key_m)
-		path_values = make([]*pathValue, count_k)
+	return nil
+}
 
-		for i := 0; i < count_k; i++ {
+// requestLogger returns the logger configured by the `Logger` option or, if nil, the logger associated with 'ctx'.
+func (h *routeHandler) requestLogger(ctx context.Context) *slog.Logger {
 
-			key := key_m[i][1]
-			value := path_m[i+1]
+	if h.logger != nil {
+		return h.logger
+	}
 
-			pv := &pathValue{
-				Key:   key,
-				Value: value,
-			}
+	return server.LoggerFromContext(ctx)
+}
+
+// initHandler returns the (cached) handler for 'pattern', invoking its `RouteHandlerFunc` if it has not already been
+// initialized. Callers must invoke the `release` method of the handler returned when they are done with it. The boolean
+// return value is true if the handler was initialized by this call. It also evicts the least recently requested handlers
+// that exceed the `MaxHandlers` option.
+func (h *routeHandler) initHandler(ctx context.Context, pattern string) (*routeHandlerRef, bool, error) {
+
+	e, ok := h.entries[pattern]
+
+	if !ok {
+		return nil, false, fmt.Errorf("Invalid route '%s', no handler defined for pattern", pattern)
+	}
+
+	ref, initialized, err := e.get(ctx, h.init_opts, h.requestLogger(ctx))
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now().UnixNano()
+	e.touch(now)
+
+	if initialized && h.max_handlers > 0 {
+		h.evictLeastRecentlyUsed(e)
+	}
 
-			path_values[i] = pv
+	return ref, initialized, nil
+}
+
+// sweepIdle evicts handlers that have been idle for longer than the `IdleTTL` option every 'interval' until 'h' is closed.
+func (h *routeHandler) sweepIdle(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop_ch:
+			return
+		case now := <-ticker.C:
+			h.evictIdle(now.Add(-h.idle_ttl).UnixNano())
 		}
+	}
+}
+
+// Close stops the goroutine which evicts idle handlers, if the `IdleTTL` option was set, and discards all the cached
+// handlers, closing those that implement the `io.Closer` interface once any requests using them have completed.
+func (h *routeHandler) Close() error {
 
-		matching_pattern = p
-		break
+	if h.stop_ch != nil {
+		h.stop_once.Do(func() {
+			close(h.stop_ch)
+		})
 	}
 
-	if matching_pattern == "" {
-		return nil, nil
+	h.ReloadAll()
+	return nil
+}
+
+// evictIdle evicts the handlers which have not been requested since 'idle_since' (Unix nanoseconds).
+func (h *routeHandler) evictIdle(idle_since int64) {
+
+	logger := h.requestLogger(context.Background())
+
+	for pattern, e := range h.entries {
+
+		if e.evict(idle_since) {
+			logger.Debug("Evicted idle route handler", "pattern", pattern)
+		}
 	}
+}
 
-	// slog.Info("ROUTE", "path", path, "matching_pattern", matching_pattern, "pv", path_values)
+// evictLeastRecentlyUsed evicts the least recently requested handlers, other than 'keep', until there are no more
+// than the `MaxHandlers` option.
+func (h *routeHandler) evictLeastRecentlyUsed(keep *routeEntry) {
 
-	var handler http.Handler
+	h.evict_mu.Lock()
+	defer h.evict_mu.Unlock()
 
-	v, exists := matches.Load(matching_pattern)
+	logger := h.requestLogger(context.Background())
 
-	if exists {
-		handler = v.(http.Handler)
-	} else {
+	initialized := make([]*routeEntry, 0)
 
-		handler_func, ok := handlers[matching_pattern]
+	for _, e := range h.entries {
 
-		// Don't fill up the matches cache with 404 handlers
+		if e.initialized() {
+			initialized = append(initialized, e)
+		}
+	}
 
-		if !ok {
-			return nil, nil
+	if len(initialized) <= h.max_handlers {
+		return
+	}
+
+	slices.SortFunc(initialized, func(a *routeEntry, b *routeEntry) int {
+		return cmp.Compare(a.last_used.Load(), b.last_used.Load())
+	})
+
+	count := len(initialized) - h.max_handlers
+
+	for _, e := range initialized {
+
+		if count == 0 {
+			break
 		}
 
-		h, err := handler_func(ctx)
+		if e == keep {
+			continue
+		}
 
-		if err != nil {
-			return nil, fmt.Errorf("Failed to instantiate handler func for '%s' matching '%s', %v", path, matching_pattern, err)
+		if e.evict(0) {
+			logger.Debug("Evicted least recently used route handler", "pattern", e.pattern)
+			count -= 1
 		}
+	}
+}
+
+// Reload discards the cached handler for 'pattern', if it has been initialized, and clears any backoff from previous
+// failures to initialize it so that it is initialized again the next time it is requested. The discarded handler is
+// closed, if it implements the `io.Closer` interface, once any requests using it have completed. An initialization
+// which is already in progress when this method is invoked is not affected.
+func (h *routeHandler) Reload(pattern string) error {
+
+	e, ok := h.entries[pattern]
 
-		handler = h
-		matches.Store(matching_pattern, handler)
+	if !ok {
+		return fmt.Errorf("Invalid route '%s', no handler defined for pattern", pattern)
 	}
 
-	rsp := &deriveHandlerResults{
-		MatchingPattern: matching_pattern,
-		Handler:         handler,
-		PathValues:      path_values,
-		Method:          method,
-		Host:            host,
+	if e.reload() {
+		h.requestLogger(context.Background()).Debug("Reloaded route handler", "pattern", pattern)
 	}
 
-	return rsp, nil
+	return nil
+}
+
+// ReloadAll discards all the cached handlers, and clears any backoff from previous failures to initialize them, as
+// described by `Reload`.
+func (h *routeHandler) ReloadAll() {
+
+	logger := h.requestLogger(context.Background())
+
+	for pattern, e := range h.entries {
+
+		if e.reload() {
+			logger.Debug("Reloaded route handler", "pattern", pattern)
+		}
+	}
 }
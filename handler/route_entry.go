@@ -0,0 +1,400 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The default amount of time a `RouteHandlerFunc` has to initialize a handler.
+const ROUTE_INIT_TIMEOUT time.Duration = 30 * time.Second
+
+// The default amount of time to wait before retrying a `RouteHandlerFunc` that has failed once.
+const ROUTE_INIT_BACKOFF time.Duration = 1 * time.Second
+
+// The default maximum amount of time to wait before retrying a `RouteHandlerFunc` that has failed repeatedly.
+const ROUTE_INIT_MAX_BACKOFF time.Duration = 1 * time.Minute
+
+// routeEntry is the `RouteHandlerFunc` for a pattern and the handler it returned, once it has been invoked. Initialized
+// handlers can be read without taking any locks; everything else is guarded by 'mu'.
+type routeEntry struct {
+	pattern      string
+	handler_func RouteHandlerFunc
+	ref          atomic.Pointer[routeHandlerRef]
+	// last_used is the time, in Unix nanoseconds, that the handler was last requested.
+	last_used atomic.Int64
+	// touch_interval is the amount of time 'last_used' must have changed by before it is updated.
+	touch_interval time.Duration
+	mu             sync.Mutex
+	// pending is the initialization currently in progress, if any.
+	pending *routeInit
+	// failures is the number of consecutive times 'handler_func' has failed.
+	failures int
+	// last_err is the error returned the last time 'handler_func' failed.
+	last_err error
+	// retry_at is the time after which 'handler_func' may be invoked again after failing.
+	retry_at time.Time
+}
+
+// routeHandlerRef is a handler returned by a `RouteHandlerFunc` and the number of requests currently using it. Once it has been
+// evicted from its `routeEntry`, and is no longer in use, it is closed if it implements the `io.Closer` interface.
+type routeHandlerRef struct {
+	handler http.Handler
+	logger  *slog.Logger
+	active  atomic.Int64
+	evicted atomic.Bool
+	closed  atomic.Bool
+}
+
+// routeInit is a single invocation of a `RouteHandlerFunc` which any number of requests may be waiting on.
+type routeInit struct {
+	done chan struct{}
+	ref  *routeHandlerRef
+	err  error
+}
+
+// routeInitOptions are the settings that control how `routeEntry` instances are initialized.
+type routeInitOptions struct {
+	timeout     time.Duration
+	backoff     time.Duration
+	max_backoff time.Duration
+}
+
+// RouteInitError is the error returned when the handler for a pattern could not be initialized, either because its
+// `RouteHandlerFunc` failed or because it failed recently and will not be retried until `RetryAt`.
+type RouteInitError struct {
+	// Pattern is the pattern whose handler could not be initialized.
+	Pattern string
+	// RetryAt is the time after which initializing the handler will be attempted again.
+	RetryAt time.Time
+	// Err is the error returned by the `RouteHandlerFunc`.
+	Err error
+}
+
+// Error returns the string representation of 'e'.
+func (e *RouteInitError) Error() string {
+	return fmt.Sprintf("Failed to initialize handler for '%s', %v", e.Pattern, e.Err)
+}
+
+// Unwrap returns the error returned by the `RouteHandlerFunc`.
+func (e *RouteInitError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter returns the number of whole seconds, rounded up and at least one, until initializing the handler will be attempted again.
+func (e *RouteInitError) RetryAfter() int {
+	return max(1, int(math.Ceil(time.Until(e.RetryAt).Seconds())))
+}
+
+// newRouteEntry returns a new `routeEntry` instance for 'pattern' and 'handler_func' whose last used time is updated
+// at most every 'touch_interval'.
+func newRouteEntry(pattern string, handler_func RouteHandlerFunc, touch_interval time.Duration) *routeEntry {
+
+	e := &routeEntry{
+		pattern:        pattern,
+		handler_func:   handler_func,
+		touch_interval: touch_interval,
+	}
+
+	return e
+}
+
+// get returns the handler for 'e', invoking its `RouteHandlerFunc` if it has not already been initialized. The handler is
+// marked as being in use and callers must invoke its `release` method when they are done with it. The boolean return value
+// is true if the handler was initialized by this call.
+//
+// Only one invocation of the `RouteHandlerFunc` is ever in progress; concurrent calls wait for it to complete, or for
+// 'ctx' to be cancelled. The `RouteHandlerFunc` is passed a context which carries the values of 'ctx' but which is not
+// cancelled when 'ctx' is, so that a client disconnecting does not abort initialization for everyone else, and which
+// times out after 'opts.timeout'. If it fails it is not invoked again until a backoff period, which starts at
+// 'opts.backoff' and doubles with each consecutive failure up to 'opts.max_backoff', has elapsed. In the meantime
+// a `RouteInitError` is returned immediately.
+func (e *routeEntry) get(ctx context.Context, opts *routeInitOptions, logger *slog.Logger) (*routeHandlerRef, bool, error) {
+
+	// A handler can only fail to be acquired if it has just been evicted, in which case the next attempt will find either
+	// no handler, and initialize a new one, or the handler that replaced it.
+
+	for {
+
+		ref := e.ref.Load()
+
+		if ref != nil {
+
+			if ref.acquire() {
+				return ref, false, nil
+			}
+
+			continue
+		}
+
+		e.mu.Lock()
+
+		if e.ref.Load() != nil {
+			e.mu.Unlock()
+			continue
+		}
+
+		if e.pending == nil && time.Now().Before(e.retry_at) {
+
+			err := &RouteInitError{
+				Pattern: e.pattern,
+				RetryAt: e.retry_at,
+				Err:     e.last_err,
+			}
+
+			e.mu.Unlock()
+			return nil, false, err
+		}
+
+		initialized := false
+
+		if e.pending == nil {
+			e.pending = &routeInit{done: make(chan struct{})}
+			initialized = true
+			go e.init(context.WithoutCancel(ctx), e.pending, opts, logger)
+		}
+
+		pending := e.pending
+		e.mu.Unlock()
+
+		select {
+		case <-pending.done:
+		case <-ctx.Done():
+			return nil, false, fmt.Errorf("Failed to wait for handler for '%s' to initialize, %w", e.pattern, ctx.Err())
+		}
+
+		if pending.err != nil {
+			return nil, false, pending.err
+		}
+
+		if pending.ref.acquire() {
+			return pending.ref, initialized, nil
+		}
+	}
+}
+
+// init invokes the `RouteHandlerFunc` for 'e' with 'ctx' and records the outcome in 'e' and 'pending'.
+func (e *routeEntry) init(ctx context.Context, pending *routeInit, opts *routeInitOptions, logger *slog.Logger) {
+
+	defer close(pending.done)
+
+	init_ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	// The handler function is run in its own goroutine so that a function which ignores its context still can't
+	// block requests for longer than the timeout
+
+	type result struct {
+		handler http.Handler
+		err     error
+	}
+
+	results := make(chan result, 1)
+
+	go func() {
+		handler, err := e.callHandlerFunc(init_ctx)
+		results <- result{handler, err}
+	}()
+
+	var handler http.Handler
+	var err error
+
+	select {
+	case r := <-results:
+		handler = r.handler
+		err = r.err
+	case <-init_ctx.Done():
+
+		err = fmt.Errorf("Handler function did not return within %v, %w", opts.timeout, init_ctx.Err())
+
+		// Close any handler the function eventually returns since it will never be used
+
+		go func() {
+
+			r := <-results
+
+			if r.handler != nil {
+				ref := &routeHandlerRef{
+					handler: r.handler,
+					logger:  logger.With("pattern", e.pattern),
+				}
+				ref.evict()
+			}
+		}()
+	}
+
+	if err == nil && handler == nil {
+		err = errors.New("Handler function returned a nil handler")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.pending = nil
+
+	if err != nil {
+
+		e.failures += 1
+		e.last_err = err
+		e.retry_at = time.Now().Add(opts.backoffFor(e.failures))
+
+		pending.err = &RouteInitError{
+			Pattern: e.pattern,
+			RetryAt: e.retry_at,
+			Err:     err,
+		}
+
+		return
+	}
+
+	e.failures = 0
+	e.last_err = nil
+	e.retry_at = time.Time{}
+
+	pending.ref = &routeHandlerRef{
+		handler: handler,
+		logger:  logger.With("pattern", e.pattern),
+	}
+
+	e.touch(time.Now().UnixNano())
+	e.ref.Store(pending.ref)
+}
+
+// callHandlerFunc invokes the `RouteHandlerFunc` for 'e' with 'ctx', recovering from any panics since it is not
+// run in the goroutine serving a request.
+func (e *routeEntry) callHandlerFunc(ctx context.Context) (handler http.Handler, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Handler function panicked, %v", r)
+		}
+	}()
+
+	return e.handler_func(ctx)
+}
+
+// touch records that the handler for 'e' was requested at 'now' (Unix nanoseconds). To avoid every request for a popular
+// pattern writing to the same memory the time is only updated if it has changed by more than 'e.touch_interval'.
+func (e *routeEntry) touch(now int64) {
+
+	if now-e.last_used.Load() > int64(e.touch_interval) {
+		e.last_used.Store(now)
+	}
+}
+
+// initialized reports whether 'e' has a cached handler.
+func (e *routeEntry) initialized() bool {
+	return e.ref.Load() != nil
+}
+
+// evict removes the cached handler for 'e', if there is one, and reports whether it did. If 'idle_since' is greater than
+// zero the handler is only removed if it has not been requested since then (Unix nanoseconds). The handler is closed once
+// any requests that are using it have completed.
+func (e *routeEntry) evict(idle_since int64) bool {
+
+	ref := e.ref.Load()
+
+	if ref == nil {
+		return false
+	}
+
+	if idle_since > 0 && e.last_used.Load() >= idle_since {
+		return false
+	}
+
+	if !e.ref.CompareAndSwap(ref, nil) {
+		return false
+	}
+
+	ref.evict()
+	return true
+}
+
+// reload evicts the cached handler for 'e', if there is one, and clears any backoff from previous failures so that
+// the next request invokes its `RouteHandlerFunc` again.
+func (e *routeEntry) reload() bool {
+
+	e.mu.Lock()
+	e.failures = 0
+	e.last_err = nil
+	e.retry_at = time.Time{}
+	e.mu.Unlock()
+
+	return e.evict(0)
+}
+
+// acquire marks 'r' as being in use. It returns false if 'r' has been evicted, in which case it must not be used.
+func (r *routeHandlerRef) acquire() bool {
+
+	r.active.Add(1)
+
+	// The counter is incremented before checking whether 'r' has been evicted, and `evict` does the opposite, so that
+	// either this method sees the eviction or `evict` sees that 'r' is in use
+
+	if r.evicted.Load() {
+		r.release()
+		return false
+	}
+
+	return true
+}
+
+// release marks 'r' as no longer being used by a request, closing it if it has been evicted and this was the last request.
+func (r *routeHandlerRef) release() {
+
+	if r.active.Add(-1) == 0 && r.evicted.Load() {
+		r.close()
+	}
+}
+
+// evict marks 'r' as having been evicted, closing it if it is not in use.
+func (r *routeHandlerRef) evict() {
+
+	r.evicted.Store(true)
+
+	if r.active.Load() == 0 {
+		r.close()
+	}
+}
+
+// close closes the handler for 'r', exactly once, if it implements the `io.Closer` interface.
+func (r *routeHandlerRef) close() {
+
+	if !r.closed.CompareAndSwap(false, true) {
+		return
+	}
+
+	c, ok := r.handler.(io.Closer)
+
+	if !ok {
+		return
+	}
+
+	err := c.Close()
+
+	if err != nil {
+		r.logger.Error("Failed to close route handler", "error", err)
+		return
+	}
+
+	r.logger.Debug("Closed route handler")
+}
+
+// backoffFor returns how long to wait before retrying a `RouteHandlerFunc` that has failed 'failures' consecutive times.
+func (opts *routeInitOptions) backoffFor(failures int) time.Duration {
+
+	d := opts.backoff
+
+	for i := 1; i < failures && d < opts.max_backoff; i++ {
+		d *= 2
+	}
+
+	return min(d, opts.max_backoff)
+}
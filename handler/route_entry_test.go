@@ -0,0 +1,567 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRouteHandlerSingleFlight(t *testing.T) {
+
+	var count atomic.Int32
+	release := make(chan struct{})
+
+	handlers := map[string]RouteHandlerFunc{
+		"/slow/": func(ctx context.Context) (http.Handler, error) {
+
+			count.Add(1)
+			<-release
+
+			fn := func(rsp http.ResponseWriter, req *http.Request) {
+				rsp.Write([]byte("slow"))
+			}
+
+			return http.HandlerFunc(fn), nil
+		},
+	}
+
+	h, err := RouteHandler(handlers)
+
+	if err != nil {
+		t.Fatalf("Failed to create route handler, %v", err)
+	}
+
+	// The first request is cancelled while it waits, which should not abort initialization for everyone else
+
+	cancelled_ctx, cancel := context.WithCancel(context.Background())
+
+	cancelled_rec := httptest.NewRecorder()
+	cancelled_done := make(chan struct{})
+
+	go func() {
+		defer close(cancelled_done)
+		req := httptest.NewRequestWithContext(cancelled_ctx, http.MethodGet, "/slow/", nil)
+		h.ServeHTTP(cancelled_rec, req)
+	}()
+
+	for count.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-cancelled_done
+
+	if cancelled_rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected status for cancelled request: %d", cancelled_rec.Code)
+	}
+
+	wg := new(sync.WaitGroup)
+	codes := make([]int, 10)
+
+	for i := range codes {
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow/", nil))
+			codes[i] = rec.Code
+		}(i)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i, code := range codes {
+
+		if code != http.StatusOK {
+			t.Fatalf("Unexpected status for request %d: %d", i, code)
+		}
+	}
+
+	if count.Load() != 1 {
+		t.Fatalf("Expected handler function to be invoked once, but it was invoked %d times", count.Load())
+	}
+}
+
+func TestRouteHandlerInitTimeout(t *testing.T) {
+
+	handlers := map[string]RouteHandlerFunc{
+		"/slow/": func(ctx context.Context) (http.Handler, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	opts := &RouteHandlerOptions{
+		Handlers:    handlers,
+		InitTimeout: 10 * time.Millisecond,
+	}
+
+	h, err := RouteHandlerWithOptions(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create route handler, %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected status: %d", rec.Code)
+	}
+
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Unexpected Retry-After header: '%s'", rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestRouteHandlerInitBackoff(t *testing.T) {
+
+	var count atomic.Int32
+
+	handlers := map[string]RouteHandlerFunc{
+		"/flaky/": func(ctx context.Context) (http.Handler, error) {
+
+			if count.Add(1) <= 2 {
+				return nil, fmt.Errorf("Not yet")
+			}
+
+			fn := func(rsp http.ResponseWriter, req *http.Request) {
+				rsp.Write([]byte("flaky"))
+			}
+
+			return http.HandlerFunc(fn), nil
+		},
+		"/panic/": func(ctx context.Context) (http.Handler, error) {
+			panic("Oh no")
+		},
+	}
+
+	opts := &RouteHandlerOptions{
+		Handlers:       handlers,
+		InitBackoff:    50 * time.Millisecond,
+		InitMaxBackoff: 80 * time.Millisecond,
+	}
+
+	h, err := RouteHandlerWithOptions(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create route handler, %v", err)
+	}
+
+	serve := func(path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	tests := []struct {
+		wait  time.Duration
+		code  int
+		count int32
+	}{
+		// Fails
+		{0, http.StatusServiceUnavailable, 1},
+		// Still backing off so the handler function isn't invoked
+		{0, http.StatusServiceUnavailable, 1},
+		// Fails again and backs off for twice as long, capped at the maximum
+		{60 * time.Millisecond, http.StatusServiceUnavailable, 2},
+		{60 * time.Millisecond, http.StatusServiceUnavailable, 2},
+		// Succeeds and is cached
+		{40 * time.Millisecond, http.StatusOK, 3},
+		{0, http.StatusOK, 3},
+	}
+
+	for i, test := range tests {
+
+		time.Sleep(test.wait)
+
+		code := serve("/flaky/")
+
+		if code != test.code {
+			t.Fatalf("Unexpected status for request %d: %d", i, code)
+		}
+
+		if count.Load() != test.count {
+			t.Fatalf("Unexpected invocation count after request %d: %d", i, count.Load())
+		}
+	}
+
+	code := serve("/panic/")
+
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected status for panicking handler function: %d", code)
+	}
+}
+
+func TestRouteInitBackoff(t *testing.T) {
+
+	opts := &routeInitOptions{
+		backoff:     time.Second,
+		max_backoff: 5 * time.Second,
+	}
+
+	expected := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		5 * time.Second,
+		5 * time.Second,
+	}
+
+	for i, d := range expected {
+
+		backoff := opts.backoffFor(i + 1)
+
+		if backoff != d {
+			t.Fatalf("Unexpected backoff after %d failures, expected %v but got %v", i+1, d, backoff)
+		}
+	}
+}
+
+// closingHandler is an `http.Handler` which records when it is closed. If 'handler' is not nil requests are served using it.
+type closingHandler struct {
+	name    string
+	closed  *atomic.Int32
+	handler http.Handler
+}
+
+func (h *closingHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+
+	if h.handler != nil {
+		h.handler.ServeHTTP(rsp, req)
+		return
+	}
+
+	rsp.Write([]byte(h.name))
+}
+
+func (h *closingHandler) Close() error {
+	h.closed.Add(1)
+	return nil
+}
+
+// newClosingHandlerFunc returns a `RouteHandlerFunc` for a `closingHandler` named 'name' which counts how many times
+// it has been invoked in 'initialized' and how many handlers it returned have been closed in 'closed'.
+func newClosingHandlerFunc(name string, initialized *atomic.Int32, closed *atomic.Int32) RouteHandlerFunc {
+
+	return func(ctx context.Context) (http.Handler, error) {
+		initialized.Add(1)
+		return &closingHandler{name: name, closed: closed}, nil
+	}
+}
+
+// waitForCount waits up to a second for 'count' to equal 'expected'.
+func waitForCount(count *atomic.Int32, expected int32) bool {
+
+	for i := 0; i < 100; i++ {
+
+		if count.Load() == expected {
+			return true
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
+
+func TestRouteHandlerReload(t *testing.T) {
+
+	var initialized atomic.Int32
+	var closed atomic.Int32
+
+	release := make(chan struct{})
+
+	handlers := map[string]RouteHandlerFunc{
+		"/a/": newClosingHandlerFunc("a", &initialized, &closed),
+		"/b/": newClosingHandlerFunc("b", &initialized, &closed),
+		"/slow/": func(ctx context.Context) (http.Handler, error) {
+
+			initialized.Add(1)
+
+			fn := func(rsp http.ResponseWriter, req *http.Request) {
+				<-release
+			}
+
+			return &closingHandler{name: "slow", closed: &closed, handler: http.HandlerFunc(fn)}, nil
+		},
+	}
+
+	h, err := RouteHandler(handlers)
+
+	if err != nil {
+		t.Fatalf("Failed to create route handler, %v", err)
+	}
+
+	reloader, ok := h.(RouteReloader)
+
+	if !ok {
+		t.Fatalf("Expected route handler to implement RouteReloader")
+	}
+
+	serve := func(path string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	serve("/a/")
+	serve("/a/")
+
+	if initialized.Load() != 1 || closed.Load() != 0 {
+		t.Fatalf("Unexpected counts after requests, initialized %d closed %d", initialized.Load(), closed.Load())
+	}
+
+	err = reloader.Reload("/a/")
+
+	if err != nil {
+		t.Fatalf("Failed to reload handler, %v", err)
+	}
+
+	if closed.Load() != 1 {
+		t.Fatalf("Expected reloaded handler to be closed")
+	}
+
+	serve("/a/")
+	serve("/b/")
+
+	if initialized.Load() != 3 {
+		t.Fatalf("Expected reloaded handler to be initialized again, initialized %d", initialized.Load())
+	}
+
+	// Handlers which are in use are only closed once the requests using them complete
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		serve("/slow/")
+	}()
+
+	if !waitForCount(&initialized, 4) {
+		t.Fatalf("Expected slow handler to be initialized")
+	}
+
+	reloader.ReloadAll()
+
+	if closed.Load() != 3 {
+		t.Fatalf("Expected idle handlers to be closed, closed %d", closed.Load())
+	}
+
+	close(release)
+	<-done
+
+	if closed.Load() != 4 {
+		t.Fatalf("Expected slow handler to be closed once its request completed, closed %d", closed.Load())
+	}
+
+	err = reloader.Reload("/missing/")
+
+	if err == nil {
+		t.Fatalf("Expected reloading an undefined pattern to fail")
+	}
+}
+
+func TestRouteHandlerMaxHandlers(t *testing.T) {
+
+	var initialized atomic.Int32
+	var closed atomic.Int32
+
+	opts := &RouteHandlerOptions{
+		Handlers: map[string]RouteHandlerFunc{
+			"/a/": newClosingHandlerFunc("a", &initialized, &closed),
+			"/b/": newClosingHandlerFunc("b", &initialized, &closed),
+			"/c/": newClosingHandlerFunc("c", &initialized, &closed),
+		},
+		MaxHandlers: 2,
+	}
+
+	h, err := RouteHandlerWithOptions(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create route handler, %v", err)
+	}
+
+	for _, path := range []string{"/a/", "/b/", "/c/", "/b/", "/c/"} {
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		// Make sure each handler is initialized at a distinct time
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if initialized.Load() != 3 || closed.Load() != 1 {
+		t.Fatalf("Unexpected counts after requests, initialized %d closed %d", initialized.Load(), closed.Load())
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a/", nil))
+
+	if initialized.Load() != 4 || closed.Load() != 2 {
+		t.Fatalf("Unexpected counts after requesting evicted handler, initialized %d closed %d", initialized.Load(), closed.Load())
+	}
+}
+
+func TestRouteHandlerIdleTTL(t *testing.T) {
+
+	var initialized atomic.Int32
+	var closed atomic.Int32
+
+	opts := &RouteHandlerOptions{
+		Handlers: map[string]RouteHandlerFunc{
+			"/a/": newClosingHandlerFunc("a", &initialized, &closed),
+			"/b/": newClosingHandlerFunc("b", &initialized, &closed),
+		},
+		IdleTTL: 50 * time.Millisecond,
+	}
+
+	h, err := RouteHandlerWithOptions(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create route handler, %v", err)
+	}
+
+	serve := func(path string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	serve("/a/")
+	serve("/b/")
+
+	// Idle handlers are evicted in the background even if no more requests are served
+
+	if !waitForCount(&closed, 2) {
+		t.Fatalf("Expected idle handlers to be closed, closed %d", closed.Load())
+	}
+
+	serve("/a/")
+
+	if initialized.Load() != 3 {
+		t.Fatalf("Expected evicted handler to be initialized again, initialized %d", initialized.Load())
+	}
+
+	// Closing the route handler stops evicting idle handlers and closes the cached ones
+
+	closer, ok := h.(io.Closer)
+
+	if !ok {
+		t.Fatalf("Expected route handler to implement io.Closer")
+	}
+
+	err = closer.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close route handler, %v", err)
+	}
+
+	if closed.Load() != 3 {
+		t.Fatalf("Expected cached handler to be closed, closed %d", closed.Load())
+	}
+
+	serve("/b/")
+	time.Sleep(150 * time.Millisecond)
+
+	if closed.Load() != 3 {
+		t.Fatalf("Expected idle handlers not to be evicted after closing, closed %d", closed.Load())
+	}
+}
+
+func TestRouteHandlerIdleTTLShort(t *testing.T) {
+
+	var initialized atomic.Int32
+	var closed atomic.Int32
+
+	opts := &RouteHandlerOptions{
+		Handlers: map[string]RouteHandlerFunc{
+			"/a/": newClosingHandlerFunc("a", &initialized, &closed),
+		},
+		IdleTTL: 200 * time.Millisecond,
+	}
+
+	h, err := RouteHandlerWithOptions(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create route handler, %v", err)
+	}
+
+	defer h.(io.Closer).Close()
+
+	// A handler which is requested more often than IdleTTL is never evicted, even when IdleTTL is less than a second
+
+	for i := 0; i < 40; i++ {
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a/", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code %d", rec.Code)
+		}
+
+		time.Sleep(25 * time.Millisecond)
+	}
+
+	if initialized.Load() != 1 || closed.Load() != 0 {
+		t.Fatalf("Expected handler in use not to be evicted, initialized %d closed %d", initialized.Load(), closed.Load())
+	}
+}
+
+func TestRouteHandlerInitTimeoutIgnored(t *testing.T) {
+
+	var closed atomic.Int32
+	release := make(chan struct{})
+
+	handlers := map[string]RouteHandlerFunc{
+		"/stuck/": func(ctx context.Context) (http.Handler, error) {
+			<-release
+			return &closingHandler{name: "stuck", closed: &closed}, nil
+		},
+	}
+
+	opts := &RouteHandlerOptions{
+		Handlers:    handlers,
+		InitTimeout: 50 * time.Millisecond,
+	}
+
+	h, err := RouteHandlerWithOptions(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create route handler, %v", err)
+	}
+
+	t1 := time.Now()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stuck/", nil))
+
+	if time.Since(t1) > time.Second {
+		t.Fatalf("Expected request to time out after InitTimeout, took %v", time.Since(t1))
+	}
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected status: %d", rec.Code)
+	}
+
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected Retry-After header")
+	}
+
+	// The handler the function eventually returns is discarded
+
+	close(release)
+
+	if !waitForCount(&closed, 1) {
+		t.Fatalf("Expected handler returned after the timeout to be closed")
+	}
+}
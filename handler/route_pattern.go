@@ -0,0 +1,588 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE-GO file.
+
+// This file adapts the parsing, validation and conflict detection of patterns from src/net/http/pattern.go in the Go
+// source tree at the go1.22.0 tag: https://go.googlesource.com/go/+/refs/tags/go1.22.0/src/net/http/pattern.go
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// routePattern is a parsed `http.ServeMux` style "[METHOD ][HOST]/[PATH]" routing pattern. The rules for parsing
+// and matching patterns are the same as those used by `http.ServeMux` in Go 1.22 and higher.
+type routePattern struct {
+	str    string
+	method string
+	host   string
+	// Paths ending in "/" are represented with a trailing anonymous multi-segment wildcard and paths ending in "{$}"
+	// are represented with a trailing literal "/" segment. For example "/a/" is the segments "a" and "{...}" and "/a/{$}"
+	// is the segments "a" and "/".
+	segments []routeSegment
+}
+
+// routeSegment is a single segment of the path of a `routePattern`.
+type routeSegment struct {
+	// s is a literal value, the name of a wildcard or "/" for a trailing "{$}".
+	s string
+	// wild is true if the segment is a "{name}" or "{name...}" wildcard.
+	wild bool
+	// multi is true if the segment is a "{name...}" wildcard, or a trailing slash, matching all the remaining path segments.
+	multi bool
+}
+
+// String returns the original string that 'p' was parsed from.
+func (p *routePattern) String() string {
+	return p.str
+}
+
+// lastSegment returns the last segment of the path of 'p'.
+func (p *routePattern) lastSegment() routeSegment {
+	return p.segments[len(p.segments)-1]
+}
+
+// parseRoutePattern parses 's' in to a `routePattern` instance. Patterns take the form "[METHOD ][HOST]/[PATH]" where
+// METHOD is any valid HTTP method, followed by one or more spaces or tabs, and PATH is a list of "/" separated segments
+// which are either literal values or "{name}", "{name...}" or "{$}" wildcards. The "{name...}" and "{$}" wildcards may
+// only occur at the end of a path and wildcard names must be distinct, valid Go identifiers.
+func parseRoutePattern(s string) (*routePattern, error) {
+
+	if s == "" {
+		return nil, errors.New("empty pattern")
+	}
+
+	method := ""
+	rest := s
+
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		method = s[:i]
+		rest = strings.TrimLeft(s[i+1:], " \t")
+
+		if !isRouteToken(method) {
+			return nil, fmt.Errorf("invalid method %q", method)
+		}
+	}
+
+	i := strings.IndexByte(rest, '/')
+
+	if i < 0 {
+		return nil, errors.New("host/path missing /")
+	}
+
+	p := &routePattern{
+		str:      s,
+		method:   method,
+		host:     rest[:i],
+		segments: make([]routeSegment, 0),
+	}
+
+	if strings.Contains(p.host, "{") {
+		return nil, errors.New("host contains '{' (missing initial '/'?)")
+	}
+
+	rest = rest[i:]
+
+	// Paths are cleaned before they are matched so an unclean path can never match (CONNECT requests
+	// are the exception)
+
+	if method != "" && method != "CONNECT" && rest != cleanRoutePath(rest) {
+		return nil, errors.New("non-CONNECT pattern with unclean path can never match")
+	}
+
+	names := make(map[string]bool)
+
+	for len(rest) > 0 {
+
+		rest = rest[1:]
+
+		if rest == "" {
+
+			// Trailing slash
+
+			p.segments = append(p.segments, routeSegment{wild: true, multi: true})
+			break
+		}
+
+		i := strings.IndexByte(rest, '/')
+
+		if i < 0 {
+			i = len(rest)
+		}
+
+		seg := rest[:i]
+		rest = rest[i:]
+
+		j := strings.IndexByte(seg, '{')
+
+		if j < 0 {
+			p.segments = append(p.segments, routeSegment{s: unescapeRoutePath(seg)})
+			continue
+		}
+
+		if j != 0 {
+			return nil, errors.New("bad wildcard segment (must start with '{')")
+		}
+
+		if !strings.HasSuffix(seg, "}") {
+			return nil, errors.New("bad wildcard segment (must end with '}')")
+		}
+
+		name := seg[1 : len(seg)-1]
+
+		if name == "$" {
+
+			if rest != "" {
+				return nil, errors.New("{$} not at end")
+			}
+
+			p.segments = append(p.segments, routeSegment{s: "/"})
+			break
+		}
+
+		name, multi := strings.CutSuffix(name, "...")
+
+		if multi && rest != "" {
+			return nil, errors.New("{...} wildcard not at end")
+		}
+
+		if name == "" {
+			return nil, errors.New("empty wildcard")
+		}
+
+		if !isRouteWildcardName(name) {
+			return nil, fmt.Errorf("bad wildcard name %q", name)
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("duplicate wildcard name %q", name)
+		}
+
+		names[name] = true
+		p.segments = append(p.segments, routeSegment{s: name, wild: true, multi: multi})
+	}
+
+	return p, nil
+}
+
+// exactMatch reports whether 'p' matches 'escaped_path' without its trailing multi-segment wildcard (if any)
+// matching a non-empty string. For example "/a/" matches "/a/" exactly but not "/a/b".
+func (p *routePattern) exactMatch(escaped_path string) bool {
+
+	if !p.lastSegment().multi {
+		return true
+	}
+
+	if !strings.HasSuffix(escaped_path, "/") {
+		return false
+	}
+
+	return len(p.segments) == strings.Count(escaped_path, "/")
+}
+
+// routeRelationship is the relationship between two patterns, p1 and p2, in terms of the requests they match.
+type routeRelationship string
+
+const (
+	// Both patterns match the same requests.
+	routeEquivalent routeRelationship = "equivalent"
+	// p1 matches all the requests that p2 does and more.
+	routeMoreGeneral routeRelationship = "more general"
+	// p2 matches all the requests that p1 does and more.
+	routeMoreSpecific routeRelationship = "more specific"
+	// There is no request that both patterns match.
+	routeDisjoint routeRelationship = "disjoint"
+	// There are requests that both patterns match but neither is more specific than the other.
+	routeOverlaps routeRelationship = "overlaps"
+)
+
+// conflictsWith reports whether 'p' conflicts with 'other', that is whether there is a request that both patterns match
+// but where neither takes precedence over the other. Patterns with a host take precedence over those without one so
+// patterns only conflict if their hosts are the same and their methods and paths are equivalent or overlap.
+func (p *routePattern) conflictsWith(other *routePattern) bool {
+
+	if p.host != other.host {
+		return false
+	}
+
+	rel := combineRouteRelationships(p.compareMethods(other), p.comparePaths(other))
+	return rel == routeEquivalent || rel == routeOverlaps
+}
+
+// describeConflict returns an explanation of why 'p' and 'other', which are expected to conflict, do so.
+func (p *routePattern) describeConflict(other *routePattern) string {
+
+	method_rel := p.compareMethods(other)
+	path_rel := p.comparePaths(other)
+
+	switch {
+	case combineRouteRelationships(method_rel, path_rel) == routeEquivalent:
+		return fmt.Sprintf("'%s' matches the same requests as '%s'", p, other)
+	case path_rel == routeOverlaps:
+		return fmt.Sprintf("'%s' and '%s' both match some paths, like %q, but neither is more specific than the other: '%s' matches %q but '%s' doesn't and '%s' matches %q but '%s' doesn't",
+			p, other, p.commonPath(other), p, p.differencePath(other), other, other, other.differencePath(p), p)
+	case method_rel == routeMoreGeneral:
+		return fmt.Sprintf("'%s' matches more methods than '%s' but has a more specific path", p, other)
+	default:
+		return fmt.Sprintf("'%s' matches fewer methods than '%s' but has a more general path", p, other)
+	}
+}
+
+// compareMethods returns the relationship between the methods of 'p' and 'other'. An empty method matches any method
+// and "GET" matches both "GET" and "HEAD" requests.
+func (p *routePattern) compareMethods(other *routePattern) routeRelationship {
+
+	switch {
+	case p.method == other.method:
+		return routeEquivalent
+	case p.method == "":
+		return routeMoreGeneral
+	case other.method == "":
+		return routeMoreSpecific
+	case p.method == "GET" && other.method == "HEAD":
+		return routeMoreGeneral
+	case p.method == "HEAD" && other.method == "GET":
+		return routeMoreSpecific
+	default:
+		return routeDisjoint
+	}
+}
+
+// comparePaths returns the relationship between the paths of 'p' and 'other'.
+func (p *routePattern) comparePaths(other *routePattern) routeRelationship {
+
+	segs_p := p.segments
+	segs_o := other.segments
+
+	multi_p := p.lastSegment().multi
+	multi_o := other.lastSegment().multi
+
+	// Without a multi-segment wildcard a pattern can only match paths with the same number of segments
+
+	if len(segs_p) != len(segs_o) && !multi_p && !multi_o {
+		return routeDisjoint
+	}
+
+	rel := routeEquivalent
+
+	for len(segs_p) > 0 && len(segs_o) > 0 {
+
+		rel = combineRouteRelationships(rel, compareRouteSegments(segs_p[0], segs_o[0]))
+
+		if rel == routeDisjoint {
+			return rel
+		}
+
+		segs_p = segs_p[1:]
+		segs_o = segs_o[1:]
+	}
+
+	switch {
+	case len(segs_p) == 0 && len(segs_o) == 0:
+		return rel
+	case len(segs_p) == 0 && multi_p:
+		// The multi-segment wildcard of 'p' is more general than the rest of 'other'
+		return combineRouteRelationships(rel, routeMoreGeneral)
+	case len(segs_o) == 0 && multi_o:
+		return combineRouteRelationships(rel, routeMoreSpecific)
+	default:
+		return routeDisjoint
+	}
+}
+
+// commonPath returns a path that both 'p' and 'other' match. It assumes there is one.
+func (p *routePattern) commonPath(other *routePattern) string {
+
+	var b strings.Builder
+
+	segs_p := p.segments
+	segs_o := other.segments
+
+	for len(segs_p) > 0 && len(segs_o) > 0 {
+
+		if segs_p[0].wild {
+			writeRouteSegment(&b, segs_o[0])
+		} else {
+			writeRouteSegment(&b, segs_p[0])
+		}
+
+		segs_p = segs_p[1:]
+		segs_o = segs_o[1:]
+	}
+
+	for _, seg := range append(segs_p, segs_o...) {
+		writeRouteSegment(&b, seg)
+	}
+
+	return b.String()
+}
+
+// differencePath returns a path that 'p' matches and 'other' does not. It assumes there is one.
+func (p *routePattern) differencePath(other *routePattern) string {
+
+	var b strings.Builder
+
+	segs_p := p.segments
+	segs_o := other.segments
+
+	for len(segs_p) > 0 && len(segs_o) > 0 {
+
+		seg_p := segs_p[0]
+		seg_o := segs_o[0]
+
+		switch {
+		case seg_p.multi && seg_o.multi:
+
+			// The patterns match the same paths from here on so the difference was found earlier
+
+			b.WriteByte('/')
+			return b.String()
+
+		case seg_p.multi:
+
+			// A trailing slash distinguishes them unless 'other' ends in "{$}" in which case any segment will do
+
+			b.WriteByte('/')
+
+			if seg_o.s == "/" {
+
+				if seg_p.s != "" {
+					b.WriteString(seg_p.s)
+				} else {
+					b.WriteString("x")
+				}
+			}
+
+			return b.String()
+
+		case seg_p.wild && !seg_o.wild && seg_p.s == seg_o.s:
+			b.WriteByte('/')
+			b.WriteString(seg_o.s + "x")
+
+		default:
+			writeRouteSegment(&b, seg_p)
+		}
+
+		segs_p = segs_p[1:]
+		segs_o = segs_o[1:]
+	}
+
+	for _, seg := range append(segs_p, segs_o...) {
+		writeRouteSegment(&b, seg)
+	}
+
+	return b.String()
+}
+
+// compareRouteSegments returns the relationship between the segments 's1' and 's2'.
+func compareRouteSegments(s1 routeSegment, s2 routeSegment) routeRelationship {
+
+	switch {
+	case s1.multi && s2.multi:
+		return routeEquivalent
+	case s1.multi:
+		return routeMoreGeneral
+	case s2.multi:
+		return routeMoreSpecific
+	case s1.wild && s2.wild:
+		return routeEquivalent
+	case s1.wild:
+
+		// Single wildcards don't match trailing slashes
+
+		if s2.s == "/" {
+			return routeDisjoint
+		}
+
+		return routeMoreGeneral
+
+	case s2.wild:
+
+		if s1.s == "/" {
+			return routeDisjoint
+		}
+
+		return routeMoreSpecific
+
+	case s1.s == s2.s:
+		return routeEquivalent
+	default:
+		return routeDisjoint
+	}
+}
+
+// combineRouteRelationships returns the overall relationship between two patterns given the relationships, 'r1' and 'r2',
+// between two separate parts of those patterns. For example if one part is more general and the other is equivalent the
+// patterns are more general but if one part is more general and the other more specific they overlap.
+func combineRouteRelationships(r1 routeRelationship, r2 routeRelationship) routeRelationship {
+
+	switch r1 {
+	case routeEquivalent:
+		return r2
+	case routeDisjoint:
+		return routeDisjoint
+	case routeOverlaps:
+
+		if r2 == routeDisjoint {
+			return routeDisjoint
+		}
+
+		return routeOverlaps
+
+	default:
+
+		switch r2 {
+		case routeEquivalent:
+			return r1
+		case inverseRouteRelationship(r1):
+			return routeOverlaps
+		default:
+			return r2
+		}
+	}
+}
+
+// inverseRouteRelationship returns the relationship of p2 to p1 given the relationship 'r' of p1 to p2.
+func inverseRouteRelationship(r routeRelationship) routeRelationship {
+
+	switch r {
+	case routeMoreSpecific:
+		return routeMoreGeneral
+	case routeMoreGeneral:
+		return routeMoreSpecific
+	default:
+		return r
+	}
+}
+
+// writeRouteSegment writes a path segment matching 's' to 'b'.
+func writeRouteSegment(b *strings.Builder, s routeSegment) {
+
+	b.WriteByte('/')
+
+	if !s.multi && s.s != "/" {
+		b.WriteString(s.s)
+	}
+}
+
+// firstRouteSegment splits 'escaped_path', which must start with "/", in to its first (unescaped) segment and
+// the rest of the path. If 'escaped_path' is "/" it returns "/" and an empty string.
+func firstRouteSegment(escaped_path string) (string, string) {
+
+	if escaped_path == "/" {
+		return "/", ""
+	}
+
+	escaped_path = escaped_path[1:]
+	i := strings.IndexByte(escaped_path, '/')
+
+	if i < 0 {
+		i = len(escaped_path)
+	}
+
+	return unescapeRoutePath(escaped_path[:i]), escaped_path[i:]
+}
+
+// unescapeRoutePath returns the unescaped value of 'p' or 'p' if it is not validly escaped.
+func unescapeRoutePath(p string) string {
+
+	u, err := url.PathUnescape(p)
+
+	if err != nil {
+		return p
+	}
+
+	return u
+}
+
+// cleanRoutePath returns the canonical form of 'p', eliminating "." and ".." elements and duplicate slashes
+// but preserving a trailing slash.
+func cleanRoutePath(p string) string {
+
+	if p == "" {
+		return "/"
+	}
+
+	if p[0] != '/' {
+		p = "/" + p
+	}
+
+	np := path.Clean(p)
+
+	if strings.HasSuffix(p, "/") && np != "/" {
+		np += "/"
+	}
+
+	return np
+}
+
+// stripRouteHostPort returns 'host' without any trailing ":{PORT}".
+func stripRouteHostPort(host string) string {
+
+	if !strings.Contains(host, ":") {
+		return host
+	}
+
+	h, _, err := net.SplitHostPort(host)
+
+	if err != nil {
+		return host
+	}
+
+	return h
+}
+
+// isRouteWildcardName reports whether 's' is a valid Go identifier.
+func isRouteWildcardName(s string) bool {
+
+	for i, c := range s {
+
+		if !unicode.IsLetter(c) && c != '_' && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+
+	return s != ""
+}
+
+// isRouteToken reports whether 's' is a valid HTTP token (RFC 9110), as required for method names.
+func isRouteToken(s string) bool {
+
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			// pass
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+			// pass
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// routeURL returns a `url.URL` instance for the escaped path 'escaped_path' and 'raw_query', keeping its
+// `Path` and `RawPath` fields in sync.
+func routeURL(escaped_path string, raw_query string) *url.URL {
+
+	u := &url.URL{
+		Path:     unescapeRoutePath(escaped_path),
+		RawPath:  escaped_path,
+		RawQuery: raw_query,
+	}
+
+	return u
+}
@@ -4,15 +4,20 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aaronland/go-http-server/v2"
 )
 
 func TestRouteHandler(t *testing.T) {
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
 
 	pv_func := func(ctx context.Context) (http.Handler, error) {
 
@@ -80,24 +85,47 @@ func TestRouteHandler(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.Handle("/", route_handler)
 
-	s, err := server.NewServer(ctx, "http://localhost:8080")
+	s, err := server.NewServer(ctx, "http://localhost:8081")
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	done_ch := make(chan struct{})
+
 	go func() {
 		s.ListenAndServe(ctx, mux)
+		close(done_ch)
+	}()
+
+	// Stop the server so the port is free if the test is run again
+
+	defer func() {
+		cancel()
+		<-done_ch
 	}()
 
+	// Wait for the server to start listening
+
+	for i := 0; i < 50; i++ {
+
+		conn, err := net.Dial("tcp", "localhost:8081")
+
+		if err == nil {
+			conn.Close()
+			break
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
 	tests_to_succeed := map[string]string{
-		"http://localhost:8080/foo":                        "foo",
-		"http://localhost:8080/foo/":                       "foo",
-		"http://localhost:8080/foo/bar":                    "bar",
-		"http://localhost:8080/id/1234":                    "1234",
-		"http://localhost:8080/id/5678/sub":                "5678",
-		"http://localhost:8080/horse/omg/wtf/email":        "horse email",
-		"http://localhost:8080/this/is/a/GET/handler/yeah": "GET handler",
+		"http://localhost:8081/foo":                        "foo",
+		"http://localhost:8081/foo/bar":                    "bar",
+		"http://localhost:8081/id/1234":                    "1234",
+		"http://localhost:8081/id/5678/sub":                "5678",
+		"http://localhost:8081/horse/omg/wtf/email":        "horse email",
+		"http://localhost:8081/this/is/a/GET/handler/yeah": "GET handler",
 	}
 
 	for uri, expected := range tests_to_succeed {
@@ -123,10 +151,13 @@ func TestRouteHandler(t *testing.T) {
 		}
 	}
 
+	// Like http.ServeMux "/foo" only matches "/foo" and not "/foo/"
+
 	tests_to_fail := map[string]string{
-		"http://localhost:8080/foo/post":         "",
-		"http://localhost:8080/wrong/host/":      "",
-		"http://localhost:8080/also/wrong/host/": "",
+		"http://localhost:8081/foo/":             "",
+		"http://localhost:8081/foo/post":         "",
+		"http://localhost:8081/wrong/host/":      "",
+		"http://localhost:8081/also/wrong/host/": "",
 	}
 
 	for uri, _ := range tests_to_fail {
@@ -146,3 +177,506 @@ func TestRouteHandler(t *testing.T) {
 	}
 
 }
+
+func TestRouteHandlerInitAndWarmRoutes(t *testing.T) {
+
+	ctx := context.Background()
+
+	initialized := make(map[string]int)
+
+	newFunc := func(name string) RouteHandlerFunc {
+
+		return func(ctx context.Context) (http.Handler, error) {
+
+			initialized[name] += 1
+
+			fn := func(rsp http.ResponseWriter, req *http.Request) {
+				rsp.Write([]byte(name))
+			}
+
+			return http.HandlerFunc(fn), nil
+		}
+	}
+
+	opts := &RouteHandlerOptions{
+		Handlers: map[string]RouteHandlerFunc{
+			"/init/": newFunc("init"),
+			"/warm/": newFunc("warm"),
+			"/lazy/": newFunc("lazy"),
+		},
+		InitRoutes: []string{"/init/"},
+		WarmRoutes: []string{"/warm/"},
+	}
+
+	h, err := RouteHandlerWithOptions(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create route handler, %v", err)
+	}
+
+	if initialized["init"] != 1 || initialized["warm"] != 0 || initialized["lazy"] != 0 {
+		t.Fatalf("Unexpected initializations after create: %v", initialized)
+	}
+
+	warmer, ok := h.(server.Warmer)
+
+	if !ok {
+		t.Fatalf("Expected route handler to implement server.Warmer")
+	}
+
+	for i := 0; i < 2; i++ {
+
+		err = warmer.Warm(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to warm route handler, %v", err)
+		}
+	}
+
+	if initialized["init"] != 1 || initialized["warm"] != 1 || initialized["lazy"] != 0 {
+		t.Fatalf("Unexpected initializations after warm: %v", initialized)
+	}
+
+	for _, path := range []string{"/init/", "/warm/", "/lazy/"} {
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Unexpected status for %s: %d", path, rec.Code)
+		}
+	}
+
+	if initialized["init"] != 1 || initialized["warm"] != 1 || initialized["lazy"] != 1 {
+		t.Fatalf("Unexpected initializations after requests: %v", initialized)
+	}
+
+	// Routes must be defined
+
+	opts.InitRoutes = []string{"/missing/"}
+
+	_, err = RouteHandlerWithOptions(opts)
+
+	if err == nil {
+		t.Fatalf("Expected undefined init route to fail")
+	}
+
+	// Init errors are returned
+
+	opts.Handlers["/broken/"] = func(ctx context.Context) (http.Handler, error) {
+		return nil, fmt.Errorf("Broken")
+	}
+
+	opts.InitRoutes = []string{"/broken/"}
+
+	_, err = RouteHandlerWithOptions(opts)
+
+	if err == nil {
+		t.Fatalf("Expected broken init route to fail")
+	}
+}
+
+func TestRouteHandlerWarmNested(t *testing.T) {
+
+	ctx := context.Background()
+
+	warmed := 0
+
+	opts := &RouteHandlerOptions{
+		Handlers: map[string]RouteHandlerFunc{
+			"/warm/": func(ctx context.Context) (http.Handler, error) {
+				warmed += 1
+				return http.NotFoundHandler(), nil
+			},
+		},
+		WarmRoutes: []string{"/warm/"},
+	}
+
+	route_handler, err := RouteHandlerWithOptions(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create route handler, %v", err)
+	}
+
+	// The route handler is hidden from the server by the ServeMux it is mounted in
+
+	mux := http.NewServeMux()
+	mux.Handle("/", route_handler)
+
+	for _, uri := range []string{"lambda://?warmer=true", "functionurl://?warmer=true"} {
+
+		warmed = 0
+
+		for i, register := range []bool{false, true} {
+
+			server_ctx := ctx
+
+			if register {
+				server_ctx = server.WithWarmers(ctx, route_handler.(server.Warmer))
+			}
+
+			s, err := server.NewServer(server_ctx, uri)
+
+			if err != nil {
+				t.Fatalf("Failed to create server %s, %v", uri, err)
+			}
+
+			lambda_handler := s.(server.LambdaHandlerServer).LambdaHandler(mux)
+
+			_, err = lambda_handler.Invoke(ctx, []byte(`{"warmer":true}`))
+
+			if err != nil {
+				t.Fatalf("Failed to invoke warm-up ping for %s, %v", uri, err)
+			}
+
+			if warmed != i {
+				t.Fatalf("Unexpected number of warmed routes for %s (registered %t): %d", uri, register, warmed)
+			}
+		}
+
+		route_handler.(RouteReloader).ReloadAll()
+	}
+}
+
+// Regular expression to match the names of "{name}" and "{name...}" wildcards in a pattern.
+var re_test_wildcard = regexp.MustCompile(`\{([^\.\}\$]+)(?:\.\.\.)?\}`)
+
+func TestRouteHandlerServeMux(t *testing.T) {
+
+	newPatternHandler := func(pattern string) http.Handler {
+
+		fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+			values := make([]string, 0)
+
+			for _, m := range re_test_wildcard.FindAllStringSubmatch(pattern, -1) {
+				values = append(values, m[1]+"="+req.PathValue(m[1]))
+			}
+
+			fmt.Fprintf(rsp, "%s %s %v", pattern, req.Pattern, values)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+
+	type testRequest struct {
+		Method string
+		Host   string
+		Target string
+	}
+
+	tests := []struct {
+		Patterns []string
+		Requests []testRequest
+	}{
+		// Literals, trailing slashes, wildcards, {$} and unclean or escaped paths
+		{
+			Patterns: []string{"/", "/a", "/a/", "/a/b", "/a/{x}", "/a/{x}/c", "/b/{rest...}", "/c/{$}", "/d/{x}/{y...}", "/e/{x}/{$}", "/f%20g/h"},
+			Requests: []testRequest{
+				{"GET", "", "/"},
+				{"GET", "", "/a"},
+				{"GET", "", "/a/"},
+				{"GET", "", "/a/b"},
+				{"GET", "", "/a/z"},
+				{"GET", "", "/a/z/c"},
+				{"GET", "", "/a/z/d"},
+				{"GET", "", "/b"},
+				{"GET", "", "/b/"},
+				{"GET", "", "/b/x/y"},
+				{"GET", "", "/b/x%2Fy/z"},
+				{"GET", "", "/c"},
+				{"GET", "", "/c?q=1"},
+				{"GET", "", "/c/"},
+				{"GET", "", "/c/x"},
+				{"GET", "", "/d/1"},
+				{"GET", "", "/d/1/"},
+				{"GET", "", "/d/1/2/3"},
+				{"GET", "", "/e/1"},
+				{"GET", "", "/e/1/"},
+				{"GET", "", "/e/1/2"},
+				{"GET", "", "/f%20g/h"},
+				{"GET", "", "/a/x%2Fy"},
+				{"GET", "", "/a/../a/b"},
+				{"GET", "", "//a"},
+				{"GET", "", "/a/./b?q=1"},
+				{"HEAD", "", "/a"},
+				{"POST", "", "/a/b"},
+			},
+		},
+		// Methods
+		{
+			Patterns: []string{"GET /m", "POST /m", "PATCH /m/{id}", "DELETE /m/{id}", "/m/{id}/x", "TRACE /t", "CONNECT /conn", "PURGE /cache/", "OPTIONS /opt", "GET /g/{$}"},
+			Requests: []testRequest{
+				{"GET", "", "/m"},
+				{"HEAD", "", "/m"},
+				{"POST", "", "/m"},
+				{"PUT", "", "/m"},
+				{"PATCH", "", "/m/1"},
+				{"DELETE", "", "/m/1"},
+				{"GET", "", "/m/1"},
+				{"GET", "", "/m/1/x"},
+				{"TRACE", "", "/t"},
+				{"GET", "", "/t"},
+				{"CONNECT", "", "/conn"},
+				{"PURGE", "", "/cache/x"},
+				{"GET", "", "/cache/x"},
+				{"PURGE", "", "/cache"},
+				{"OPTIONS", "", "/opt"},
+				{"GET", "", "/g"},
+				{"GET", "", "/g/"},
+				{"POST", "", "/g"},
+				{"GET", "", "/nothing"},
+			},
+		},
+		// Hosts
+		{
+			Patterns: []string{"/h", "example.com/h", "example.com/only/", "GET api.example.com/{x}"},
+			Requests: []testRequest{
+				{"GET", "example.com", "/h"},
+				{"GET", "example.com:8080", "/h"},
+				{"GET", "other.com", "/h"},
+				{"GET", "example.com", "/only/x"},
+				{"GET", "example.com", "/only"},
+				{"GET", "other.com", "/only/x"},
+				{"GET", "api.example.com", "/v"},
+				{"HEAD", "api.example.com", "/v"},
+				{"POST", "api.example.com", "/v"},
+				{"POST", "api.example.com", "/h"},
+			},
+		},
+		// Most specific pattern wins
+		{
+			Patterns: []string{"/a/b/z", "/a/{x}/c", "GET /a/{x}/{$}", "/a/{rest...}", "/{x}", "/x"},
+			Requests: []testRequest{
+				{"GET", "", "/a/b/z"},
+				{"GET", "", "/a/b/c"},
+				{"HEAD", "", "/a/b/c"},
+				{"GET", "", "/a/q"},
+				{"GET", "", "/a/q/"},
+				{"HEAD", "", "/a/q/"},
+				{"POST", "", "/a/q/"},
+				{"POST", "", "/a/b/z"},
+				{"GET", "", "/a"},
+				{"GET", "", "/x"},
+				{"GET", "", "/y"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+
+		mux := http.NewServeMux()
+		handlers := make(map[string]RouteHandlerFunc)
+
+		for _, p := range test.Patterns {
+
+			h := newPatternHandler(p)
+			mux.Handle(p, h)
+
+			handlers[p] = func(ctx context.Context) (http.Handler, error) {
+				return h, nil
+			}
+		}
+
+		route_handler, err := RouteHandler(handlers)
+
+		if err != nil {
+			t.Fatalf("Failed to create route handler for %v, %v", test.Patterns, err)
+		}
+
+		for _, r := range test.Requests {
+
+			newRequest := func() *http.Request {
+
+				req := httptest.NewRequest(r.Method, r.Target, nil)
+
+				if r.Host != "" {
+					req.Host = r.Host
+				}
+
+				return req
+			}
+
+			expected := httptest.NewRecorder()
+			mux.ServeHTTP(expected, newRequest())
+
+			rec := httptest.NewRecorder()
+			route_handler.ServeHTTP(rec, newRequest())
+
+			label := fmt.Sprintf("%s %s%s", r.Method, r.Host, r.Target)
+
+			// The status code used for redirects has changed between Go releases so only require that both are redirects
+
+			expected_code := expected.Code
+			code := rec.Code
+
+			if expected_code >= 300 && expected_code < 400 && code >= 300 && code < 400 {
+				code = expected_code
+			}
+
+			if code != expected_code {
+				t.Fatalf("Unexpected status code for %s. Expected %d but got %d", label, expected_code, code)
+			}
+
+			for _, k := range []string{"Location", "Allow"} {
+
+				if rec.Header().Get(k) != expected.Header().Get(k) {
+					t.Fatalf("Unexpected %s header for %s. Expected '%s' but got '%s'", k, label, expected.Header().Get(k), rec.Header().Get(k))
+				}
+			}
+
+			if rec.Body.String() != expected.Body.String() {
+				t.Fatalf("Unexpected body for %s. Expected '%s' but got '%s'", label, expected.Body.String(), rec.Body.String())
+			}
+		}
+	}
+}
+
+func TestRouteHandlerInvalidPatterns(t *testing.T) {
+
+	null_func := func(ctx context.Context) (http.Handler, error) {
+		return http.NotFoundHandler(), nil
+	}
+
+	invalid := []string{
+		"",
+		"GET",
+		"/{x",
+		"/a{x}",
+		"/{}",
+		"/{1x}",
+		"/{x}/{x}",
+		"/{$}/a",
+		"/{a...}/b",
+		"BAD@ /a",
+		"GET /a/../b",
+		"example{x}/a",
+	}
+
+	for _, p := range invalid {
+
+		// Make sure http.ServeMux agrees
+
+		func() {
+
+			defer func() {
+				if r := recover(); r == nil {
+					t.Fatalf("Expected http.ServeMux to reject '%s'", p)
+				}
+			}()
+
+			http.NewServeMux().Handle(p, http.NotFoundHandler())
+		}()
+
+		_, err := RouteHandler(map[string]RouteHandlerFunc{
+			p: null_func,
+		})
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to be rejected", p)
+		}
+	}
+}
+
+func TestRouteHandlerConflicts(t *testing.T) {
+
+	null_func := func(ctx context.Context) (http.Handler, error) {
+		return http.NotFoundHandler(), nil
+	}
+
+	tests := []struct {
+		Patterns  []string
+		Conflicts bool
+	}{
+		{[]string{"/a", "GET /a"}, false},
+		{[]string{"/a/", "GET /a/b"}, false},
+		{[]string{"/a/{x}", "/a/b"}, false},
+		{[]string{"/{x}", "example.com/{y}"}, false},
+		{[]string{"GET /a", "HEAD /a"}, false},
+		{[]string{"GET /a/{x}", "POST /a/{x}"}, false},
+		{[]string{"/a/{$}", "/a/{x}"}, false},
+		{[]string{"/a/{x}", "/a/{y}"}, true},
+		{[]string{"/a/", "/a/{rest...}"}, true},
+		{[]string{"GET /a/", "/a/b"}, true},
+		{[]string{"/a/{x}", "/{x}/b"}, true},
+		{[]string{"/{x}/b/{$}", "/a/{rest...}"}, true},
+		{[]string{"example.com/{x}", "example.com/{y}"}, true},
+		{[]string{"GET /a", "GET  /a"}, true},
+	}
+
+	for _, test := range tests {
+
+		mux_conflicts := func() (conflicts bool) {
+
+			defer func() {
+				if r := recover(); r != nil {
+					conflicts = true
+				}
+			}()
+
+			mux := http.NewServeMux()
+
+			for _, p := range test.Patterns {
+				mux.Handle(p, http.NotFoundHandler())
+			}
+
+			return false
+		}()
+
+		if mux_conflicts != test.Conflicts {
+			t.Fatalf("Unexpected http.ServeMux conflict for %v", test.Patterns)
+		}
+
+		handlers := make(map[string]RouteHandlerFunc)
+
+		for _, p := range test.Patterns {
+			handlers[p] = null_func
+		}
+
+		_, err := RouteHandler(handlers)
+
+		if !test.Conflicts {
+
+			if err != nil {
+				t.Fatalf("Unexpected error for %v, %v", test.Patterns, err)
+			}
+
+			continue
+		}
+
+		if err == nil {
+			t.Fatalf("Expected %v to conflict", test.Patterns)
+		}
+
+		for _, p := range test.Patterns {
+
+			if !strings.Contains(err.Error(), "'"+p+"'") {
+				t.Fatalf("Expected error for %v to mention '%s': %v", test.Patterns, p, err)
+			}
+		}
+	}
+
+	// All the invalid patterns and conflicting pairs are reported
+
+	handlers := map[string]RouteHandlerFunc{
+		"/a/{x}":  null_func,
+		"/a/{y}":  null_func,
+		"/a/{z}":  null_func,
+		"/b/{x":   null_func,
+		"/c/{$}/": null_func,
+	}
+
+	_, err := RouteHandler(handlers)
+
+	if err == nil {
+		t.Fatalf("Expected errors")
+	}
+
+	lines := strings.Split(err.Error(), "\n")
+
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 errors, got %d: %v", len(lines), err)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE-GO file.
+
+// This file adapts the routing tree used to match requests against patterns from src/net/http/routing_tree.go in the Go
+// source tree at the go1.22.0 tag: https://go.googlesource.com/go/+/refs/tags/go1.22.0/src/net/http/routing_tree.go
+
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// routeNode is a node in the tree of patterns used by `routeHandler` to match requests. The first level of the tree
+// branches on the host of each pattern, the second level on its method and the remaining levels on the segments of its
+// path. Leaf nodes hold a single pattern. The tree is built once, when the route handler is created, and is never modified
+// after that so it can be read concurrently without any locking.
+type routeNode struct {
+	// pattern is the pattern for a leaf node.
+	pattern *routePattern
+	// children are the child nodes keyed by host, method, literal path segment or "/" for a trailing "{$}".
+	children map[string]*routeNode
+	// empty is the child node for an empty host or method or for a single wildcard path segment.
+	empty *routeNode
+	// multi is the child node for a multi-segment wildcard. It is always a leaf node.
+	multi *routeNode
+}
+
+// newRouteTree returns a new `routeNode` instance which is the root of a tree containing 'patterns'. The patterns are
+// expected not to conflict with one another.
+func newRouteTree(patterns []*routePattern) *routeNode {
+
+	root := &routeNode{}
+
+	for _, p := range patterns {
+
+		n := root.addChild(p.host).addChild(p.method)
+
+		for _, seg := range p.segments {
+
+			switch {
+			case seg.multi:
+				n.multi = &routeNode{}
+				n = n.multi
+			case seg.wild:
+				n = n.addChild("")
+			default:
+				n = n.addChild(seg.s)
+			}
+		}
+
+		n.pattern = p
+	}
+
+	return root
+}
+
+// addChild returns the child of 'n' for 'key', creating it if necessary.
+func (n *routeNode) addChild(key string) *routeNode {
+
+	if key == "" {
+
+		if n.empty == nil {
+			n.empty = &routeNode{}
+		}
+
+		return n.empty
+	}
+
+	if n.children == nil {
+		n.children = make(map[string]*routeNode)
+	}
+
+	c, ok := n.children[key]
+
+	if !ok {
+		c = &routeNode{}
+		n.children[key] = c
+	}
+
+	return c
+}
+
+// child returns the child of 'n' for 'key' or nil if there isn't one.
+func (n *routeNode) child(key string) *routeNode {
+
+	if key == "" {
+		return n.empty
+	}
+
+	return n.children[key]
+}
+
+// match returns the pattern with the highest precedence which matches 'host', 'method' and 'escaped_path' and the
+// values of its wildcards. Patterns with a host take precedence over those without one.
+func (n *routeNode) match(host string, method string, escaped_path string) (*routePattern, []string) {
+
+	if host != "" {
+
+		p, values := n.child(host).matchMethodAndPath(method, escaped_path)
+
+		if p != nil {
+			return p, values
+		}
+	}
+
+	return n.empty.matchMethodAndPath(method, escaped_path)
+}
+
+// matchMethodAndPath matches 'method' and 'escaped_path' against the children of 'n', a host node. Patterns with the
+// same method take precedence over "GET" patterns matching a "HEAD" request which take precedence over patterns
+// without a method.
+func (n *routeNode) matchMethodAndPath(method string, escaped_path string) (*routePattern, []string) {
+
+	if n == nil {
+		return nil, nil
+	}
+
+	p, values := n.child(method).matchPath(escaped_path, nil)
+
+	if p != nil {
+		return p, values
+	}
+
+	if method == http.MethodHead {
+
+		p, values := n.child(http.MethodGet).matchPath(escaped_path, nil)
+
+		if p != nil {
+			return p, values
+		}
+	}
+
+	return n.empty.matchPath(escaped_path, nil)
+}
+
+// matchPath matches 'escaped_path' against 'n' and its descendants, appending the values of any wildcards to 'values'.
+// At each level literal segments are tried before single wildcards which are tried before multi-segment wildcards, which
+// is the order of precedence for patterns that don't conflict.
+func (n *routeNode) matchPath(escaped_path string, values []string) (*routePattern, []string) {
+
+	if n == nil {
+		return nil, nil
+	}
+
+	if escaped_path == "" {
+
+		if n.pattern == nil {
+			return nil, nil
+		}
+
+		return n.pattern, values
+	}
+
+	seg, rest := firstRouteSegment(escaped_path)
+
+	// Look up literal children directly since an empty segment, in an uncleaned CONNECT path, is not a wildcard
+
+	p, m := n.children[seg].matchPath(rest, values)
+
+	if p != nil {
+		return p, m
+	}
+
+	// Single wildcards don't match trailing slashes
+
+	if seg != "/" && n.empty != nil {
+
+		p, m := n.empty.matchPath(rest, append(values, seg))
+
+		if p != nil {
+			return p, m
+		}
+	}
+
+	if n.multi != nil {
+
+		// Trailing slashes are anonymous multi-segment wildcards whose values aren't recorded
+
+		if n.multi.pattern.lastSegment().s != "" {
+			values = append(values, unescapeRoutePath(escaped_path[1:]))
+		}
+
+		return n.multi.pattern, values
+	}
+
+	return nil, nil
+}
+
+// allowedMethods returns the sorted list of methods for which there is a pattern matching 'host' and 'escaped_path',
+// or 'escaped_path' with a trailing slash. If "GET" is allowed so is "HEAD".
+func (n *routeNode) allowedMethods(host string, escaped_path string) []string {
+
+	methods := make([]string, 0)
+
+	if host != "" {
+		methods = n.child(host).appendMethods(escaped_path, methods)
+	}
+
+	methods = n.empty.appendMethods(escaped_path, methods)
+
+	if slices.Contains(methods, http.MethodGet) && !slices.Contains(methods, http.MethodHead) {
+		methods = append(methods, http.MethodHead)
+	}
+
+	slices.Sort(methods)
+	return methods
+}
+
+// appendMethods appends the methods of the children of 'n', a host node, which match 'escaped_path', or 'escaped_path'
+// with a trailing slash, to 'methods'. Patterns without a method are ignored since they would have matched any method.
+func (n *routeNode) appendMethods(escaped_path string, methods []string) []string {
+
+	if n == nil {
+		return methods
+	}
+
+	paths := []string{escaped_path}
+
+	if !strings.HasSuffix(escaped_path, "/") {
+		paths = append(paths, escaped_path+"/")
+	}
+
+	for method, method_n := range n.children {
+
+		if slices.Contains(methods, method) {
+			continue
+		}
+
+		for _, path := range paths {
+
+			p, _ := method_n.matchPath(path, nil)
+
+			if p != nil {
+				methods = append(methods, method)
+				break
+			}
+		}
+	}
+
+	return methods
+}
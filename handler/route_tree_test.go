@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// routeTreeTestPatterns returns 'count' non-conflicting patterns, using a mix of methods, wildcards and trailing
+// slashes, and a request path matching each of them.
+func routeTreeTestPatterns(count int) ([]string, []string) {
+
+	patterns := make([]string, count)
+	paths := make([]string, count)
+
+	for i := 0; i < count; i++ {
+
+		switch i % 4 {
+		case 0:
+			patterns[i] = fmt.Sprintf("GET /api/v1/things%d/{id}", i)
+			paths[i] = fmt.Sprintf("/api/v1/things%d/1234", i)
+		case 1:
+			patterns[i] = fmt.Sprintf("POST /api/v1/things%d/{id}/items", i)
+			paths[i] = fmt.Sprintf("/api/v1/things%d/1234/items", i)
+		case 2:
+			patterns[i] = fmt.Sprintf("/static%d/", i)
+			paths[i] = fmt.Sprintf("/static%d/css/main.css", i)
+		default:
+			patterns[i] = fmt.Sprintf("/files%d/{path...}", i)
+			paths[i] = fmt.Sprintf("/files%d/a/b/c.txt", i)
+		}
+	}
+
+	return patterns, paths
+}
+
+// routeTreeTestRequests returns a request for each of 'paths' using the method expected by the pattern at the same
+// position in the list returned by `routeTreeTestPatterns`.
+func routeTreeTestRequests(paths []string) []*http.Request {
+
+	requests := make([]*http.Request, len(paths))
+
+	for i, path := range paths {
+
+		method := http.MethodGet
+
+		if i%4 == 1 {
+			method = http.MethodPost
+		}
+
+		requests[i] = httptest.NewRequest(method, path, nil)
+	}
+
+	return requests
+}
+
+// discardResponseWriter is an `http.ResponseWriter` that discards everything written to it.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(code int) {}
+
+func TestRouteTree(t *testing.T) {
+
+	patterns, paths := routeTreeTestPatterns(1000)
+
+	handlers := make(map[string]RouteHandlerFunc)
+	mux := http.NewServeMux()
+
+	for _, p := range patterns {
+
+		fn := func(rsp http.ResponseWriter, req *http.Request) {
+			rsp.Write([]byte(req.Pattern))
+		}
+
+		handlers[p] = func(ctx context.Context) (http.Handler, error) {
+			return http.HandlerFunc(fn), nil
+		}
+
+		mux.HandleFunc(p, fn)
+	}
+
+	route_handler, err := RouteHandler(handlers)
+
+	if err != nil {
+		t.Fatalf("Failed to create route handler, %v", err)
+	}
+
+	requests := routeTreeTestRequests(paths)
+
+	// Also check requests that don't match anything, or only match with a different method
+
+	requests = append(requests,
+		httptest.NewRequest(http.MethodGet, "/api/v1/things0", nil),
+		httptest.NewRequest(http.MethodGet, "/api/v1/things1/1234/items", nil),
+		httptest.NewRequest(http.MethodGet, "/static2", nil),
+		httptest.NewRequest(http.MethodGet, "/missing", nil),
+	)
+
+	for _, req := range requests {
+
+		expected := httptest.NewRecorder()
+		mux.ServeHTTP(expected, req.Clone(req.Context()))
+
+		actual := httptest.NewRecorder()
+		route_handler.ServeHTTP(actual, req.Clone(req.Context()))
+
+		if actual.Code != expected.Code {
+			t.Fatalf("Unexpected status code for %s %s, expected %d but got %d", req.Method, req.URL.Path, expected.Code, actual.Code)
+		}
+
+		if actual.Body.String() != expected.Body.String() {
+			t.Fatalf("Unexpected body for %s %s, expected '%s' but got '%s'", req.Method, req.URL.Path, expected.Body.String(), actual.Body.String())
+		}
+
+		if actual.Header().Get("Allow") != expected.Header().Get("Allow") {
+			t.Fatalf("Unexpected Allow header for %s %s, expected '%s' but got '%s'", req.Method, req.URL.Path, expected.Header().Get("Allow"), actual.Header().Get("Allow"))
+		}
+	}
+}
+
+func BenchmarkRouteHandler(b *testing.B) {
+
+	for _, count := range []int{10, 100, 1000} {
+
+		patterns, paths := routeTreeTestPatterns(count)
+		requests := routeTreeTestRequests(paths)
+
+		fn := func(rsp http.ResponseWriter, req *http.Request) {}
+
+		handlers := make(map[string]RouteHandlerFunc)
+		mux := http.NewServeMux()
+
+		for _, p := range patterns {
+
+			handlers[p] = func(ctx context.Context) (http.Handler, error) {
+				return http.HandlerFunc(fn), nil
+			}
+
+			mux.HandleFunc(p, fn)
+		}
+
+		route_handler, err := RouteHandlerWithOptions(&RouteHandlerOptions{
+			Handlers:   handlers,
+			InitRoutes: patterns,
+			Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		})
+
+		if err != nil {
+			b.Fatalf("Failed to create route handler, %v", err)
+		}
+
+		for _, bench := range []struct {
+			name    string
+			handler http.Handler
+		}{
+			{"RouteHandler", route_handler},
+			{"ServeMux", mux},
+		} {
+
+			b.Run(fmt.Sprintf("routes=%d/%s", count, bench.name), func(b *testing.B) {
+
+				rsp := &discardResponseWriter{header: make(http.Header)}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					bench.handler.ServeHTTP(rsp, requests[i%len(requests)])
+				}
+			})
+
+			b.Run(fmt.Sprintf("routes=%d/%s/parallel", count, bench.name), func(b *testing.B) {
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				b.RunParallel(func(pb *testing.PB) {
+
+					rsp := &discardResponseWriter{header: make(http.Header)}
+					i := 0
+
+					for pb.Next() {
+						req := requests[i%len(requests)]
+						bench.handler.ServeHTTP(rsp, req.Clone(req.Context()))
+						i += 1
+					}
+				})
+			})
+		}
+	}
+}
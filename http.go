@@ -10,12 +10,14 @@ package server
 import (
 	"context"
 	"errors"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -32,6 +34,8 @@ type HTTPServer struct {
 	http_server *http.Server
 	cert        string
 	key         string
+	after_wg    *sync.WaitGroup
+	logger      *slog.Logger
 }
 
 // NewHTTPServer returns a new `HTTPServer` instance configured by 'uri' which is
@@ -57,57 +61,8 @@ func NewHTTPServer(ctx context.Context, uri string) (Server, error) {
 
 	u.Scheme = "http"
 
-	read_timeout := 2 * time.Second
-	write_timeout := 10 * time.Second
-	idle_timeout := 15 * time.Second
-	header_timeout := 2 * time.Second
-
 	q := u.Query()
 
-	if q.Get("read_timeout") != "" {
-
-		to, err := strconv.Atoi(q.Get("read_timeout"))
-
-		if err != nil {
-			return nil, err
-		}
-
-		read_timeout = time.Duration(to) * time.Second
-	}
-
-	if q.Get("write_timeout") != "" {
-
-		to, err := strconv.Atoi(q.Get("write_timeout"))
-
-		if err != nil {
-			return nil, err
-		}
-
-		write_timeout = time.Duration(to) * time.Second
-	}
-
-	if q.Get("idle_timeout") != "" {
-
-		to, err := strconv.Atoi(q.Get("idle_timeout"))
-
-		if err != nil {
-			return nil, err
-		}
-
-		idle_timeout = time.Duration(to) * time.Second
-	}
-
-	if q.Get("header_timeout") != "" {
-
-		to, err := strconv.Atoi(q.Get("header_timeout"))
-
-		if err != nil {
-			return nil, err
-		}
-
-		header_timeout = time.Duration(to) * time.Second
-	}
-
 	tls_cert := q.Get("cert")
 	tls_key := q.Get("key")
 
@@ -135,19 +90,23 @@ func NewHTTPServer(ctx context.Context, uri string) (Server, error) {
 		// pass
 	}
 
-	srv := &http.Server{
-		Addr:              u.Host,
-		ReadTimeout:       read_timeout,
-		WriteTimeout:      write_timeout,
-		IdleTimeout:       idle_timeout,
-		ReadHeaderTimeout: header_timeout,
+	logger := LoggerFromContext(ctx)
+
+	srv, err := newHTTPServer(u, logger)
+
+	if err != nil {
+		return nil, err
 	}
 
+	srv.Addr = u.Host
+
 	server := HTTPServer{
 		url:         u,
 		http_server: srv,
 		cert:        tls_cert,
 		key:         tls_key,
+		after_wg:    new(sync.WaitGroup),
+		logger:      logger,
 	}
 
 	return &server, nil
@@ -158,11 +117,14 @@ func (s *HTTPServer) Address() string {
 
 	u, _ := url.Parse(s.url.String())
 	u.RawQuery = ""
+	u.ForceQuery = false
 
 	return u.String()
 }
 
-// ListenAndServe starts the server and listens for requests using 'mux' for routing.
+// ListenAndServe starts the server and listens for requests using 'mux' for routing. The server will
+// be shut down, after draining any in-flight requests and waiting for any functions queued with `AfterResponse`
+// to complete, when an interrupt signal is received or 'ctx' is cancelled.
 func (s *HTTPServer) ListenAndServe(ctx context.Context, mux http.Handler) error {
 
 	idleConnsClosed := make(chan struct{})
@@ -171,20 +133,25 @@ func (s *HTTPServer) ListenAndServe(ctx context.Context, mux http.Handler) error
 
 		sigint := make(chan os.Signal, 1)
 		signal.Notify(sigint, os.Interrupt)
-		<-sigint
+		defer signal.Stop(sigint)
+
+		select {
+		case <-sigint:
+		case <-ctx.Done():
+		}
 
 		// We received an interrupt signal, shut down.
 
 		err := s.http_server.Shutdown(context.Background())
 
 		if err != nil {
-			log.Printf("HTTP server shutdown error: %v", err)
+			s.logger.Error("Failed to shut down server", "address", s.Address(), "error", err)
 		}
 
 		close(idleConnsClosed)
 	}()
 
-	s.http_server.Handler = mux
+	s.http_server.Handler = newAfterResponseHandler(mux, s.after_wg, s.logger)
 
 	var err error
 
@@ -199,5 +166,81 @@ func (s *HTTPServer) ListenAndServe(ctx context.Context, mux http.Handler) error
 	}
 
 	<-idleConnsClosed
+
+	// Wait for any work queued by handlers to run after their responses were sent
+
+	s.after_wg.Wait()
 	return nil
 }
+
+// newHTTPServer returns a new `http.Server` instance whose timeouts are derived from the
+// query parameters in 'u'. See `NewHTTPServer` for details. Errors logged by the server, and
+// the context of each request it serves, use 'logger'.
+func newHTTPServer(u *url.URL, logger *slog.Logger) (*http.Server, error) {
+
+	read_timeout := 2 * time.Second
+	write_timeout := 10 * time.Second
+	idle_timeout := 15 * time.Second
+	header_timeout := 2 * time.Second
+
+	q := u.Query()
+
+	if q.Get("read_timeout") != "" {
+
+		to, err := strconv.Atoi(q.Get("read_timeout"))
+
+		if err != nil {
+			return nil, err
+		}
+
+		read_timeout = time.Duration(to) * time.Second
+	}
+
+	if q.Get("write_timeout") != "" {
+
+		to, err := strconv.Atoi(q.Get("write_timeout"))
+
+		if err != nil {
+			return nil, err
+		}
+
+		write_timeout = time.Duration(to) * time.Second
+	}
+
+	if q.Get("idle_timeout") != "" {
+
+		to, err := strconv.Atoi(q.Get("idle_timeout"))
+
+		if err != nil {
+			return nil, err
+		}
+
+		idle_timeout = time.Duration(to) * time.Second
+	}
+
+	if q.Get("header_timeout") != "" {
+
+		to, err := strconv.Atoi(q.Get("header_timeout"))
+
+		if err != nil {
+			return nil, err
+		}
+
+		header_timeout = time.Duration(to) * time.Second
+	}
+
+	base_ctx := func(l net.Listener) context.Context {
+		return WithLogger(context.Background(), logger)
+	}
+
+	srv := &http.Server{
+		ReadTimeout:       read_timeout,
+		WriteTimeout:      write_timeout,
+		IdleTimeout:       idle_timeout,
+		ReadHeaderTimeout: header_timeout,
+		ErrorLog:          newErrorLog(logger),
+		BaseContext:       base_ctx,
+	}
+
+	return srv, nil
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"testing"
+	"time"
 )
 
 func testHandler() http.Handler {
@@ -20,7 +21,7 @@ func testHandler() http.Handler {
 
 func TestHTTPServer(t *testing.T) {
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
 
 	s, err := NewServer(ctx, "http://localhost:8080")
 
@@ -28,15 +29,40 @@ func TestHTTPServer(t *testing.T) {
 		t.Fatalf("Failed to create server, %v", err)
 	}
 
+	done_ch := make(chan struct{})
+
 	go func() {
+
 		err := s.ListenAndServe(ctx, testHandler())
 
 		if err != nil {
 			log.Fatalf("Failed to start server, %v", err)
 		}
+
+		close(done_ch)
 	}()
 
-	rsp, err := http.Get("http://localhost:8080")
+	// Stop the server so the port is free if the test is run again
+
+	defer func() {
+		cancel()
+		<-done_ch
+	}()
+
+	var rsp *http.Response
+
+	// Wait for the server to start listening
+
+	for i := 0; i < 50; i++ {
+
+		rsp, err = http.Get("http://localhost:8080")
+
+		if err == nil {
+			break
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
 
 	if err != nil {
 		t.Fatalf("Failed to GET request, %v", err)
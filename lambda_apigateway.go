@@ -2,12 +2,18 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	_ "log"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
 
 	"github.com/akrylysov/algnhsa"
+	"github.com/aws/aws-lambda-go/lambda"
 )
 
 func init() {
@@ -19,7 +25,11 @@ func init() {
 type LambdaServer struct {
 	Server
 	url          *url.URL
-	binary_types []string
+	encoder      *lambdaResponseEncoder
+	event_router *lambdaEventRouter
+	warmer       *lambdaWarmer
+	path_prefix  *lambdaPathPrefix
+	logger       *slog.Logger
 }
 
 // NewLambdaServer returns a new `LambdaServer` instance configured by 'uri' which is
@@ -28,7 +38,24 @@ type LambdaServer struct {
 //	lambda://?{PARAMETERS}
 //
 // Valid parameters are:
-// * `binary_type={MIMETYPE}` One or more mimetypes to be served by AWS API Gateway as binary content types.
+// * `binary_type={MIMETYPE}` One or more mimetypes to be served by AWS API Gateway as binary content types. Parameters
+// are ignored when matching and mimetypes may be wildcards in the form of "{TYPE}/*" or "*/*".
+// * `binary_auto={BOOLEAN}` If true any response body that isn't valid UTF-8 or has a non-text content type will be served as binary content.
+// * `compress={BOOLEAN}` If true textual response bodies are compressed using "br" or "gzip" when the client's "Accept-Encoding" header allows it. Default is false.
+// * `max_payload_size={BYTES}` The maximum size of a request or response payload. Larger requests are rejected with a 413 Request Entity Too Large
+// error and larger responses are replaced by a 502 Bad Gateway error. Default is 6MB.
+// * `events={EVENT_TYPE}` One or more non-HTTP event types, separated by commas, to route to the `http.Handler` as POST requests. Valid options are
+// "sqs", "sns", "eventbridge", "schedule" and "s3". Each record is sent as a separate request with the record in the body and its metadata in
+// "X-Lambda-Event-*" headers. SQS messages whose requests return a non-2xx status code are reported as batch item failures; other event types return an error.
+// * `events_path={PATH}` The path prefix that requests derived from non-HTTP events are sent to, as "{PATH}/{EVENT_TYPE}". Default is "/_lambda/events".
+// When the `events` parameter is set HTTP requests for paths under this prefix receive a 404 response and "X-Lambda-Event-*" headers are removed
+// from all other HTTP requests, so handlers can trust that requests with those headers were derived from non-HTTP events.
+// * `warmer={BOOLEAN}` If true warm-up pings (events with a "warmer" property set to true, "serverless-plugin-warmup" events and, unless
+// they are routed using the `events` parameter, scheduled events) are answered without serving a request. If the handler implements the
+// `Warmer` interface its `Warm` method is invoked, as are those of any `Warmer` instances registered using `WithWarmers`. Default is false.
+// * `strip_prefix={PATH}` A path prefix to remove from request paths, if present, before they are routed. For example, a custom domain base path mapping.
+// * `stage_prefix=auto` If present the API Gateway stage, or custom domain base path mapping, is removed from request paths before they are routed.
+// The prefixes that were removed, or that clients used but are not present in request paths, are available using `PublicPrefixFromContext`.
 func NewLambdaServer(ctx context.Context, uri string) (Server, error) {
 
 	u, err := url.Parse(uri)
@@ -37,16 +64,45 @@ func NewLambdaServer(ctx context.Context, uri string) (Server, error) {
 		return nil, fmt.Errorf("Failed to parse URI, %w", err)
 	}
 
-	server := LambdaServer{
-		url: u,
+	encoder, err := newLambdaResponseEncoder(u.Query())
+
+	if err != nil {
+		return nil, err
 	}
 
-	q := u.Query()
+	event_router, err := newLambdaEventRouter(u.Query())
 
-	binary_types, ok := q["binary_type"]
+	if err != nil {
+		return nil, err
+	}
+
+	path_prefix, err := newLambdaPathPrefix(u.Query())
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger := LoggerFromContext(ctx)
+
+	warmer, err := newLambdaWarmer(ctx, u.Query(), logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Scheduled events that are routed to the http.Handler are not warm-up pings
+
+	if warmer != nil && event_router != nil && slices.Contains(event_router.sources, LAMBDA_EVENT_SCHEDULE) {
+		warmer.schedule = false
+	}
 
-	if ok {
-		server.binary_types = binary_types
+	server := LambdaServer{
+		url:          u,
+		encoder:      encoder,
+		event_router: event_router,
+		warmer:       warmer,
+		path_prefix:  path_prefix,
+		logger:       logger,
 	}
 
 	return &server, nil
@@ -60,12 +116,293 @@ func (s *LambdaServer) Address() string {
 // ListenAndServe starts the serve and listens for requests using 'mux' for routing.
 func (s *LambdaServer) ListenAndServe(ctx context.Context, mux http.Handler) error {
 
-	lambda_opts := new(algnhsa.Options)
+	// This is the equivalent of algnhsa.ListenAndServe but with a base context that includes the server's logger
 
-	if len(s.binary_types) > 0 {
-		lambda_opts.BinaryContentTypes = s.binary_types
-	}
+	lambda_ctx := WithLogger(ctx, s.logger)
 
-	algnhsa.ListenAndServe(mux, lambda_opts)
+	lambda.StartWithOptions(s.lambdaHandlerFunc(mux), lambda.WithContext(lambda_ctx))
 	return nil
 }
+
+// LambdaHandler returns the `lambda.Handler` instance used by 's' to translate API Gateway v1, v2 and ALB events
+// in to HTTP requests served by 'mux' and the resulting HTTP responses back in to Lambda responses. If 's' was
+// configured with the `events` parameter the handler also routes those (non-HTTP) events to 'mux' and if it was configured
+// with the `warmer` parameter the handler answers warm-up pings itself. Functions queued with `AfterResponse` are run
+// once the response has been read.
+func (s *LambdaServer) LambdaHandler(mux http.Handler) lambda.Handler {
+	return lambda.NewHandler(s.lambdaHandlerFunc(mux))
+}
+
+// lambdaHandlerFunc returns the Lambda handler function for 's' which runs any functions queued with `AfterResponse`
+// after the response has been posted to the Lambda Runtime API.
+func (s *LambdaServer) lambdaHandlerFunc(mux http.Handler) func(context.Context, json.RawMessage) (io.Reader, error) {
+
+	h := s.lambdaHandler(mux)
+
+	fn := func(ctx context.Context, payload json.RawMessage) ([]byte, error) {
+		return h.Invoke(ctx, payload)
+	}
+
+	return withAfterResponse(fn, s.logger)
+}
+
+// lambdaHandler returns the `lambda.Handler` instance which translates Lambda events in to HTTP requests served by 'mux'.
+func (s *LambdaServer) lambdaHandler(mux http.Handler) lambda.Handler {
+
+	// algnhsa only matches binary content types exactly so have it base64-encode every response body
+	// and let lambdaResponseHandler decide which ones should remain encoded.
+
+	lambda_opts := &algnhsa.Options{
+		BinaryContentTypes: []string{"*/*"},
+	}
+
+	// Attach the original event, and the identity derived from it, to the request context
+	// so they can be retrieved using `LambdaEventFromContext` and `LambdaIdentityFromContext`.
+
+	var lambda_mux http.Handler = mux
+
+	// Don't let HTTP requests impersonate requests derived from non-HTTP events
+
+	if s.event_router != nil {
+		lambda_mux = s.event_router.httpHandler(lambda_mux)
+	}
+
+	if s.path_prefix != nil {
+		lambda_mux = s.path_prefix.handler(lambda_mux)
+	}
+
+	lambda_mux = newLambdaEventHandler(lambda_mux)
+
+	lambda_handler := &lambdaResponseHandler{
+		handler: algnhsa.New(lambda_mux, lambda_opts),
+		encoder: s.encoder,
+		logger:  s.logger,
+	}
+
+	var h lambda.Handler = lambda_handler
+
+	if s.event_router != nil {
+
+		h = &lambdaEventSourceHandler{
+			handler: h,
+			mux:     mux,
+			router:  s.event_router,
+			logger:  s.logger,
+		}
+	}
+
+	if s.warmer != nil {
+
+		h = &lambdaWarmerHandler{
+			handler: h,
+			mux:     mux,
+			warmer:  s.warmer,
+		}
+	}
+
+	return h
+}
+
+// LambdaEventTypes returns the list of event types that 's' can handle.
+func (s *LambdaServer) LambdaEventTypes() []string {
+	return []string{
+		LAMBDA_EVENT_APIGATEWAY_V2,
+		LAMBDA_EVENT_APIGATEWAY_V1,
+		LAMBDA_EVENT_ALB,
+	}
+}
+
+// lambdaRequest contains the properties, common to API Gateway v1, v2 and ALB events, needed to post-process responses.
+type lambdaRequest struct {
+	HTTPMethod        string              `json:"httpMethod"`
+	Path              string              `json:"path"`
+	RawPath           string              `json:"rawPath"`
+	Headers           map[string]string   `json:"headers"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+	RequestContext    struct {
+		HTTP struct {
+			Method string `json:"method"`
+		} `json:"http"`
+	} `json:"requestContext"`
+}
+
+// header returns the first value for the header 'key' in 'req'.
+func (req *lambdaRequest) header(key string) string {
+	return newLambdaHeader(req.Headers, req.MultiValueHeaders).Get(key)
+}
+
+// method returns the HTTP method of 'req'.
+func (req *lambdaRequest) method() string {
+
+	if req.HTTPMethod != "" {
+		return req.HTTPMethod
+	}
+
+	return req.RequestContext.HTTP.Method
+}
+
+// path returns the URL path of 'req'.
+func (req *lambdaRequest) path() string {
+
+	if req.RawPath != "" {
+		return req.RawPath
+	}
+
+	return req.Path
+}
+
+// lambdaResponse mirrors the combined API Gateway v1, v2 and ALB response produced by `algnhsa`.
+type lambdaResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Cookies           []string            `json:"cookies,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded,omitempty"`
+}
+
+// header returns the headers of 'rsp' as an `http.Header` instance.
+func (rsp *lambdaResponse) header() http.Header {
+	return newLambdaHeader(rsp.Headers, rsp.MultiValueHeaders)
+}
+
+// setHeader replaces the headers of 'rsp' with 'header'. API Gateway v1 and ALB responses use multi-value headers
+// while API Gateway v2 responses, which do not support them, join multiple values with a comma.
+func (rsp *lambdaResponse) setHeader(header http.Header) {
+
+	if rsp.MultiValueHeaders != nil {
+		rsp.MultiValueHeaders = header
+		rsp.Headers = nil
+		return
+	}
+
+	rsp.Headers = make(map[string]string)
+
+	for k, v := range header {
+		rsp.Headers[k] = strings.Join(v, ",")
+	}
+}
+
+// newLambdaHeader returns a new `http.Header` instance derived from the single and multi-value headers of a Lambda event or response.
+func newLambdaHeader(headers map[string]string, multi_value_headers map[string][]string) http.Header {
+
+	header := make(http.Header)
+
+	for k, v := range headers {
+		header.Add(k, v)
+	}
+
+	for k, values := range multi_value_headers {
+
+		if len(headers) > 0 {
+			header.Del(k)
+		}
+
+		for _, v := range values {
+			header.Add(k, v)
+		}
+	}
+
+	return header
+}
+
+// lambdaResponseHandler implements the `lambda.Handler` interface post-processing the (base64-encoded)
+// responses produced by an `algnhsa` handler.
+type lambdaResponseHandler struct {
+	handler lambda.Handler
+	encoder *lambdaResponseEncoder
+	logger  *slog.Logger
+}
+
+// Invoke invokes the underlying handler with 'payload' and re-encodes the (base64-encoded) response body,
+// compressing it and limiting its size as necessary. Payloads which exceed the maximum payload size are rejected
+// without invoking the underlying handler.
+func (h *lambdaResponseHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+
+	var req lambdaRequest
+
+	err := json.Unmarshal(payload, &req)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal request, %w", err)
+	}
+
+	if len(payload) > h.encoder.max_payload_size {
+
+		h.logger.Error("Request payload exceeds maximum size", "method", req.method(), "path", req.path(), "size", len(payload), "max_payload_size", h.encoder.max_payload_size)
+
+		status, header, body := h.encoder.oversizeRequest(len(payload))
+
+		rsp := lambdaResponse{
+			StatusCode: status,
+			Body:       body,
+		}
+
+		// Answer API Gateway v1 and ALB events that use multi-value headers in kind
+
+		if req.MultiValueHeaders != nil {
+			rsp.MultiValueHeaders = make(map[string][]string)
+		}
+
+		rsp.setHeader(header)
+		return json.Marshal(rsp)
+	}
+
+	rsp_body, err := h.handler.Invoke(ctx, payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rsp lambdaResponse
+
+	err = json.Unmarshal(rsp_body, &rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal response, %w", err)
+	}
+
+	body := []byte(rsp.Body)
+
+	if rsp.IsBase64Encoded {
+
+		body, err = base64.StdEncoding.DecodeString(rsp.Body)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode response body, %w", err)
+		}
+	}
+
+	header := rsp.header()
+
+	rsp.Body, rsp.IsBase64Encoded, err = h.encoder.encodeBody(req.header("Accept-Encoding"), header, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rsp.setHeader(header)
+
+	enc_rsp, err := json.Marshal(rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal response, %w", err)
+	}
+
+	if len(enc_rsp) > h.encoder.max_payload_size {
+
+		h.logger.Error("Response payload exceeds maximum size", "method", req.method(), "path", req.path(), "status", rsp.StatusCode, "size", len(enc_rsp), "max_payload_size", h.encoder.max_payload_size)
+
+		status, header, body := h.encoder.oversizeResponse(len(enc_rsp))
+
+		rsp.StatusCode = status
+		rsp.Body = body
+		rsp.IsBase64Encoded = false
+		rsp.Cookies = nil
+		rsp.setHeader(header)
+
+		return json.Marshal(rsp)
+	}
+
+	return enc_rsp, nil
+}
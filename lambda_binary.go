@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// binaryContentTypes determines whether the body of a Lambda response should be base64-encoded.
+type binaryContentTypes struct {
+	// patterns are the (lower-cased, parameter-free) media types to match. They may be "*/*" or "{TYPE}/*".
+	patterns []string
+	// auto enables base64-encoding for any body that isn't valid UTF-8 or has a non-text content type.
+	auto bool
+}
+
+// newBinaryContentTypes returns a new `binaryContentTypes` instance derived from the `binary_type` and
+// `binary_auto` parameters in 'q'.
+func newBinaryContentTypes(q url.Values) (*binaryContentTypes, error) {
+
+	b := &binaryContentTypes{
+		patterns: make([]string, 0),
+	}
+
+	for _, t := range q["binary_type"] {
+
+		mt := parseMediaType(t)
+
+		if mt == "" {
+			return nil, fmt.Errorf("Invalid binary_type parameter, %s", t)
+		}
+
+		b.patterns = append(b.patterns, mt)
+	}
+
+	if q.Has("binary_auto") {
+
+		auto, err := strconv.ParseBool(q.Get("binary_auto"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid binary_auto parameter, %w", err)
+		}
+
+		b.auto = auto
+	}
+
+	return b, nil
+}
+
+// isBinary reports whether a response with 'content_type' and 'body' should be base64-encoded.
+func (b *binaryContentTypes) isBinary(content_type string, body []byte) bool {
+
+	mt := parseMediaType(content_type)
+
+	if mt != "" {
+
+		for _, p := range b.patterns {
+
+			if matchMediaType(p, mt) {
+				return true
+			}
+		}
+	}
+
+	if !b.auto {
+		return false
+	}
+
+	if !utf8.Valid(body) {
+		return true
+	}
+
+	return mt != "" && !isTextMediaType(mt)
+}
+
+// parseMediaType returns the lower-cased media type of 'content_type' with any parameters removed.
+func parseMediaType(content_type string) string {
+
+	mt, _, err := mime.ParseMediaType(content_type)
+
+	if err != nil {
+		// Be lenient with malformed parameters, for example "image/png; charset"
+		mt, _, _ = strings.Cut(content_type, ";")
+		mt = strings.ToLower(strings.TrimSpace(mt))
+	}
+
+	if !strings.Contains(mt, "/") {
+		return ""
+	}
+
+	return mt
+}
+
+// matchMediaType reports whether 'mt' matches 'pattern' which may be "*/*" or "{TYPE}/*".
+func matchMediaType(pattern string, mt string) bool {
+
+	if pattern == "*/*" || pattern == mt {
+		return true
+	}
+
+	prefix, ok := strings.CutSuffix(pattern, "/*")
+
+	if !ok {
+		return false
+	}
+
+	return strings.HasPrefix(mt, prefix+"/")
+}
+
+// isTextMediaType reports whether 'mt' is a textual media type.
+func isTextMediaType(mt string) bool {
+
+	if strings.HasPrefix(mt, "text/") {
+		return true
+	}
+
+	if strings.HasSuffix(mt, "+json") || strings.HasSuffix(mt, "+xml") {
+		return true
+	}
+
+	switch mt {
+	case "application/json", "application/xml", "application/javascript", "application/ecmascript",
+		"application/x-www-form-urlencoded", "application/x-ndjson", "application/graphql", "application/yaml",
+		"application/x-yaml", "application/toml", "application/sql":
+		return true
+	}
+
+	return false
+}
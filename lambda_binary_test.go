@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/akrylysov/algnhsa"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestBinaryContentTypes(t *testing.T) {
+
+	q := url.Values{}
+	q.Add("binary_type", "image/*")
+	q.Add("binary_type", "application/PDF")
+
+	b, err := newBinaryContentTypes(q)
+
+	if err != nil {
+		t.Fatalf("Failed to create binary content types, %v", err)
+	}
+
+	tests := map[string]bool{
+		"image/png":                     true,
+		"image/png; charset=binary":     true,
+		"IMAGE/JPEG":                    true,
+		"application/pdf; version=1.7":  true,
+		"text/html; charset=utf-8":      false,
+		"application/json":              false,
+		"imagery/png":                   false,
+		"":                              false,
+		"application/octet-stream; foo": false,
+	}
+
+	for content_type, expected := range tests {
+
+		if b.isBinary(content_type, []byte("hello")) != expected {
+			t.Fatalf("Unexpected result for '%s', expected %t", content_type, expected)
+		}
+	}
+
+	q = url.Values{}
+	q.Set("binary_auto", "true")
+
+	b, err = newBinaryContentTypes(q)
+
+	if err != nil {
+		t.Fatalf("Failed to create binary content types, %v", err)
+	}
+
+	auto_tests := []struct {
+		ContentType string
+		Body        []byte
+		Expected    bool
+	}{
+		{"text/plain; charset=utf-8", []byte("hello"), false},
+		{"application/json", []byte(`{"a":1}`), false},
+		{"application/vnd.api+json", []byte(`{"a":1}`), false},
+		{"", []byte("hello"), false},
+		{"text/plain", []byte{0xff, 0xfe, 0x00}, true},
+		{"application/octet-stream", []byte("hello"), true},
+		{"image/svg+xml", []byte("<svg/>"), false},
+	}
+
+	for _, test := range auto_tests {
+
+		if b.isBinary(test.ContentType, test.Body) != test.Expected {
+			t.Fatalf("Unexpected auto result for '%s' (%v), expected %t", test.ContentType, test.Body, test.Expected)
+		}
+	}
+
+	q = url.Values{}
+	q.Set("binary_auto", "maybe")
+
+	_, err = newBinaryContentTypes(q)
+
+	if err == nil {
+		t.Fatalf("Expected invalid binary_auto parameter to fail")
+	}
+}
+
+func TestLambdaResponseHandler(t *testing.T) {
+
+	ctx := context.Background()
+
+	q := url.Values{}
+	q.Add("binary_type", "image/*")
+
+	e, err := newLambdaResponseEncoder(q)
+
+	if err != nil {
+		t.Fatalf("Failed to create response encoder, %v", err)
+	}
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		switch req.URL.Path {
+		case "/image":
+			rsp.Header().Set("Content-Type", "image/png; charset=binary")
+			rsp.Write([]byte{0x89, 0x50, 0x4e, 0x47})
+		default:
+			rsp.Header().Set("Content-Type", "text/plain")
+			rsp.Write([]byte("hello"))
+		}
+	}
+
+	h := &lambdaResponseHandler{
+		handler: algnhsa.New(http.HandlerFunc(handler), &algnhsa.Options{BinaryContentTypes: []string{"*/*"}}),
+		encoder: e,
+		logger:  slog.Default(),
+	}
+
+	for path, expected_base64 := range map[string]bool{"/image": true, "/text": false} {
+
+		event := events.APIGatewayV2HTTPRequest{
+			Version: "2.0",
+			RawPath: path,
+		}
+
+		event.RequestContext.HTTP.Method = http.MethodGet
+		event.RequestContext.RouteKey = "$default"
+
+		payload, err := json.Marshal(event)
+
+		if err != nil {
+			t.Fatalf("Failed to marshal event, %v", err)
+		}
+
+		rsp_body, err := h.Invoke(ctx, payload)
+
+		if err != nil {
+			t.Fatalf("Failed to invoke handler for %s, %v", path, err)
+		}
+
+		var rsp events.APIGatewayV2HTTPResponse
+
+		err = json.Unmarshal(rsp_body, &rsp)
+
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response, %v", err)
+		}
+
+		if rsp.IsBase64Encoded != expected_base64 {
+			t.Fatalf("Unexpected base64 encoding for %s: %t", path, rsp.IsBase64Encoded)
+		}
+
+		if expected_base64 {
+
+			body, err := base64.StdEncoding.DecodeString(rsp.Body)
+
+			if err != nil || len(body) != 4 {
+				t.Fatalf("Unexpected body for %s: %s", path, rsp.Body)
+			}
+
+		} else if rsp.Body != "hello" {
+			t.Fatalf("Unexpected body for %s: %s", path, rsp.Body)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// The value that redacted header values are replaced with in Lambda fixtures.
+const LAMBDA_FIXTURE_REDACTED string = "REDACTED"
+
+// LAMBDA_FIXTURE_REDACT_HEADERS is the default list of request and response headers whose values are redacted in Lambda fixtures.
+var LAMBDA_FIXTURE_REDACT_HEADERS = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"Set-Cookie",
+	"X-Amz-Security-Token",
+	"X-Api-Key",
+	"X-Csrf-Token",
+}
+
+// Regular expression to match characters which are replaced when deriving fixture names from request paths.
+var re_fixture_name = regexp.MustCompile(`[^a-zA-Z0-9\-_\.]+`)
+
+// LambdaFixture is a Lambda event derived from an HTTP request and the Lambda response that the same request is expected to produce.
+type LambdaFixture struct {
+	// Event is the JSON-encoded Lambda event.
+	Event []byte
+	// Response is the expected Lambda response, normalized using `NormalizeLambdaResponse`.
+	Response []byte
+}
+
+// NewLambdaFixture returns a new `LambdaFixture` instance containing a Lambda event of type 'event_type' (one of the LAMBDA_EVENT_*
+// constants) derived from 'req' and the Lambda response that 'rsp' would be translated in to. The values of the headers in
+// 'redact_headers' are replaced by `LAMBDA_FIXTURE_REDACTED`. The "Accept-Encoding" header is removed from the event since
+// a `lambda://` or `functionurl://` server would otherwise compress the response that replaying it produces. Response bodies
+// are base64-encoded using the same rules as the `binary_auto` parameter. The bodies of 'req' and 'rsp' are consumed.
+func NewLambdaFixture(req *http.Request, rsp *http.Response, event_type string, redact_headers []string) (*LambdaFixture, error) {
+
+	req = req.Clone(req.Context())
+	req.Header.Del("Accept-Encoding")
+
+	redactHeaders(req.Header, redact_headers)
+
+	event, err := NewLambdaEvent(req, event_type)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create Lambda event, %w", err)
+	}
+
+	enc_event, err := json.MarshalIndent(event, "", "  ")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal Lambda event, %w", err)
+	}
+
+	var body []byte
+
+	if rsp.Body != nil {
+
+		body, err = io.ReadAll(rsp.Body)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read response body, %w", err)
+		}
+	}
+
+	header := rsp.Header.Clone()
+
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	redactHeaders(header, redact_headers)
+
+	enc_rsp, err := newLambdaFixtureResponse(rsp.StatusCode, header, body, event_type)
+
+	if err != nil {
+		return nil, err
+	}
+
+	norm_rsp, err := NormalizeLambdaResponse(enc_rsp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := &LambdaFixture{
+		Event:    append(enc_event, '\n'),
+		Response: norm_rsp,
+	}
+
+	return f, nil
+}
+
+// Write writes the event and expected response of 'f' to "{NAME}.json" and "{NAME}.golden.json" files in 'dir', the
+// layout expected by the `lambda-emulator replay` command.
+func (f *LambdaFixture) Write(dir string, name string) error {
+
+	event_path := filepath.Join(dir, name+".json")
+	golden_path := filepath.Join(dir, name+".golden.json")
+
+	err := os.WriteFile(event_path, f.Event, 0644)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write %s, %w", event_path, err)
+	}
+
+	err = os.WriteFile(golden_path, f.Response, 0644)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write %s, %w", golden_path, err)
+	}
+
+	return nil
+}
+
+// LambdaCaptureOptions defines configuration options for the `NewLambdaCaptureHandler` method.
+type LambdaCaptureOptions struct {
+	// EventType is the type of Lambda event (one of the LAMBDA_EVENT_* constants) to write. Default is `LAMBDA_EVENT_APIGATEWAY_V2`.
+	EventType string
+	// Directory is the directory that fixtures are written to. It must already exist.
+	Directory string
+	// RedactHeaders is the list of request and response headers whose values are redacted. Default is `LAMBDA_FIXTURE_REDACT_HEADERS`.
+	RedactHeaders []string
+	// Logger is an optional `*slog.Logger` instance used to log errors writing fixtures. If nil the logger associated with each
+	// request's context (see `LoggerFromContext`) is used.
+	Logger *slog.Logger
+}
+
+// NewLambdaCaptureHandler returns an `http.Handler` middleware that serves requests using 'next' and writes each request and the
+// response it produced to 'opts.Directory' as a `LambdaFixture`, for use as a regression fixture with the `lambda-emulator replay`
+// command. Fixtures are named "{TIMESTAMP}-{COUNT}-{METHOD}-{PATH}". Requests or responses larger than `LAMBDA_MAX_PAYLOAD_SIZE`
+// are served but not captured. Failing to write a fixture does not affect the response.
+func NewLambdaCaptureHandler(next http.Handler, opts *LambdaCaptureOptions) (http.Handler, error) {
+
+	event_type := opts.EventType
+
+	if event_type == "" {
+		event_type = LAMBDA_EVENT_APIGATEWAY_V2
+	}
+
+	switch event_type {
+	case LAMBDA_EVENT_APIGATEWAY_V1, LAMBDA_EVENT_APIGATEWAY_V2, LAMBDA_EVENT_ALB, LAMBDA_EVENT_FUNCTIONURL:
+		// pass
+	default:
+		return nil, fmt.Errorf("Invalid event type, %s", event_type)
+	}
+
+	info, err := os.Stat(opts.Directory)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to stat fixtures directory, %w", err)
+	}
+
+	if !info.IsDir() {
+		return nil, fmt.Errorf("Fixtures directory (%s) is not a directory", opts.Directory)
+	}
+
+	redact_headers := opts.RedactHeaders
+
+	if redact_headers == nil {
+		redact_headers = LAMBDA_FIXTURE_REDACT_HEADERS
+	}
+
+	count := new(atomic.Int64)
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		logger := opts.Logger
+
+		if logger == nil {
+			logger = LoggerFromContext(req.Context())
+		}
+
+		logger = logger.With("method", req.Method, "path", req.URL.Path)
+
+		var body []byte
+
+		if req.Body != nil {
+
+			b, err := io.ReadAll(io.LimitReader(req.Body, int64(LAMBDA_MAX_PAYLOAD_SIZE)+1))
+
+			if err != nil {
+				logger.Error("Failed to read request body", "error", err)
+				http.Error(rsp, "Bad request", http.StatusBadRequest)
+				return
+			}
+
+			body = b
+
+			// Any part of the body that was not read is still available to 'next'
+
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		}
+
+		capture_req := req.Clone(req.Context())
+		capture_req.Body = io.NopCloser(bytes.NewReader(body))
+
+		wr := newCaptureResponseWriter(rsp)
+		next.ServeHTTP(wr, req)
+
+		if len(body) > LAMBDA_MAX_PAYLOAD_SIZE || wr.overflow {
+			logger.Warn("Request or response exceeds maximum Lambda payload size, not capturing")
+			return
+		}
+
+		capture_rsp := &http.Response{
+			StatusCode: wr.status,
+			Header:     wr.header,
+			Body:       io.NopCloser(bytes.NewReader(wr.body.Bytes())),
+		}
+
+		if capture_rsp.StatusCode == 0 {
+			capture_rsp.StatusCode = http.StatusOK
+		}
+
+		f, err := NewLambdaFixture(capture_req, capture_rsp, event_type, redact_headers)
+
+		if err != nil {
+			logger.Error("Failed to create Lambda fixture", "error", err)
+			return
+		}
+
+		name := lambdaFixtureName(req, count.Add(1))
+
+		err = f.Write(opts.Directory, name)
+
+		if err != nil {
+			logger.Error("Failed to write Lambda fixture", "error", err)
+			return
+		}
+
+		logger.Debug("Wrote Lambda fixture", "name", name)
+	}
+
+	return http.HandlerFunc(fn), nil
+}
+
+// newLambdaFixtureResponse returns the JSON-encoded Lambda response, for 'event_type', with 'status', 'header' and 'body'.
+func newLambdaFixtureResponse(status int, header http.Header, body []byte, event_type string) ([]byte, error) {
+
+	binary_types := &binaryContentTypes{
+		auto: true,
+	}
+
+	enc_body := string(body)
+	is_base64 := binary_types.isBinary(header.Get("Content-Type"), body)
+
+	if is_base64 {
+		enc_body = base64.StdEncoding.EncodeToString(body)
+	}
+
+	var rsp any
+
+	switch event_type {
+	case LAMBDA_EVENT_APIGATEWAY_V1, LAMBDA_EVENT_ALB:
+
+		rsp = &lambdaResponse{
+			StatusCode:        status,
+			MultiValueHeaders: header,
+			Body:              enc_body,
+			IsBase64Encoded:   is_base64,
+		}
+
+	case LAMBDA_EVENT_APIGATEWAY_V2:
+
+		headers, cookies := newFunctionURLResponseHeaders(header)
+
+		rsp = &lambdaResponse{
+			StatusCode:      status,
+			Headers:         headers,
+			Cookies:         cookies,
+			Body:            enc_body,
+			IsBase64Encoded: is_base64,
+		}
+
+	case LAMBDA_EVENT_FUNCTIONURL:
+
+		headers, cookies := newFunctionURLResponseHeaders(header)
+
+		rsp = &events.LambdaFunctionURLResponse{
+			StatusCode:      status,
+			Headers:         headers,
+			Cookies:         cookies,
+			Body:            enc_body,
+			IsBase64Encoded: is_base64,
+		}
+
+	default:
+		return nil, fmt.Errorf("Invalid event type, %s", event_type)
+	}
+
+	enc_rsp, err := json.Marshal(rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal Lambda response, %w", err)
+	}
+
+	return enc_rsp, nil
+}
+
+// redactHeaders replaces the values of the headers in 'redact_headers' in 'header' with `LAMBDA_FIXTURE_REDACTED`.
+func redactHeaders(header http.Header, redact_headers []string) {
+
+	for _, k := range redact_headers {
+
+		values := header.Values(k)
+
+		if len(values) == 0 {
+			continue
+		}
+
+		redacted := make([]string, len(values))
+
+		for i := range values {
+			redacted[i] = LAMBDA_FIXTURE_REDACTED
+		}
+
+		header[http.CanonicalHeaderKey(k)] = redacted
+	}
+}
+
+// lambdaFixtureName returns a unique, filesystem-safe, name for a fixture derived from 'req'.
+func lambdaFixtureName(req *http.Request, count int64) string {
+
+	path := strings.Trim(re_fixture_name.ReplaceAllString(req.URL.Path, "_"), "_")
+
+	if path == "" {
+		path = "root"
+	}
+
+	if len(path) > 64 {
+		path = path[:64]
+	}
+
+	return fmt.Sprintf("%d-%d-%s-%s", time.Now().Unix(), count, strings.ToLower(req.Method), path)
+}
+
+// captureResponseWriter implements the `http.ResponseWriter` interface recording the status, headers and body
+// of a response as they are written to the underlying `http.ResponseWriter`.
+type captureResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     *bytes.Buffer
+	overflow bool
+}
+
+func newCaptureResponseWriter(rsp http.ResponseWriter) *captureResponseWriter {
+
+	wr := &captureResponseWriter{
+		ResponseWriter: rsp,
+		body:           new(bytes.Buffer),
+	}
+
+	return wr
+}
+
+// WriteHeader records 'status' and a copy of the response headers and writes them to the underlying `http.ResponseWriter`.
+func (wr *captureResponseWriter) WriteHeader(status int) {
+	wr.snapshot(status, nil)
+	wr.ResponseWriter.WriteHeader(status)
+}
+
+// Write records 'b' and writes it to the underlying `http.ResponseWriter`.
+func (wr *captureResponseWriter) Write(b []byte) (int, error) {
+
+	wr.snapshot(http.StatusOK, b)
+
+	if wr.body.Len()+len(b) > LAMBDA_MAX_PAYLOAD_SIZE {
+		wr.overflow = true
+	} else {
+		wr.body.Write(b)
+	}
+
+	return wr.ResponseWriter.Write(b)
+}
+
+// Flush flushes the underlying `http.ResponseWriter`, if it implements the `http.Flusher` interface.
+func (wr *captureResponseWriter) Flush() {
+
+	wr.snapshot(http.StatusOK, nil)
+
+	if f, ok := wr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying `http.ResponseWriter` for use with `http.ResponseController`.
+func (wr *captureResponseWriter) Unwrap() http.ResponseWriter {
+	return wr.ResponseWriter
+}
+
+// snapshot records 'status' and a copy of the response headers the first time it is called. Like the `httptest.ResponseRecorder`
+// used to translate HTTP responses in to Lambda responses a "Content-Type" header is derived from 'b' if one is not present.
+func (wr *captureResponseWriter) snapshot(status int, b []byte) {
+
+	if wr.header != nil {
+		return
+	}
+
+	wr.status = status
+	wr.header = wr.ResponseWriter.Header().Clone()
+
+	_, has_type := wr.header["Content-Type"]
+
+	if !has_type && wr.header.Get("Transfer-Encoding") == "" {
+		wr.header.Set("Content-Type", http.DetectContentType(b))
+	}
+}
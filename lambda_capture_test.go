@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLambdaCaptureHandler(t *testing.T) {
+
+	ctx := context.Background()
+
+	mux := http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.Header.Get("Authorization") != "Bearer s3cr3t" {
+			http.Error(rsp, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		http.SetCookie(rsp, &http.Cookie{Name: "session", Value: "s3cr3t"})
+		rsp.Header().Set("X-Path", req.URL.Path)
+
+		switch req.URL.Path {
+		case "/binary":
+			rsp.Header().Set("Content-Type", "image/png")
+			rsp.Write([]byte{0x89, 'P', 'N', 'G', 0x00, 0xff})
+		default:
+			rsp.Write([]byte("hello " + req.URL.Query().Get("name")))
+		}
+	})
+
+	for _, event_type := range []string{LAMBDA_EVENT_APIGATEWAY_V1, LAMBDA_EVENT_APIGATEWAY_V2, LAMBDA_EVENT_ALB, LAMBDA_EVENT_FUNCTIONURL} {
+
+		dir := t.TempDir()
+
+		capture_handler, err := NewLambdaCaptureHandler(mux, &LambdaCaptureOptions{
+			EventType: event_type,
+			Directory: dir,
+		})
+
+		if err != nil {
+			t.Fatalf("Failed to create capture handler for %s, %v", event_type, err)
+		}
+
+		ts := httptest.NewServer(capture_handler)
+
+		for _, path := range []string{"/hello?name=bob", "/binary"} {
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader("body"))
+
+			if err != nil {
+				t.Fatalf("Failed to create request, %v", err)
+			}
+
+			req.Header.Set("Authorization", "Bearer s3cr3t")
+
+			rsp, err := http.DefaultClient.Do(req)
+
+			if err != nil {
+				t.Fatalf("Failed to execute request, %v", err)
+			}
+
+			rsp.Body.Close()
+
+			if rsp.StatusCode != http.StatusOK {
+				t.Fatalf("Unexpected status for %s: %d", path, rsp.StatusCode)
+			}
+		}
+
+		ts.Close()
+
+		event_paths, err := filepath.Glob(filepath.Join(dir, "*.golden.json"))
+
+		if err != nil {
+			t.Fatalf("Failed to list fixtures, %v", err)
+		}
+
+		if len(event_paths) != 2 {
+			t.Fatalf("Expected 2 fixtures for %s, got %d", event_type, len(event_paths))
+		}
+
+		server_uri := "lambda://?binary_auto=true"
+
+		if event_type == LAMBDA_EVENT_FUNCTIONURL {
+			server_uri = "functionurl://?binary_auto=true"
+		}
+
+		s, err := NewServer(ctx, server_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create server, %v", err)
+		}
+
+		lambda_handler := s.(LambdaHandlerServer).LambdaHandler(mux)
+
+		for _, golden_path := range event_paths {
+
+			event_path := strings.TrimSuffix(golden_path, ".golden.json") + ".json"
+
+			event, err := os.ReadFile(event_path)
+
+			if err != nil {
+				t.Fatalf("Failed to read %s, %v", event_path, err)
+			}
+
+			if bytes.Contains(event, []byte("s3cr3t")) || !bytes.Contains(event, []byte(LAMBDA_FIXTURE_REDACTED)) {
+				t.Fatalf("Expected Authorization header to be redacted in %s: %s", event_path, event)
+			}
+
+			expected, err := os.ReadFile(golden_path)
+
+			if err != nil {
+				t.Fatalf("Failed to read %s, %v", golden_path, err)
+			}
+
+			if bytes.Contains(expected, []byte("s3cr3t")) {
+				t.Fatalf("Expected Set-Cookie header to be redacted in %s: %s", golden_path, expected)
+			}
+
+			// Replaying the event produces the expected response, except for the redacted
+			// Authorization header which the handler rejects
+
+			rsp, err := InvokeLambdaEvent(ctx, lambda_handler, event)
+
+			if err != nil {
+				t.Fatalf("Failed to replay %s, %v", event_path, err)
+			}
+
+			if !bytes.Contains(rsp, []byte(`"statusCode": 401`)) {
+				t.Fatalf("Expected replayed %s event to be unauthorized: %s", event_type, rsp)
+			}
+
+			replay_event := bytes.ReplaceAll(event, []byte(`"`+LAMBDA_FIXTURE_REDACTED+`"`), []byte(`"Bearer s3cr3t"`))
+
+			rsp, err = InvokeLambdaEvent(ctx, lambda_handler, replay_event)
+
+			if err != nil {
+				t.Fatalf("Failed to replay %s, %v", event_path, err)
+			}
+
+			rsp = bytes.ReplaceAll(rsp, []byte("session=s3cr3t"), []byte(LAMBDA_FIXTURE_REDACTED))
+
+			if !bytes.Equal(rsp, expected) {
+				t.Fatalf("Replayed %s response does not match fixture.\nexpected:\n%s\nreplayed:\n%s", event_type, expected, rsp)
+			}
+		}
+	}
+}
+
+func TestLambdaCaptureHandlerInvalid(t *testing.T) {
+
+	mux := http.NotFoundHandler()
+
+	_, err := NewLambdaCaptureHandler(mux, &LambdaCaptureOptions{EventType: "sqs", Directory: t.TempDir()})
+
+	if err == nil {
+		t.Fatalf("Expected invalid event type to fail")
+	}
+
+	_, err = NewLambdaCaptureHandler(mux, &LambdaCaptureOptions{Directory: filepath.Join(t.TempDir(), "missing")})
+
+	if err == nil {
+		t.Fatalf("Expected missing directory to fail")
+	}
+}
@@ -0,0 +1,394 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/akrylysov/algnhsa"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// The event type for requests derived from API Gateway REST API (v1) events.
+const LAMBDA_EVENT_APIGATEWAY_V1 string = "apigateway_v1"
+
+// The event type for requests derived from API Gateway HTTP API (v2) events.
+const LAMBDA_EVENT_APIGATEWAY_V2 string = "apigateway_v2"
+
+// The event type for requests derived from Application Load Balancer events.
+const LAMBDA_EVENT_ALB string = "alb"
+
+// The event type for requests derived from Lambda Function URL events.
+const LAMBDA_EVENT_FUNCTIONURL string = "functionurl"
+
+// lambdaEventContextKey is the key used to store the original Lambda event in a `context.Context`.
+type lambdaEventContextKey struct{}
+
+// lambdaIdentityContextKey is the key used to store a `LambdaIdentity` instance in a `context.Context`.
+type lambdaIdentityContextKey struct{}
+
+// LambdaIdentity is a normalized representation of the request and caller details contained in the Lambda
+// events that `lambda://` and `functionurl://` servers translate in to `*http.Request` instances. Properties
+// that are not present in a given type of event are left empty.
+type LambdaIdentity struct {
+	// EventType is the type of the original event. One of the LAMBDA_EVENT_* constants.
+	EventType string
+	// RequestID is the API Gateway or Function URL request ID.
+	RequestID string
+	// LambdaRequestID is the AWS request ID of the Lambda invocation.
+	LambdaRequestID string
+	// InvokedFunctionARN is the ARN of the Lambda function that was invoked.
+	InvokedFunctionARN string
+	// AccountID is the AWS account ID that owns the API Gateway API or Function URL.
+	AccountID string
+	// APIID is the API Gateway API ID or the Function URL ID.
+	APIID string
+	// Stage is the API Gateway stage.
+	Stage string
+	// DomainName is the domain name the request was sent to.
+	DomainName string
+	// SourceIP is the IP address of the client.
+	SourceIP string
+	// UserAgent is the user agent of the client.
+	UserAgent string
+	// TargetGroupARN is the ARN of the ALB target group.
+	TargetGroupARN string
+	// PrincipalID is the principal ID returned by a Lambda (custom) authorizer.
+	PrincipalID string
+	// Claims are the JWT or Cognito user pool claims returned by an authorizer.
+	Claims map[string]string
+	// Scopes are the JWT scopes returned by an authorizer.
+	Scopes []string
+	// Authorizer is the context returned by a Lambda (custom) authorizer.
+	Authorizer map[string]any
+	// IAM contains the details of callers authenticated using AWS IAM.
+	IAM *LambdaIAMIdentity
+	// ConnectionID is the API Gateway WebSocket API connection ID.
+	ConnectionID string
+}
+
+// LambdaIAMIdentity contains the details of callers authenticated using AWS IAM.
+type LambdaIAMIdentity struct {
+	AccessKey             string
+	AccountID             string
+	CallerID              string
+	UserARN               string
+	UserID                string
+	PrincipalOrgID        string
+	CognitoIdentityID     string
+	CognitoIdentityPoolID string
+}
+
+// LambdaEventFromContext returns the original Lambda event that the request associated with 'ctx' was derived from.
+// It will be one of `events.APIGatewayProxyRequest`, `events.APIGatewayV2HTTPRequest`, `events.ALBTargetGroupRequest`
+// or `events.LambdaFunctionURLRequest`. For requests derived from non-HTTP events (see the `events` parameter of `NewLambdaServer`)
+// it will be the individual record the request was derived from: one of `events.SQSMessage`, `events.SNSEventRecord`,
+// `events.S3EventRecord` or `events.EventBridgeEvent`. For requests served by the `lambdawebsocket://` server it will be an
+// `events.APIGatewayWebsocketProxyRequest`.
+func LambdaEventFromContext(ctx context.Context) (any, bool) {
+	ev := ctx.Value(lambdaEventContextKey{})
+	return ev, ev != nil
+}
+
+// LambdaIdentityFromContext returns the `LambdaIdentity` instance derived from the Lambda event that the request associated
+// with 'ctx' was derived from.
+func LambdaIdentityFromContext(ctx context.Context) (*LambdaIdentity, bool) {
+	id, ok := ctx.Value(lambdaIdentityContextKey{}).(*LambdaIdentity)
+	return id, ok
+}
+
+// APIGatewayV1RequestFromContext returns the API Gateway REST API (v1) event that the request associated with 'ctx' was derived from.
+func APIGatewayV1RequestFromContext(ctx context.Context) (events.APIGatewayProxyRequest, bool) {
+	ev, ok := ctx.Value(lambdaEventContextKey{}).(events.APIGatewayProxyRequest)
+	return ev, ok
+}
+
+// APIGatewayV2RequestFromContext returns the API Gateway HTTP API (v2) event that the request associated with 'ctx' was derived from.
+func APIGatewayV2RequestFromContext(ctx context.Context) (events.APIGatewayV2HTTPRequest, bool) {
+	ev, ok := ctx.Value(lambdaEventContextKey{}).(events.APIGatewayV2HTTPRequest)
+	return ev, ok
+}
+
+// ALBRequestFromContext returns the Application Load Balancer event that the request associated with 'ctx' was derived from.
+func ALBRequestFromContext(ctx context.Context) (events.ALBTargetGroupRequest, bool) {
+	ev, ok := ctx.Value(lambdaEventContextKey{}).(events.ALBTargetGroupRequest)
+	return ev, ok
+}
+
+// FunctionURLRequestFromContext returns the Lambda Function URL event that the request associated with 'ctx' was derived from.
+func FunctionURLRequestFromContext(ctx context.Context) (events.LambdaFunctionURLRequest, bool) {
+	ev, ok := ctx.Value(lambdaEventContextKey{}).(events.LambdaFunctionURLRequest)
+	return ev, ok
+}
+
+// withLambdaEvent returns a copy of 'ctx' that stores 'event' and the `LambdaIdentity` derived from it.
+func withLambdaEvent(ctx context.Context, event any) (context.Context, error) {
+
+	id, err := newLambdaIdentity(ctx, event)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, lambdaEventContextKey{}, event)
+	ctx = context.WithValue(ctx, lambdaIdentityContextKey{}, id)
+
+	return ctx, nil
+}
+
+// newLambdaEventHandler returns an `http.Handler` that attaches the Lambda event, and its `LambdaIdentity`, stored in the
+// request context by `algnhsa` to the request context before serving 'next'.
+func newLambdaEventHandler(next http.Handler) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		var event any
+
+		if ev, ok := algnhsa.APIGatewayV2RequestFromContext(ctx); ok {
+			event = ev
+		} else if ev, ok := algnhsa.APIGatewayV1RequestFromContext(ctx); ok {
+			event = ev
+		} else if ev, ok := algnhsa.ALBRequestFromContext(ctx); ok {
+			event = ev
+		}
+
+		if event != nil {
+
+			ctx, err := withLambdaEvent(ctx, event)
+
+			if err != nil {
+				LoggerFromContext(ctx).Error("Failed to derive Lambda identity", "path", req.URL.Path, "error", err)
+				http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			req = req.WithContext(ctx)
+		}
+
+		next.ServeHTTP(rsp, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// newLambdaIdentity derives a `LambdaIdentity` instance from 'event' and the Lambda invocation details in 'ctx'.
+func newLambdaIdentity(ctx context.Context, event any) (*LambdaIdentity, error) {
+
+	id := &LambdaIdentity{}
+
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		id.LambdaRequestID = lc.AwsRequestID
+		id.InvokedFunctionARN = lc.InvokedFunctionArn
+	}
+
+	switch ev := event.(type) {
+	case events.APIGatewayProxyRequest:
+
+		req_ctx := ev.RequestContext
+
+		id.EventType = LAMBDA_EVENT_APIGATEWAY_V1
+		id.RequestID = req_ctx.RequestID
+		id.AccountID = req_ctx.AccountID
+		id.APIID = req_ctx.APIID
+		id.Stage = req_ctx.Stage
+		id.DomainName = req_ctx.DomainName
+		id.SourceIP = req_ctx.Identity.SourceIP
+		id.UserAgent = req_ctx.Identity.UserAgent
+
+		if req_ctx.Identity.AccessKey != "" || req_ctx.Identity.UserArn != "" || req_ctx.Identity.CognitoIdentityID != "" {
+
+			id.IAM = &LambdaIAMIdentity{
+				AccessKey:             req_ctx.Identity.AccessKey,
+				AccountID:             req_ctx.Identity.AccountID,
+				CallerID:              req_ctx.Identity.Caller,
+				UserARN:               req_ctx.Identity.UserArn,
+				UserID:                req_ctx.Identity.User,
+				CognitoIdentityID:     req_ctx.Identity.CognitoIdentityID,
+				CognitoIdentityPoolID: req_ctx.Identity.CognitoIdentityPoolID,
+			}
+		}
+
+		if len(req_ctx.Authorizer) > 0 {
+
+			authorizer := make(map[string]any)
+
+			for k, v := range req_ctx.Authorizer {
+
+				switch k {
+				case "claims":
+					// Cognito user pool authorizers
+					id.Claims = stringMap(v)
+				case "principalId":
+					id.PrincipalID = fmt.Sprintf("%v", v)
+				default:
+					authorizer[k] = v
+				}
+			}
+
+			if len(authorizer) > 0 {
+				id.Authorizer = authorizer
+			}
+		}
+
+	case events.APIGatewayV2HTTPRequest:
+
+		req_ctx := ev.RequestContext
+
+		id.EventType = LAMBDA_EVENT_APIGATEWAY_V2
+		id.RequestID = req_ctx.RequestID
+		id.AccountID = req_ctx.AccountID
+		id.APIID = req_ctx.APIID
+		id.Stage = req_ctx.Stage
+		id.DomainName = req_ctx.DomainName
+		id.SourceIP = req_ctx.HTTP.SourceIP
+		id.UserAgent = req_ctx.HTTP.UserAgent
+
+		if req_ctx.Authorizer != nil {
+
+			if req_ctx.Authorizer.JWT != nil {
+				id.Claims = req_ctx.Authorizer.JWT.Claims
+				id.Scopes = req_ctx.Authorizer.JWT.Scopes
+			}
+
+			if len(req_ctx.Authorizer.Lambda) > 0 {
+				id.Authorizer = req_ctx.Authorizer.Lambda
+			}
+
+			if iam := req_ctx.Authorizer.IAM; iam != nil {
+
+				id.IAM = &LambdaIAMIdentity{
+					AccessKey:             iam.AccessKey,
+					AccountID:             iam.AccountID,
+					CallerID:              iam.CallerID,
+					UserARN:               iam.UserARN,
+					UserID:                iam.UserID,
+					PrincipalOrgID:        iam.PrincipalOrgID,
+					CognitoIdentityID:     iam.CognitoIdentity.IdentityID,
+					CognitoIdentityPoolID: iam.CognitoIdentity.IdentityPoolID,
+				}
+			}
+		}
+
+	case events.ALBTargetGroupRequest:
+
+		id.EventType = LAMBDA_EVENT_ALB
+		id.TargetGroupARN = ev.RequestContext.ELB.TargetGroupArn
+
+		headers := make(http.Header)
+
+		for k, v := range ev.Headers {
+			headers.Add(k, v)
+		}
+
+		for k, values := range ev.MultiValueHeaders {
+
+			for _, v := range values {
+				headers.Add(k, v)
+			}
+		}
+
+		id.SourceIP = strings.TrimSpace(strings.Split(headers.Get("X-Forwarded-For"), ",")[0])
+		id.UserAgent = headers.Get("User-Agent")
+		id.RequestID = headers.Get("X-Amzn-Trace-Id")
+
+	case events.LambdaFunctionURLRequest:
+
+		req_ctx := ev.RequestContext
+
+		id.EventType = LAMBDA_EVENT_FUNCTIONURL
+		id.RequestID = req_ctx.RequestID
+		id.AccountID = req_ctx.AccountID
+		id.APIID = req_ctx.APIID
+		id.DomainName = req_ctx.DomainName
+		id.SourceIP = req_ctx.HTTP.SourceIP
+		id.UserAgent = req_ctx.HTTP.UserAgent
+
+		if req_ctx.Authorizer != nil && req_ctx.Authorizer.IAM != nil {
+
+			iam := req_ctx.Authorizer.IAM
+
+			id.IAM = &LambdaIAMIdentity{
+				AccessKey: iam.AccessKey,
+				AccountID: iam.AccountID,
+				CallerID:  iam.CallerID,
+				UserARN:   iam.UserARN,
+				UserID:    iam.UserID,
+			}
+		}
+
+	case events.APIGatewayWebsocketProxyRequest:
+
+		req_ctx := ev.RequestContext
+
+		id.EventType = LAMBDA_EVENT_WEBSOCKET
+		id.RequestID = req_ctx.RequestID
+		id.AccountID = req_ctx.AccountID
+		id.APIID = req_ctx.APIID
+		id.Stage = req_ctx.Stage
+		id.DomainName = req_ctx.DomainName
+		id.SourceIP = req_ctx.Identity.SourceIP
+		id.UserAgent = req_ctx.Identity.UserAgent
+		id.ConnectionID = req_ctx.ConnectionID
+
+		if authorizer, ok := req_ctx.Authorizer.(map[string]any); ok && len(authorizer) > 0 {
+
+			if v, ok := authorizer["principalId"]; ok {
+				id.PrincipalID = fmt.Sprintf("%v", v)
+			}
+
+			id.Authorizer = authorizer
+		}
+
+	case events.SQSMessage:
+
+		id.EventType = LAMBDA_EVENT_SQS
+		id.RequestID = ev.MessageId
+		id.AccountID = arnAccountID(ev.EventSourceARN)
+
+	case events.SNSEventRecord:
+
+		id.EventType = LAMBDA_EVENT_SNS
+		id.RequestID = ev.SNS.MessageID
+		id.AccountID = arnAccountID(ev.SNS.TopicArn)
+
+	case events.EventBridgeEvent:
+
+		id.EventType = lambdaEventBridgeType(ev.Source, ev.DetailType)
+		id.RequestID = ev.ID
+		id.AccountID = ev.AccountID
+
+	case events.S3EventRecord:
+
+		id.EventType = LAMBDA_EVENT_S3
+		id.RequestID = ev.ResponseElements["x-amz-request-id"]
+		id.SourceIP = ev.RequestParameters.SourceIPAddress
+		id.PrincipalID = ev.PrincipalID.PrincipalID
+
+	default:
+		return nil, fmt.Errorf("Unsupported event type, %T", event)
+	}
+
+	return id, nil
+}
+
+// stringMap converts 'v', which is expected to be a map with string keys, in to a map of strings.
+func stringMap(v any) map[string]string {
+
+	m, ok := v.(map[string]any)
+
+	if !ok {
+		return nil
+	}
+
+	str_m := make(map[string]string, len(m))
+
+	for k, v := range m {
+		str_m[k] = fmt.Sprintf("%v", v)
+	}
+
+	return str_m
+}
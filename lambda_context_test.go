@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+func TestLambdaIdentity(t *testing.T) {
+
+	ctx := context.Background()
+
+	ctx = lambdacontext.NewContext(ctx, &lambdacontext.LambdaContext{
+		AwsRequestID:       "lambda-request",
+		InvokedFunctionArn: "arn:aws:lambda:us-east-1:123456789012:function:example",
+	})
+
+	v1 := events.APIGatewayProxyRequest{}
+	v1.RequestContext.RequestID = "v1-request"
+	v1.RequestContext.Stage = "prod"
+	v1.RequestContext.Identity.SourceIP = "192.0.2.1"
+	v1.RequestContext.Authorizer = map[string]any{
+		"principalId": "user-1",
+		"claims":      map[string]any{"sub": "abc", "email_verified": true},
+		"tenant":      "example",
+	}
+
+	v2 := events.APIGatewayV2HTTPRequest{}
+	v2.RequestContext.RequestID = "v2-request"
+	v2.RequestContext.Stage = "$default"
+	v2.RequestContext.HTTP.SourceIP = "192.0.2.2"
+	v2.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+			Claims: map[string]string{"sub": "def"},
+			Scopes: []string{"read"},
+		},
+	}
+
+	alb := events.ALBTargetGroupRequest{
+		Headers: map[string]string{
+			"x-forwarded-for": "192.0.2.3, 10.0.0.1",
+			"x-amzn-trace-id": "Root=1-abc",
+		},
+	}
+
+	alb.RequestContext.ELB.TargetGroupArn = "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/example"
+
+	fu := events.LambdaFunctionURLRequest{}
+	fu.RequestContext.RequestID = "fu-request"
+	fu.RequestContext.HTTP.SourceIP = "192.0.2.4"
+	fu.RequestContext.Authorizer = &events.LambdaFunctionURLRequestContextAuthorizerDescription{
+		IAM: &events.LambdaFunctionURLRequestContextAuthorizerIAMDescription{
+			UserARN: "arn:aws:iam::123456789012:user/example",
+		},
+	}
+
+	tests := map[string]any{
+		LAMBDA_EVENT_APIGATEWAY_V1: v1,
+		LAMBDA_EVENT_APIGATEWAY_V2: v2,
+		LAMBDA_EVENT_ALB:           alb,
+		LAMBDA_EVENT_FUNCTIONURL:   fu,
+	}
+
+	for event_type, event := range tests {
+
+		event_ctx, err := withLambdaEvent(ctx, event)
+
+		if err != nil {
+			t.Fatalf("Failed to attach %s event, %v", event_type, err)
+		}
+
+		id, ok := LambdaIdentityFromContext(event_ctx)
+
+		if !ok {
+			t.Fatalf("Missing identity for %s event", event_type)
+		}
+
+		if id.EventType != event_type {
+			t.Fatalf("Unexpected event type for %s event: %s", event_type, id.EventType)
+		}
+
+		if id.LambdaRequestID != "lambda-request" {
+			t.Fatalf("Unexpected Lambda request ID for %s event: %s", event_type, id.LambdaRequestID)
+		}
+
+		_, ok = LambdaEventFromContext(event_ctx)
+
+		if !ok {
+			t.Fatalf("Missing event for %s event", event_type)
+		}
+
+		switch event_type {
+		case LAMBDA_EVENT_APIGATEWAY_V1:
+
+			if _, ok := APIGatewayV1RequestFromContext(event_ctx); !ok {
+				t.Fatalf("Failed to retrieve API Gateway v1 event")
+			}
+
+			if id.RequestID != "v1-request" || id.Stage != "prod" || id.SourceIP != "192.0.2.1" {
+				t.Fatalf("Unexpected identity for v1 event: %v", id)
+			}
+
+			if id.PrincipalID != "user-1" || id.Claims["sub"] != "abc" || id.Claims["email_verified"] != "true" {
+				t.Fatalf("Unexpected authorizer details for v1 event: %v", id)
+			}
+
+			if id.Authorizer["tenant"] != "example" {
+				t.Fatalf("Unexpected authorizer context for v1 event: %v", id.Authorizer)
+			}
+
+		case LAMBDA_EVENT_APIGATEWAY_V2:
+
+			if _, ok := APIGatewayV2RequestFromContext(event_ctx); !ok {
+				t.Fatalf("Failed to retrieve API Gateway v2 event")
+			}
+
+			if id.RequestID != "v2-request" || id.Stage != "$default" || id.SourceIP != "192.0.2.2" {
+				t.Fatalf("Unexpected identity for v2 event: %v", id)
+			}
+
+			if id.Claims["sub"] != "def" || len(id.Scopes) != 1 {
+				t.Fatalf("Unexpected authorizer details for v2 event: %v", id)
+			}
+
+		case LAMBDA_EVENT_ALB:
+
+			if _, ok := ALBRequestFromContext(event_ctx); !ok {
+				t.Fatalf("Failed to retrieve ALB event")
+			}
+
+			if id.SourceIP != "192.0.2.3" || id.RequestID != "Root=1-abc" || id.TargetGroupARN == "" {
+				t.Fatalf("Unexpected identity for ALB event: %v", id)
+			}
+
+		case LAMBDA_EVENT_FUNCTIONURL:
+
+			if _, ok := FunctionURLRequestFromContext(event_ctx); !ok {
+				t.Fatalf("Failed to retrieve Function URL event")
+			}
+
+			if id.RequestID != "fu-request" || id.SourceIP != "192.0.2.4" {
+				t.Fatalf("Unexpected identity for Function URL event: %v", id)
+			}
+
+			if id.IAM == nil || id.IAM.UserARN != "arn:aws:iam::123456789012:user/example" {
+				t.Fatalf("Unexpected IAM identity for Function URL event: %v", id.IAM)
+			}
+		}
+	}
+
+	_, err := withLambdaEvent(ctx, "invalid")
+
+	if err == nil {
+		t.Fatalf("Expected unsupported event type to fail")
+	}
+}
+
+func TestLambdaFunctionURLRequestIdentity(t *testing.T) {
+
+	ctx := context.Background()
+
+	event := events.LambdaFunctionURLRequest{
+		RawPath: "/",
+	}
+
+	event.RequestContext.HTTP.Method = http.MethodGet
+	event.RequestContext.RequestID = "fu-request"
+
+	req, err := newHTTPRequest(ctx, event)
+
+	if err != nil {
+		t.Fatalf("Failed to create request, %v", err)
+	}
+
+	id, ok := LambdaIdentityFromContext(req.Context())
+
+	if !ok {
+		t.Fatalf("Missing identity in request context")
+	}
+
+	if id.RequestID != "fu-request" {
+		t.Fatalf("Unexpected request ID: %s", id.RequestID)
+	}
+}
+
+func TestLambdaEventHandler(t *testing.T) {
+
+	var id *LambdaIdentity
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+		id, _ = LambdaIdentityFromContext(req.Context())
+	}
+
+	h := newLambdaEventHandler(http.HandlerFunc(handler))
+
+	// Requests that were not derived from Lambda events are passed through untouched
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if id != nil {
+		t.Fatalf("Expected no identity for plain request")
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffLines returns a line-based diff of 'a' and 'b', labeled 'a_name' and 'b_name', derived from
+// their longest common subsequence of lines.
+func diffLines(a_name string, a []byte, b_name string, b []byte) string {
+
+	a_lines := strings.SplitAfter(strings.TrimSuffix(string(a), "\n"), "\n")
+	b_lines := strings.SplitAfter(strings.TrimSuffix(string(b), "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a_lines[i:] and b_lines[j:]
+
+	lcs := make([][]int, len(a_lines)+1)
+
+	for i := range lcs {
+		lcs[i] = make([]int, len(b_lines)+1)
+	}
+
+	for i := len(a_lines) - 1; i >= 0; i-- {
+
+		for j := len(b_lines) - 1; j >= 0; j-- {
+
+			if a_lines[i] == b_lines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", a_name, b_name)
+
+	i := 0
+	j := 0
+
+	write := func(prefix string, line string) {
+		sb.WriteString(prefix + strings.TrimSuffix(line, "\n") + "\n")
+	}
+
+	for i < len(a_lines) || j < len(b_lines) {
+
+		switch {
+		case i < len(a_lines) && j < len(b_lines) && a_lines[i] == b_lines[j]:
+			write(" ", a_lines[i])
+			i++
+			j++
+		case i < len(a_lines) && (j == len(b_lines) || lcs[i+1][j] >= lcs[i][j+1]):
+			write("-", a_lines[i])
+			i++
+		default:
+			write("+", b_lines[j])
+			j++
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// The function ARN assigned to Lambda invocations by the `lambdaemulator://` server.
+const LAMBDA_EMULATOR_FUNCTION_ARN string = "arn:aws:lambda:local:000000000000:function:emulator"
+
+func init() {
+	ctx := context.Background()
+	RegisterServer(ctx, "lambdaemulator", NewLambdaEmulatorServer)
+}
+
+// LambdaEmulatorServer implements the `Server` interface for running a Lambda-bound handler locally. It listens for
+// ordinary HTTP requests, translates each one in to a Lambda event, invokes the same Lambda handler that a `lambda://`
+// or `functionurl://` server would use and translates the resulting Lambda response back in to an HTTP response.
+type LambdaEmulatorServer struct {
+	Server
+	url           *url.URL
+	http_server   Server
+	lambda_server LambdaHandlerServer
+	event_type    string
+	logger        *slog.Logger
+}
+
+// NewLambdaEmulatorServer returns a new `LambdaEmulatorServer` instance configured by 'uri' which is
+// expected to be defined in the form of:
+//
+//	lambdaemulator://{ADDRESS}:{PORT}?{PARAMETERS}
+//
+// Where {ADDRESS} and {PORT} are the address and port to listen for HTTP requests on. Valid parameters are:
+// * `server={URI}` The URI of the `lambda://` or `functionurl://` server to emulate. Default is "lambda://".
+// * `event={EVENT_TYPE}` The type of Lambda event to translate requests in to. Valid options are "apigateway_v1", "apigateway_v2" and "alb"
+// for `lambda://` servers and "functionurl" for `functionurl://` servers. Default is the first event type supported by the server.
+// * Any other parameters (for example, `read_timeout` or `write_timeout`) are passed to the underlying `http://` server.
+func NewLambdaEmulatorServer(ctx context.Context, uri string) (Server, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	server_uri := "lambda://"
+
+	if q.Has("server") {
+		server_uri = q.Get("server")
+	}
+
+	s, err := NewServer(ctx, server_uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create Lambda server, %w", err)
+	}
+
+	lambda_server, ok := s.(LambdaHandlerServer)
+
+	if !ok {
+		return nil, fmt.Errorf("Server (%s) does not support Lambda events", server_uri)
+	}
+
+	event_types := lambda_server.LambdaEventTypes()
+	event_type := event_types[0]
+
+	if q.Has("event") {
+
+		event_type = strings.ToLower(q.Get("event"))
+
+		if !slices.Contains(event_types, event_type) {
+			return nil, fmt.Errorf("Invalid event parameter, %s (valid options for %s are: %s)", event_type, server_uri, strings.Join(event_types, ", "))
+		}
+	}
+
+	q.Del("server")
+	q.Del("event")
+
+	http_u := url.URL{
+		Scheme:   "http",
+		Host:     u.Host,
+		RawQuery: q.Encode(),
+	}
+
+	http_server, err := NewHTTPServer(ctx, http_u.String())
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create HTTP server, %w", err)
+	}
+
+	server := LambdaEmulatorServer{
+		url:           u,
+		http_server:   http_server,
+		lambda_server: lambda_server,
+		event_type:    event_type,
+		logger:        LoggerFromContext(ctx),
+	}
+
+	return &server, nil
+}
+
+// Address returns the fully-qualified URL that the emulator listens for HTTP requests on.
+func (s *LambdaEmulatorServer) Address() string {
+	return s.http_server.Address()
+}
+
+// ListenAndServe starts the server and listens for HTTP requests, translating them in to Lambda events
+// that are handled by 'mux'.
+func (s *LambdaEmulatorServer) ListenAndServe(ctx context.Context, mux http.Handler) error {
+
+	handler := NewLambdaEmulatorHandler(s.lambda_server.LambdaHandler(mux), s.event_type, s.logger)
+	return s.http_server.ListenAndServe(ctx, handler)
+}
+
+// NewLambdaEmulatorHandler returns an `http.Handler` that translates HTTP requests in to Lambda events of type 'event_type'
+// (one of the LAMBDA_EVENT_* constants), invokes 'lambda_handler' with those events and writes the Lambda response back
+// as an HTTP response. Like Lambda, request payloads larger than `LAMBDA_MAX_PAYLOAD_SIZE` are rejected with a
+// "413 Request Entity Too Large" error and handler errors are returned as "502 Bad Gateway" errors.
+func NewLambdaEmulatorHandler(lambda_handler lambda.Handler, event_type string, logger *slog.Logger) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		logger := logger.With("method", req.Method, "path", req.URL.Path)
+
+		body, err := io.ReadAll(http.MaxBytesReader(rsp, req.Body, int64(LAMBDA_MAX_PAYLOAD_SIZE)))
+
+		if err != nil {
+
+			var max_err *http.MaxBytesError
+
+			if errors.As(err, &max_err) {
+				logger.Error("Request payload exceeds maximum size", "max_payload_size", LAMBDA_MAX_PAYLOAD_SIZE)
+				http.Error(rsp, "Request payload exceeds the maximum Lambda request size", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			logger.Error("Failed to read request body", "error", err)
+			http.Error(rsp, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		req = newLambdaEmulatorRequest(req, body)
+
+		event, err := NewLambdaEvent(req, event_type)
+
+		if err != nil {
+			logger.Error("Failed to create Lambda event", "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		payload, err := json.Marshal(event)
+
+		if err != nil {
+			logger.Error("Failed to marshal Lambda event", "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if len(payload) > LAMBDA_MAX_PAYLOAD_SIZE {
+			logger.Error("Request payload exceeds maximum size", "size", len(payload), "max_payload_size", LAMBDA_MAX_PAYLOAD_SIZE)
+			http.Error(rsp, "Request payload exceeds the maximum Lambda request size", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		lambda_ctx := lambdacontext.NewContext(WithLogger(req.Context(), logger), &lambdacontext.LambdaContext{
+			AwsRequestID:       newLambdaEventRequestID(),
+			InvokedFunctionArn: LAMBDA_EMULATOR_FUNCTION_ARN,
+		})
+
+		rsp_payload, err := lambda_handler.Invoke(lambda_ctx, payload)
+
+		if err != nil {
+			logger.Error("Lambda handler returned an error", "error", err)
+			http.Error(rsp, "Bad gateway", http.StatusBadGateway)
+			return
+		}
+
+		event_rsp, err := NewLambdaHTTPResponse(rsp_payload)
+
+		if err != nil {
+			logger.Error("Failed to create HTTP response from Lambda response", "error", err)
+			http.Error(rsp, "Bad gateway", http.StatusBadGateway)
+			return
+		}
+
+		err = writeLambdaHTTPResponse(rsp, event_rsp)
+
+		if err != nil {
+			logger.Error("Failed to write response", "error", err)
+		}
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// newLambdaEmulatorRequest returns a copy of 'req' with 'body' and the "X-Forwarded-*" and "X-Amzn-Trace-Id"
+// headers that API Gateway, ALB and Function URLs add to requests.
+func newLambdaEmulatorRequest(req *http.Request, body []byte) *http.Request {
+
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	if req.Header.Get("X-Forwarded-Proto") == "" {
+
+		proto := "http"
+
+		if req.TLS != nil {
+			proto = "https"
+		}
+
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+
+	if req.Header.Get("X-Forwarded-Port") == "" {
+
+		_, port, err := net.SplitHostPort(req.Host)
+
+		if err == nil {
+			req.Header.Set("X-Forwarded-Port", port)
+		}
+	}
+
+	if req.Header.Get("X-Amzn-Trace-Id") == "" {
+		trace_id := strings.ReplaceAll(newLambdaEventRequestID(), "-", "")
+		req.Header.Set("X-Amzn-Trace-Id", fmt.Sprintf("Root=1-%x-%s", time.Now().Unix(), trace_id[0:24]))
+	}
+
+	if req.Header.Get("X-Forwarded-For") == "" {
+
+		source_ip := lambdaEventSourceIP(req)
+
+		if source_ip != "" {
+			req.Header.Set("X-Forwarded-For", source_ip)
+		}
+	}
+
+	return req
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLambdaEmulatorServer(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "lambdaemulator://localhost:8082?server=functionurl://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new server, %v", err)
+	}
+
+	if s.Address() != "http://localhost:8082" {
+		t.Fatalf("Unexpected address: %s", s.Address())
+	}
+
+	_, err = NewServer(ctx, "lambdaemulator://localhost:8082?server=functionurl://&event=alb")
+
+	if err == nil {
+		t.Fatalf("Expected invalid event type to fail")
+	}
+
+	_, err = NewServer(ctx, "lambdaemulator://localhost:8082?server=http://localhost:8083")
+
+	if err == nil {
+		t.Fatalf("Expected non-Lambda server to fail")
+	}
+}
+
+func TestLambdaEmulatorHandler(t *testing.T) {
+
+	ctx := context.Background()
+
+	binary_body := []byte{0x89, 0x50, 0x4e, 0x47, 0x00, 0xff}
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		id, ok := LambdaIdentityFromContext(req.Context())
+
+		if !ok {
+			http.Error(rsp, "Missing identity", http.StatusInternalServerError)
+			return
+		}
+
+		switch req.URL.Path {
+		case "/binary":
+
+			body, _ := io.ReadAll(req.Body)
+
+			if !bytes.Equal(body, binary_body) {
+				http.Error(rsp, "Unexpected body", http.StatusBadRequest)
+				return
+			}
+
+			rsp.Header().Set("Content-Type", "application/octet-stream")
+			rsp.Write(body)
+
+		default:
+
+			c, err := req.Cookie("b")
+
+			if err != nil {
+				http.Error(rsp, "Missing cookie", http.StatusBadRequest)
+				return
+			}
+
+			http.SetCookie(rsp, &http.Cookie{Name: "c", Value: "3"})
+			rsp.Header().Set("Content-Type", "text/plain")
+			rsp.Header().Add("X-Multi", "one")
+			rsp.Header().Add("X-Multi", "two")
+
+			fmt.Fprintf(rsp, "%s %s %s %s", id.EventType, strings.Join(req.URL.Query()["q"], "|"), c.Value, req.Header.Get("X-Forwarded-Proto"))
+		}
+	}
+
+	mux := http.HandlerFunc(handler)
+
+	tests := map[string]string{
+		"lambda://?binary_type=application/*":                         LAMBDA_EVENT_APIGATEWAY_V1,
+		"lambda://?binary_auto=true":                                  LAMBDA_EVENT_APIGATEWAY_V2,
+		"lambda://?binary_type=*/*":                                   LAMBDA_EVENT_ALB,
+		"functionurl://?binary_auto=true":                             LAMBDA_EVENT_FUNCTIONURL,
+		"functionurl://?binary_auto=true&invoke_mode=response_stream": LAMBDA_EVENT_FUNCTIONURL,
+	}
+
+	for server_uri, event_type := range tests {
+
+		s, err := NewServer(ctx, server_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create server %s, %v", server_uri, err)
+		}
+
+		lambda_handler := s.(LambdaHandlerServer).LambdaHandler(mux)
+
+		ts := httptest.NewServer(NewLambdaEmulatorHandler(lambda_handler, event_type, slog.Default()))
+		defer ts.Close()
+
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/text?q=a%2Cb&q=c", nil)
+
+		if err != nil {
+			t.Fatalf("Failed to create request, %v", err)
+		}
+
+		req.Header.Add("Cookie", "a=1; b=2")
+
+		rsp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatalf("Failed to execute request for %s (%s), %v", server_uri, event_type, err)
+		}
+
+		body, _ := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected status for %s (%s): %d %s", server_uri, event_type, rsp.StatusCode, body)
+		}
+
+		// Function URLs are always served over HTTPS
+
+		proto := "http"
+
+		if event_type == LAMBDA_EVENT_FUNCTIONURL {
+			proto = "https"
+		}
+
+		expected := fmt.Sprintf("%s a,b|c 2 %s", event_type, proto)
+
+		if string(body) != expected {
+			t.Fatalf("Unexpected body for %s (%s): '%s', expected '%s'", server_uri, event_type, body, expected)
+		}
+
+		if strings.Join(rsp.Header.Values("X-Multi"), ",") != "one,two" {
+			t.Fatalf("Unexpected X-Multi header for %s (%s): %v", server_uri, event_type, rsp.Header.Values("X-Multi"))
+		}
+
+		if len(rsp.Cookies()) != 1 || rsp.Cookies()[0].Value != "3" {
+			t.Fatalf("Unexpected cookies for %s (%s): %v", server_uri, event_type, rsp.Cookies())
+		}
+
+		rsp, err = http.Post(ts.URL+"/binary", "application/octet-stream", bytes.NewReader(binary_body))
+
+		if err != nil {
+			t.Fatalf("Failed to execute binary request for %s (%s), %v", server_uri, event_type, err)
+		}
+
+		body, _ = io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusOK || !bytes.Equal(body, binary_body) {
+			t.Fatalf("Unexpected binary response for %s (%s): %d %v", server_uri, event_type, rsp.StatusCode, body)
+		}
+	}
+}
+
+func TestLambdaEmulatorHandlerPayloadSize(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "functionurl://")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	mux := http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {})
+	lambda_handler := s.(LambdaHandlerServer).LambdaHandler(mux)
+
+	ts := httptest.NewServer(NewLambdaEmulatorHandler(lambda_handler, LAMBDA_EVENT_FUNCTIONURL, slog.Default()))
+	defer ts.Close()
+
+	rsp, err := http.Post(ts.URL, "text/plain", strings.NewReader(strings.Repeat("a", LAMBDA_MAX_PAYLOAD_SIZE+1)))
+
+	if err != nil {
+		t.Fatalf("Failed to execute request, %v", err)
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Unexpected status code: %d", rsp.StatusCode)
+	}
+}
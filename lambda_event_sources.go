@@ -0,0 +1,496 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// The event type for requests derived from SQS messages.
+const LAMBDA_EVENT_SQS string = "sqs"
+
+// The event type for requests derived from SNS notifications.
+const LAMBDA_EVENT_SNS string = "sns"
+
+// The event type for requests derived from EventBridge events.
+const LAMBDA_EVENT_EVENTBRIDGE string = "eventbridge"
+
+// The event type for requests derived from scheduled (EventBridge or CloudWatch Events) events.
+const LAMBDA_EVENT_SCHEDULE string = "schedule"
+
+// The event type for requests derived from S3 event notifications.
+const LAMBDA_EVENT_S3 string = "s3"
+
+// The default path prefix for requests derived from non-HTTP Lambda events. Requests are sent to
+// "{PREFIX}/{EVENT_TYPE}", for example "/_lambda/events/sqs".
+const LAMBDA_EVENTS_PATH string = "/_lambda/events"
+
+// The prefix for the headers containing the metadata of non-HTTP Lambda events.
+const LAMBDA_EVENT_HEADER_PREFIX string = "X-Lambda-Event-"
+
+// lambdaEventSources is the list of non-HTTP event types that `lambda://` servers can route to an `http.Handler`.
+var lambdaEventSources = []string{
+	LAMBDA_EVENT_SQS,
+	LAMBDA_EVENT_SNS,
+	LAMBDA_EVENT_EVENTBRIDGE,
+	LAMBDA_EVENT_SCHEDULE,
+	LAMBDA_EVENT_S3,
+}
+
+// lambdaEventRouter routes non-HTTP Lambda events to an `http.Handler` as synthesized HTTP requests.
+type lambdaEventRouter struct {
+	sources []string
+	path    string
+}
+
+// newLambdaEventRouter returns a new `lambdaEventRouter` instance configured by the `events` and `events_path`
+// parameters in 'q'. If there are no `events` parameters it returns nil.
+func newLambdaEventRouter(q url.Values) (*lambdaEventRouter, error) {
+
+	sources := make([]string, 0)
+
+	for _, v := range q["events"] {
+
+		for _, source := range strings.Split(v, ",") {
+
+			source = strings.ToLower(strings.TrimSpace(source))
+
+			if source == "" {
+				continue
+			}
+
+			if !slices.Contains(lambdaEventSources, source) {
+				return nil, fmt.Errorf("Invalid events parameter, %s (valid options are: %s)", source, strings.Join(lambdaEventSources, ", "))
+			}
+
+			if !slices.Contains(sources, source) {
+				sources = append(sources, source)
+			}
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	path := LAMBDA_EVENTS_PATH
+
+	if q.Has("events_path") {
+
+		path = strings.TrimRight(q.Get("events_path"), "/")
+
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("Invalid events_path parameter, %s (must start with '/')", q.Get("events_path"))
+		}
+	}
+
+	r := &lambdaEventRouter{
+		sources: sources,
+		path:    path,
+	}
+
+	return r, nil
+}
+
+// httpHandler returns an `http.Handler` for requests derived from HTTP (API Gateway and ALB) events which ensures that they
+// can't be mistaken for requests derived from non-HTTP events. Requests for paths under 'r.path' receive a 404 response and
+// any "X-Lambda-Event-*" headers are removed before the remaining requests are served by 'next'.
+func (r *lambdaEventRouter) httpHandler(next http.Handler) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		clean_path := path.Clean("/" + req.URL.Path)
+
+		if clean_path == r.path || strings.HasPrefix(clean_path, r.path+"/") {
+			LoggerFromContext(req.Context()).Warn("Rejected HTTP request for Lambda events path", "path", req.URL.Path)
+			http.NotFound(rsp, req)
+			return
+		}
+
+		for k := range req.Header {
+
+			if strings.HasPrefix(http.CanonicalHeaderKey(k), LAMBDA_EVENT_HEADER_PREFIX) {
+				req.Header.Del(k)
+			}
+		}
+
+		next.ServeHTTP(rsp, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// lambdaEventSourceHandler implements the `lambda.Handler` interface routing the non-HTTP Lambda events enabled
+// in 'router' to 'mux' and all other events to 'handler'.
+type lambdaEventSourceHandler struct {
+	handler lambda.Handler
+	mux     http.Handler
+	router  *lambdaEventRouter
+	logger  *slog.Logger
+}
+
+// lambdaEventProbe contains the properties needed to determine the type of a non-HTTP Lambda event. Note that
+// JSON keys are matched case-insensitively so `EventSource` matches both SQS/S3 ("eventSource") and SNS ("EventSource") records.
+type lambdaEventProbe struct {
+	Records []struct {
+		EventSource string `json:"eventSource"`
+	} `json:"Records"`
+	DetailType string `json:"detail-type"`
+	Source     string `json:"source"`
+}
+
+// lambdaEventResult is the outcome of serving a single synthesized HTTP request.
+type lambdaEventResult struct {
+	id     string
+	status int
+	err    error
+}
+
+// Invoke routes 'payload' to the `http.Handler` if it is one of the enabled non-HTTP event types and to the
+// underlying (HTTP) handler otherwise.
+func (h *lambdaEventSourceHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+
+	event_type := lambdaEventSourceType(payload)
+
+	if event_type == "" || !slices.Contains(h.router.sources, event_type) {
+		return h.handler.Invoke(ctx, payload)
+	}
+
+	switch event_type {
+	case LAMBDA_EVENT_SQS:
+		return h.invokeSQS(ctx, payload)
+	case LAMBDA_EVENT_SNS:
+		return h.invokeSNS(ctx, payload)
+	case LAMBDA_EVENT_S3:
+		return h.invokeS3(ctx, payload)
+	default:
+		return h.invokeEventBridge(ctx, event_type, payload)
+	}
+}
+
+// invokeSQS serves each message in the SQS event in 'payload' and reports the messages whose requests did not
+// return a 2xx status code as batch item failures. Once a message from a FIFO queue fails the remaining messages
+// are reported as failures, without being served, in order to preserve their ordering.
+func (h *lambdaEventSourceHandler) invokeSQS(ctx context.Context, payload []byte) ([]byte, error) {
+
+	var ev events.SQSEvent
+
+	err := json.Unmarshal(payload, &ev)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal SQS event, %w", err)
+	}
+
+	rsp := events.SQSEventResponse{
+		BatchItemFailures: make([]events.SQSBatchItemFailure, 0),
+	}
+
+	fifo_failed := false
+
+	for _, msg := range ev.Records {
+
+		if fifo_failed {
+			rsp.BatchItemFailures = append(rsp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+			continue
+		}
+
+		header := make(http.Header)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Id", msg.MessageId)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Source-Arn", msg.EventSourceARN)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Region", msg.AWSRegion)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Receipt-Handle", msg.ReceiptHandle)
+
+		for k, v := range msg.Attributes {
+			header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Attribute-"+k, v)
+		}
+
+		for k, v := range msg.MessageAttributes {
+
+			if v.StringValue != nil {
+				header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Message-Attribute-"+k, *v.StringValue)
+			}
+		}
+
+		result := h.serve(ctx, LAMBDA_EVENT_SQS, msg.MessageId, msg, header, []byte(msg.Body))
+
+		if result.err != nil {
+
+			rsp.BatchItemFailures = append(rsp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+
+			if msg.Attributes["MessageGroupId"] != "" {
+				fifo_failed = true
+			}
+		}
+	}
+
+	return json.Marshal(rsp)
+}
+
+// invokeSNS serves each record in the SNS event in 'payload'.
+func (h *lambdaEventSourceHandler) invokeSNS(ctx context.Context, payload []byte) ([]byte, error) {
+
+	var ev events.SNSEvent
+
+	err := json.Unmarshal(payload, &ev)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal SNS event, %w", err)
+	}
+
+	results := make([]*lambdaEventResult, len(ev.Records))
+
+	for i, r := range ev.Records {
+
+		header := make(http.Header)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Id", r.SNS.MessageID)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Source-Arn", r.SNS.TopicArn)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Subscription-Arn", r.EventSubscriptionArn)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Message-Type", r.SNS.Type)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Subject", r.SNS.Subject)
+
+		if !r.SNS.Timestamp.IsZero() {
+			header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Time", r.SNS.Timestamp.Format(time.RFC3339Nano))
+		}
+
+		for k, v := range r.SNS.MessageAttributes {
+
+			attr, ok := v.(map[string]any)
+
+			if !ok {
+				continue
+			}
+
+			header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Message-Attribute-"+k, fmt.Sprintf("%v", attr["Value"]))
+		}
+
+		results[i] = h.serve(ctx, LAMBDA_EVENT_SNS, r.SNS.MessageID, r, header, []byte(r.SNS.Message))
+	}
+
+	return lambdaEventResults(LAMBDA_EVENT_SNS, results)
+}
+
+// invokeS3 serves each record in the S3 event in 'payload'. The body of each request is the JSON-encoded record.
+func (h *lambdaEventSourceHandler) invokeS3(ctx context.Context, payload []byte) ([]byte, error) {
+
+	var ev events.S3Event
+
+	err := json.Unmarshal(payload, &ev)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal S3 event, %w", err)
+	}
+
+	results := make([]*lambdaEventResult, len(ev.Records))
+
+	for i, r := range ev.Records {
+
+		body, err := json.Marshal(r)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal S3 record, %w", err)
+		}
+
+		id := r.S3.Object.Sequencer
+
+		header := make(http.Header)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Id", id)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Name", r.EventName)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Source-Arn", r.S3.Bucket.Arn)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Region", r.AWSRegion)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Bucket", r.S3.Bucket.Name)
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Key", r.S3.Object.URLDecodedKey)
+
+		if !r.EventTime.IsZero() {
+			header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Time", r.EventTime.Format(time.RFC3339Nano))
+		}
+
+		results[i] = h.serve(ctx, LAMBDA_EVENT_S3, id, r, header, body)
+	}
+
+	return lambdaEventResults(LAMBDA_EVENT_S3, results)
+}
+
+// invokeEventBridge serves the EventBridge (or scheduled) event in 'payload'. The body of the request is the event's detail.
+func (h *lambdaEventSourceHandler) invokeEventBridge(ctx context.Context, event_type string, payload []byte) ([]byte, error) {
+
+	var ev events.EventBridgeEvent
+
+	err := json.Unmarshal(payload, &ev)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal EventBridge event, %w", err)
+	}
+
+	header := make(http.Header)
+	header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Id", ev.ID)
+	header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Source", ev.Source)
+	header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Detail-Type", ev.DetailType)
+	header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Account", ev.AccountID)
+	header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Region", ev.Region)
+
+	if !ev.Time.IsZero() {
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Time", ev.Time.Format(time.RFC3339Nano))
+	}
+
+	if len(ev.Resources) > 0 {
+		header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Resources", strings.Join(ev.Resources, ","))
+	}
+
+	result := h.serve(ctx, event_type, ev.ID, ev, header, ev.Detail)
+
+	return lambdaEventResults(event_type, []*lambdaEventResult{result})
+}
+
+// serve sends a POST request with 'body' and 'header' to the path for 'event_type' and returns the outcome. Responses
+// with a status code outside of the 2xx range are reported as errors.
+func (h *lambdaEventSourceHandler) serve(ctx context.Context, event_type string, id string, event any, header http.Header, body []byte) *lambdaEventResult {
+
+	path := h.router.path + "/" + event_type
+	logger := h.logger.With("event_type", event_type, "id", id, "path", path)
+
+	result := &lambdaEventResult{
+		id: id,
+	}
+
+	ctx, err := withLambdaEvent(ctx, event)
+
+	if err != nil {
+		result.err = err
+		logger.Error("Failed to derive Lambda identity", "error", err)
+		return result
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, bytes.NewReader(body))
+
+	if err != nil {
+		result.err = fmt.Errorf("Failed to create request, %w", err)
+		logger.Error("Failed to create request", "error", err)
+		return result
+	}
+
+	req.RequestURI = path
+
+	for k, values := range header {
+
+		for _, v := range values {
+
+			if v != "" {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+
+	req.Header.Set(LAMBDA_EVENT_HEADER_PREFIX+"Type", event_type)
+	req.Header.Set("Content-Type", lambdaEventContentType(body))
+
+	rec := httptest.NewRecorder()
+	h.mux.ServeHTTP(rec, req)
+
+	result.status = rec.Code
+
+	if result.status < 200 || result.status > 299 {
+		result.err = fmt.Errorf("Request for %s event %s returned status %d", event_type, id, result.status)
+		logger.Error("Failed to handle Lambda event", "status", result.status)
+	}
+
+	return result
+}
+
+// lambdaEventResults returns an error joining the errors in 'results', if any. Since the Lambda service
+// retries asynchronous invocations which fail this is how failures are reported for non-SQS events.
+func lambdaEventResults(event_type string, results []*lambdaEventResult) ([]byte, error) {
+
+	errs := make([]error, 0)
+
+	for _, r := range results {
+
+		if r.err != nil {
+			errs = append(errs, r.err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("Failed to handle %d of %d %s records, %w", len(errs), len(results), event_type, errors.Join(errs...))
+	}
+
+	return []byte("null"), nil
+}
+
+// lambdaEventSourceType returns the non-HTTP event type (one of the LAMBDA_EVENT_* constants) of the
+// Lambda event in 'payload' or an empty string if it is not a supported event.
+func lambdaEventSourceType(payload []byte) string {
+
+	var probe lambdaEventProbe
+
+	err := json.Unmarshal(payload, &probe)
+
+	if err != nil {
+		return ""
+	}
+
+	if len(probe.Records) > 0 {
+
+		switch probe.Records[0].EventSource {
+		case "aws:sqs":
+			return LAMBDA_EVENT_SQS
+		case "aws:sns":
+			return LAMBDA_EVENT_SNS
+		case "aws:s3":
+			return LAMBDA_EVENT_S3
+		}
+
+		return ""
+	}
+
+	if probe.DetailType != "" && probe.Source != "" {
+		return lambdaEventBridgeType(probe.Source, probe.DetailType)
+	}
+
+	return ""
+}
+
+// lambdaEventBridgeType returns `LAMBDA_EVENT_SCHEDULE` if 'source' and 'detail_type' are those of a scheduled
+// event and `LAMBDA_EVENT_EVENTBRIDGE` otherwise.
+func lambdaEventBridgeType(source string, detail_type string) string {
+
+	if source == "aws.events" && detail_type == "Scheduled Event" {
+		return LAMBDA_EVENT_SCHEDULE
+	}
+
+	return LAMBDA_EVENT_EVENTBRIDGE
+}
+
+// lambdaEventContentType returns "application/json" if 'body' is valid JSON and "text/plain" otherwise.
+func lambdaEventContentType(body []byte) string {
+
+	if len(body) > 0 && json.Valid(body) {
+		return "application/json"
+	}
+
+	return "text/plain; charset=utf-8"
+}
+
+// arnAccountID returns the account ID component of 'arn', if present.
+func arnAccountID(arn string) string {
+
+	parts := strings.SplitN(arn, ":", 6)
+
+	if len(parts) < 6 {
+		return ""
+	}
+
+	return parts[4]
+}
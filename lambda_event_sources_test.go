@@ -0,0 +1,386 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type lambdaEventSourceTestRequest struct {
+	Path        string
+	Body        string
+	ContentType string
+	Header      http.Header
+	Identity    *LambdaIdentity
+}
+
+func newLambdaEventSourceTestHandler(requests *[]*lambdaEventSourceTestRequest) http.Handler {
+
+	mu := new(sync.Mutex)
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		body, _ := io.ReadAll(req.Body)
+		id, _ := LambdaIdentityFromContext(req.Context())
+
+		mu.Lock()
+
+		*requests = append(*requests, &lambdaEventSourceTestRequest{
+			Path:        req.URL.Path,
+			Body:        string(body),
+			ContentType: req.Header.Get("Content-Type"),
+			Header:      req.Header,
+			Identity:    id,
+		})
+
+		mu.Unlock()
+
+		if strings.Contains(string(body), "fail") {
+			http.Error(rsp, "Failed", http.StatusInternalServerError)
+			return
+		}
+
+		rsp.WriteHeader(http.StatusNoContent)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func TestLambdaEventSourceType(t *testing.T) {
+
+	tests := map[string]string{
+		`{"Records":[{"eventSource":"aws:sqs","messageId":"1"}]}`:                       LAMBDA_EVENT_SQS,
+		`{"Records":[{"EventSource":"aws:sns","Sns":{"Message":"hello"}}]}`:             LAMBDA_EVENT_SNS,
+		`{"Records":[{"eventSource":"aws:s3","eventName":"ObjectCreated:Put"}]}`:        LAMBDA_EVENT_S3,
+		`{"Records":[{"eventSource":"aws:kinesis"}]}`:                                   "",
+		`{"source":"aws.events","detail-type":"Scheduled Event","detail":{}}`:           LAMBDA_EVENT_SCHEDULE,
+		`{"source":"com.example","detail-type":"Order Placed","detail":{"id":1}}`:       LAMBDA_EVENT_EVENTBRIDGE,
+		`{"version":"2.0","rawPath":"/","requestContext":{"http":{"method":"GET"}}}`:    "",
+		`{"httpMethod":"GET","path":"/","requestContext":{"accountId":"123456789012"}}`: "",
+		`not json`: "",
+	}
+
+	for payload, expected := range tests {
+
+		event_type := lambdaEventSourceType([]byte(payload))
+
+		if event_type != expected {
+			t.Fatalf("Unexpected event type for %s: '%s' (expected '%s')", payload, event_type, expected)
+		}
+	}
+}
+
+func TestNewLambdaEventRouter(t *testing.T) {
+
+	ctx := context.Background()
+
+	for _, uri := range []string{"lambda://?events=sqs,kinesis", "lambda://?events=sqs&events_path=events"} {
+
+		_, err := NewServer(ctx, uri)
+
+		if err == nil {
+			t.Fatalf("Expected %s to fail", uri)
+		}
+	}
+}
+
+func TestLambdaEventSourceHandlerSQS(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "lambda://?events=sqs,schedule")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	requests := make([]*lambdaEventSourceTestRequest, 0)
+	lambda_handler := s.(LambdaHandlerServer).LambdaHandler(newLambdaEventSourceTestHandler(&requests))
+
+	ev := events.SQSEvent{
+		Records: []events.SQSMessage{
+			{MessageId: "1", Body: `{"ok":true}`, EventSource: "aws:sqs", EventSourceARN: "arn:aws:sqs:us-east-1:123456789012:queue"},
+			{MessageId: "2", Body: "fail", EventSource: "aws:sqs"},
+			{MessageId: "3", Body: "hello", EventSource: "aws:sqs"},
+		},
+	}
+
+	payload, _ := json.Marshal(ev)
+
+	rsp, err := lambda_handler.Invoke(ctx, payload)
+
+	if err != nil {
+		t.Fatalf("Failed to invoke handler, %v", err)
+	}
+
+	var sqs_rsp events.SQSEventResponse
+
+	err = json.Unmarshal(rsp, &sqs_rsp)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response, %v", err)
+	}
+
+	if len(sqs_rsp.BatchItemFailures) != 1 || sqs_rsp.BatchItemFailures[0].ItemIdentifier != "2" {
+		t.Fatalf("Unexpected batch item failures: %s", rsp)
+	}
+
+	if len(requests) != 3 {
+		t.Fatalf("Unexpected number of requests: %d", len(requests))
+	}
+
+	req := requests[0]
+
+	if req.Path != LAMBDA_EVENTS_PATH+"/sqs" || req.Body != `{"ok":true}` || req.ContentType != "application/json" {
+		t.Fatalf("Unexpected request: %s '%s' %s", req.Path, req.Body, req.ContentType)
+	}
+
+	if req.Header.Get("X-Lambda-Event-Id") != "1" || req.Header.Get("X-Lambda-Event-Type") != LAMBDA_EVENT_SQS {
+		t.Fatalf("Unexpected headers: %v", req.Header)
+	}
+
+	if req.Identity == nil || req.Identity.EventType != LAMBDA_EVENT_SQS || req.Identity.AccountID != "123456789012" {
+		t.Fatalf("Unexpected identity: %v", req.Identity)
+	}
+
+	if requests[2].ContentType != "text/plain; charset=utf-8" {
+		t.Fatalf("Unexpected content type: %s", requests[2].ContentType)
+	}
+}
+
+func TestLambdaEventSourceHandlerSQSFIFO(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "lambda://?events=sqs")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	requests := make([]*lambdaEventSourceTestRequest, 0)
+	lambda_handler := s.(LambdaHandlerServer).LambdaHandler(newLambdaEventSourceTestHandler(&requests))
+
+	attrs := map[string]string{"MessageGroupId": "group"}
+
+	ev := events.SQSEvent{
+		Records: []events.SQSMessage{
+			{MessageId: "1", Body: "fail", EventSource: "aws:sqs", Attributes: attrs},
+			{MessageId: "2", Body: "hello", EventSource: "aws:sqs", Attributes: attrs},
+		},
+	}
+
+	payload, _ := json.Marshal(ev)
+
+	rsp, err := lambda_handler.Invoke(ctx, payload)
+
+	if err != nil {
+		t.Fatalf("Failed to invoke handler, %v", err)
+	}
+
+	var sqs_rsp events.SQSEventResponse
+
+	err = json.Unmarshal(rsp, &sqs_rsp)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response, %v", err)
+	}
+
+	if len(sqs_rsp.BatchItemFailures) != 2 {
+		t.Fatalf("Unexpected batch item failures: %s", rsp)
+	}
+
+	if len(requests) != 1 {
+		t.Fatalf("Expected messages following a failed FIFO message to be skipped, got %d requests", len(requests))
+	}
+}
+
+func TestLambdaEventSourceHandlerEvents(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "lambda://?events=sns,s3,eventbridge,schedule&events_path=/events/")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	requests := make([]*lambdaEventSourceTestRequest, 0)
+	lambda_handler := s.(LambdaHandlerServer).LambdaHandler(newLambdaEventSourceTestHandler(&requests))
+
+	tests := []struct {
+		Payload string
+		Path    string
+		Body    string
+		Header  string
+		Value   string
+	}{
+		{
+			Payload: `{"Records":[{"EventSource":"aws:sns","Sns":{"MessageId":"m1","TopicArn":"arn:aws:sns:us-east-1:123456789012:topic","Subject":"Hi","Message":"hello"}}]}`,
+			Path:    "/events/sns",
+			Body:    "hello",
+			Header:  "X-Lambda-Event-Subject",
+			Value:   "Hi",
+		},
+		{
+			Payload: `{"Records":[{"eventSource":"aws:s3","eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"bucket"},"object":{"key":"a+b.txt"}}}]}`,
+			Path:    "/events/s3",
+			Header:  "X-Lambda-Event-Key",
+			Value:   "a b.txt",
+		},
+		{
+			Payload: `{"id":"e1","source":"com.example","detail-type":"Order Placed","account":"123456789012","detail":{"order":1}}`,
+			Path:    "/events/eventbridge",
+			Body:    `{"order":1}`,
+			Header:  "X-Lambda-Event-Detail-Type",
+			Value:   "Order Placed",
+		},
+		{
+			Payload: `{"id":"e2","source":"aws.events","detail-type":"Scheduled Event","resources":["arn:aws:events:us-east-1:123456789012:rule/hourly"],"detail":{}}`,
+			Path:    "/events/schedule",
+			Body:    `{}`,
+			Header:  "X-Lambda-Event-Resources",
+			Value:   "arn:aws:events:us-east-1:123456789012:rule/hourly",
+		},
+	}
+
+	for _, test := range tests {
+
+		requests = requests[:0]
+
+		rsp, err := lambda_handler.Invoke(ctx, []byte(test.Payload))
+
+		if err != nil {
+			t.Fatalf("Failed to invoke handler for %s, %v", test.Path, err)
+		}
+
+		if string(rsp) != "null" {
+			t.Fatalf("Unexpected response for %s: %s", test.Path, rsp)
+		}
+
+		if len(requests) != 1 {
+			t.Fatalf("Unexpected number of requests for %s: %d", test.Path, len(requests))
+		}
+
+		req := requests[0]
+
+		if req.Path != test.Path {
+			t.Fatalf("Unexpected path: %s (expected %s)", req.Path, test.Path)
+		}
+
+		if test.Body != "" && req.Body != test.Body {
+			t.Fatalf("Unexpected body for %s: '%s'", test.Path, req.Body)
+		}
+
+		if req.Header.Get(test.Header) != test.Value {
+			t.Fatalf("Unexpected %s header for %s: '%s'", test.Header, test.Path, req.Header.Get(test.Header))
+		}
+	}
+
+	// Failures are reported as errors so that the Lambda service retries the event
+
+	_, err = lambda_handler.Invoke(ctx, []byte(`{"Records":[{"EventSource":"aws:sns","Sns":{"MessageId":"m2","Message":"fail"}}]}`))
+
+	if err == nil {
+		t.Fatalf("Expected failed SNS record to return an error")
+	}
+}
+
+func TestLambdaEventSourceHandlerDisabled(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "lambda://?events=sqs")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	requests := make([]*lambdaEventSourceTestRequest, 0)
+	lambda_handler := s.(LambdaHandlerServer).LambdaHandler(newLambdaEventSourceTestHandler(&requests))
+
+	// SNS events are not enabled so they are passed to the HTTP handler which does not support them
+
+	_, err = lambda_handler.Invoke(ctx, []byte(`{"Records":[{"EventSource":"aws:sns","Sns":{"Message":"hello"}}]}`))
+
+	if err == nil {
+		t.Fatalf("Expected disabled event type to fail")
+	}
+
+	// HTTP events are still handled
+
+	rsp, err := InvokeLambdaEvent(ctx, lambda_handler, []byte(`{"version":"2.0","rawPath":"/","requestContext":{"http":{"method":"GET"}}}`))
+
+	if err != nil {
+		t.Fatalf("Failed to invoke HTTP event, %v", err)
+	}
+
+	if !strings.Contains(string(rsp), `"statusCode": 204`) {
+		t.Fatalf("Unexpected response: %s", rsp)
+	}
+
+	if len(requests) != 1 || requests[0].Identity.EventType != LAMBDA_EVENT_APIGATEWAY_V2 {
+		t.Fatalf("Unexpected requests")
+	}
+}
+
+func TestLambdaEventSourceHandlerHTTPEvents(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "lambda://?events=sqs")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	requests := make([]*lambdaEventSourceTestRequest, 0)
+	lambda_handler := s.(LambdaHandlerServer).LambdaHandler(newLambdaEventSourceTestHandler(&requests))
+
+	// HTTP events can't target the events path
+
+	for _, path := range []string{"/_lambda/events/sqs", "/_lambda/events", "/_lambda//events/./sqs"} {
+
+		payload := []byte(`{"version":"2.0","rawPath":"` + path + `","headers":{"x-lambda-event-type":"sqs"},"requestContext":{"http":{"method":"POST"}}}`)
+
+		rsp, err := InvokeLambdaEvent(ctx, lambda_handler, payload)
+
+		if err != nil {
+			t.Fatalf("Failed to invoke HTTP event for %s, %v", path, err)
+		}
+
+		if !strings.Contains(string(rsp), `"statusCode": 404`) {
+			t.Fatalf("Unexpected response for %s: %s", path, rsp)
+		}
+	}
+
+	if len(requests) != 0 {
+		t.Fatalf("Expected HTTP events for the events path not to be served")
+	}
+
+	// X-Lambda-Event-* headers are removed from other HTTP events
+
+	payload := []byte(`{"version":"2.0","rawPath":"/hello","headers":{"x-lambda-event-type":"sqs","x-lambda-event-id":"1","x-other":"ok"},"requestContext":{"http":{"method":"POST"}}}`)
+
+	_, err = InvokeLambdaEvent(ctx, lambda_handler, payload)
+
+	if err != nil {
+		t.Fatalf("Failed to invoke HTTP event, %v", err)
+	}
+
+	if len(requests) != 1 {
+		t.Fatalf("Unexpected number of requests: %d", len(requests))
+	}
+
+	header := requests[0].Header
+
+	if header.Get("X-Lambda-Event-Type") != "" || header.Get("X-Lambda-Event-Id") != "" || header.Get("X-Other") != "ok" {
+		t.Fatalf("Unexpected headers: %v", header)
+	}
+}
@@ -0,0 +1,472 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// The account ID assigned to events created from HTTP requests.
+const LAMBDA_EMULATOR_ACCOUNT_ID string = "000000000000"
+
+// The target group ARN assigned to ALB events created from HTTP requests.
+const LAMBDA_EMULATOR_TARGET_GROUP_ARN string = "arn:aws:elasticloadbalancing:local:000000000000:targetgroup/emulator/0000000000000000"
+
+// LambdaHandlerServer is implemented by servers which translate Lambda events in to HTTP requests.
+type LambdaHandlerServer interface {
+	Server
+	// LambdaHandler returns the `lambda.Handler` instance which translates Lambda events in to HTTP requests
+	// served by 'mux' and the resulting HTTP responses back in to Lambda responses.
+	LambdaHandler(mux http.Handler) lambda.Handler
+	// LambdaEventTypes returns the list of event types (one of the LAMBDA_EVENT_* constants) the server can handle.
+	LambdaEventTypes() []string
+}
+
+// NewLambdaEvent returns a new Lambda event of type 'event_type' (one of the LAMBDA_EVENT_* constants) derived from 'req'.
+func NewLambdaEvent(req *http.Request, event_type string) (any, error) {
+
+	switch event_type {
+	case LAMBDA_EVENT_APIGATEWAY_V1:
+		return NewAPIGatewayV1Request(req)
+	case LAMBDA_EVENT_APIGATEWAY_V2:
+		return NewAPIGatewayV2Request(req)
+	case LAMBDA_EVENT_ALB:
+		return NewALBRequest(req)
+	case LAMBDA_EVENT_FUNCTIONURL:
+		return NewFunctionURLRequest(req)
+	default:
+		return nil, fmt.Errorf("Invalid event type, %s", event_type)
+	}
+}
+
+// NewAPIGatewayV1Request returns a new API Gateway REST API (v1) event derived from 'req'.
+func NewAPIGatewayV1Request(req *http.Request) (events.APIGatewayProxyRequest, error) {
+
+	var event events.APIGatewayProxyRequest
+
+	body, is_base64, err := readLambdaEventBody(req)
+
+	if err != nil {
+		return event, err
+	}
+
+	headers, multi_value_headers := newLambdaEventHeaders(req, false)
+
+	query := req.URL.Query()
+	params := make(map[string]string)
+
+	for k, v := range query {
+		params[k] = v[len(v)-1]
+	}
+
+	source_ip := lambdaEventSourceIP(req)
+
+	event = events.APIGatewayProxyRequest{
+		Resource:                        "/{proxy+}",
+		Path:                            req.URL.Path,
+		HTTPMethod:                      req.Method,
+		Headers:                         headers,
+		MultiValueHeaders:               multi_value_headers,
+		QueryStringParameters:           params,
+		MultiValueQueryStringParameters: query,
+		PathParameters: map[string]string{
+			"proxy": strings.TrimPrefix(req.URL.Path, "/"),
+		},
+		RequestContext: events.APIGatewayProxyRequestContext{
+			AccountID:        LAMBDA_EMULATOR_ACCOUNT_ID,
+			ResourceID:       "emulator",
+			Stage:            "local",
+			DomainName:       lambdaEventDomainName(req),
+			RequestID:        newLambdaEventRequestID(),
+			Protocol:         req.Proto,
+			ResourcePath:     "/{proxy+}",
+			Path:             req.URL.Path,
+			HTTPMethod:       req.Method,
+			APIID:            "emulator",
+			RequestTimeEpoch: time.Now().UnixMilli(),
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  source_ip,
+				UserAgent: req.UserAgent(),
+			},
+		},
+		Body:            body,
+		IsBase64Encoded: is_base64,
+	}
+
+	return event, nil
+}
+
+// NewAPIGatewayV2Request returns a new API Gateway HTTP API (v2) event derived from 'req'.
+func NewAPIGatewayV2Request(req *http.Request) (events.APIGatewayV2HTTPRequest, error) {
+
+	var event events.APIGatewayV2HTTPRequest
+
+	body, is_base64, err := readLambdaEventBody(req)
+
+	if err != nil {
+		return event, err
+	}
+
+	headers, _ := newLambdaEventHeaders(req, true)
+
+	now := time.Now()
+
+	event = events.APIGatewayV2HTTPRequest{
+		Version:               "2.0",
+		RouteKey:              "$default",
+		RawPath:               req.URL.EscapedPath(),
+		RawQueryString:        req.URL.RawQuery,
+		Cookies:               lambdaEventCookies(req),
+		Headers:               headers,
+		QueryStringParameters: newLambdaEventQueryParameters(req),
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			RouteKey:     "$default",
+			AccountID:    LAMBDA_EMULATOR_ACCOUNT_ID,
+			Stage:        "$default",
+			RequestID:    newLambdaEventRequestID(),
+			APIID:        "emulator",
+			DomainName:   lambdaEventDomainName(req),
+			DomainPrefix: strings.Split(lambdaEventDomainName(req), ".")[0],
+			Time:         now.UTC().Format("02/Jan/2006:15:04:05 -0700"),
+			TimeEpoch:    now.UnixMilli(),
+			HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+				Method:    req.Method,
+				Path:      req.URL.Path,
+				Protocol:  req.Proto,
+				SourceIP:  lambdaEventSourceIP(req),
+				UserAgent: req.UserAgent(),
+			},
+		},
+		Body:            body,
+		IsBase64Encoded: is_base64,
+	}
+
+	return event, nil
+}
+
+// NewALBRequest returns a new Application Load Balancer event, with multi-value headers enabled, derived from 'req'.
+func NewALBRequest(req *http.Request) (events.ALBTargetGroupRequest, error) {
+
+	var event events.ALBTargetGroupRequest
+
+	body, is_base64, err := readLambdaEventBody(req)
+
+	if err != nil {
+		return event, err
+	}
+
+	_, multi_value_headers := newLambdaEventHeaders(req, false)
+
+	if source_ip := lambdaEventSourceIP(req); source_ip != "" && len(multi_value_headers["x-forwarded-for"]) == 0 {
+		multi_value_headers["x-forwarded-for"] = []string{source_ip}
+	}
+
+	// ALB does not decode query parameters
+
+	query := make(map[string][]string)
+
+	for _, part := range strings.Split(req.URL.RawQuery, "&") {
+
+		if part == "" {
+			continue
+		}
+
+		k, v, _ := strings.Cut(part, "=")
+		query[k] = append(query[k], v)
+	}
+
+	event = events.ALBTargetGroupRequest{
+		HTTPMethod:                      req.Method,
+		Path:                            req.URL.Path,
+		MultiValueQueryStringParameters: query,
+		MultiValueHeaders:               multi_value_headers,
+		RequestContext: events.ALBTargetGroupRequestContext{
+			ELB: events.ELBContext{
+				TargetGroupArn: LAMBDA_EMULATOR_TARGET_GROUP_ARN,
+			},
+		},
+		Body:            body,
+		IsBase64Encoded: is_base64,
+	}
+
+	return event, nil
+}
+
+// NewFunctionURLRequest returns a new Lambda Function URL event derived from 'req'.
+func NewFunctionURLRequest(req *http.Request) (events.LambdaFunctionURLRequest, error) {
+
+	var event events.LambdaFunctionURLRequest
+
+	body, is_base64, err := readLambdaEventBody(req)
+
+	if err != nil {
+		return event, err
+	}
+
+	headers, _ := newLambdaEventHeaders(req, true)
+
+	now := time.Now()
+
+	event = events.LambdaFunctionURLRequest{
+		Version:               "2.0",
+		RawPath:               req.URL.EscapedPath(),
+		RawQueryString:        req.URL.RawQuery,
+		Cookies:               lambdaEventCookies(req),
+		Headers:               headers,
+		QueryStringParameters: newLambdaEventQueryParameters(req),
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			AccountID:    LAMBDA_EMULATOR_ACCOUNT_ID,
+			RequestID:    newLambdaEventRequestID(),
+			APIID:        "emulator",
+			DomainName:   lambdaEventDomainName(req),
+			DomainPrefix: strings.Split(lambdaEventDomainName(req), ".")[0],
+			Time:         now.UTC().Format("02/Jan/2006:15:04:05 -0700"),
+			TimeEpoch:    now.UnixMilli(),
+			HTTP: events.LambdaFunctionURLRequestContextHTTPDescription{
+				Method:    req.Method,
+				Path:      req.URL.Path,
+				Protocol:  req.Proto,
+				SourceIP:  lambdaEventSourceIP(req),
+				UserAgent: req.UserAgent(),
+			},
+		},
+		Body:            body,
+		IsBase64Encoded: is_base64,
+	}
+
+	return event, nil
+}
+
+// NewLambdaHTTPResponse returns a new `http.Response` instance derived from 'payload' which is expected to be the
+// response of a Lambda handler for an API Gateway v1, v2, ALB or Function URL event. Both buffered responses and
+// (Function URL) streaming responses are supported.
+func NewLambdaHTTPResponse(payload []byte) (*http.Response, error) {
+
+	rsp, body, err := parseLambdaResponse(payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	status := rsp.StatusCode
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	header := rsp.header()
+
+	for _, c := range rsp.Cookies {
+		header.Add("Set-Cookie", c)
+	}
+
+	http_rsp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+
+	return http_rsp, nil
+}
+
+// parseLambdaResponse parses 'payload', which is expected to be a buffered or streaming Lambda response for an
+// API Gateway v1, v2, ALB or Function URL event, returning the response and its decoded body.
+func parseLambdaResponse(payload []byte) (*lambdaResponse, []byte, error) {
+
+	var rsp lambdaResponse
+
+	err := json.Unmarshal(payload, &rsp)
+
+	if err == nil {
+
+		body := []byte(rsp.Body)
+
+		if rsp.IsBase64Encoded {
+
+			body, err = base64.StdEncoding.DecodeString(rsp.Body)
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("Failed to decode response body, %w", err)
+			}
+		}
+
+		return &rsp, body, nil
+	}
+
+	// Streaming responses are a JSON prelude followed by 8 null bytes and then the body
+
+	prelude, body, ok := bytes.Cut(payload, make([]byte, 8))
+
+	if !ok {
+		return nil, nil, fmt.Errorf("Failed to unmarshal response, %w", err)
+	}
+
+	err = json.Unmarshal(prelude, &rsp)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to unmarshal streaming response prelude, %w", err)
+	}
+
+	return &rsp, body, nil
+}
+
+// readLambdaEventBody reads the body of 'req' returning it as a string, base64-encoded if it is not
+// valid UTF-8 or has a non-text content type, and whether or not it was base64-encoded.
+func readLambdaEventBody(req *http.Request) (string, bool, error) {
+
+	if req.Body == nil {
+		return "", false, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+
+	if err != nil {
+		return "", false, fmt.Errorf("Failed to read request body, %w", err)
+	}
+
+	if len(body) == 0 {
+		return "", false, nil
+	}
+
+	mt := parseMediaType(req.Header.Get("Content-Type"))
+
+	if !utf8.Valid(body) || (mt != "" && !isTextMediaType(mt)) {
+		return base64.StdEncoding.EncodeToString(body), true, nil
+	}
+
+	return string(body), false, nil
+}
+
+// newLambdaEventHeaders returns the single and multi-value headers of 'req', including "Host", with lower-cased keys.
+// If 'exclude_cookies' is true the "Cookie" header is omitted.
+func newLambdaEventHeaders(req *http.Request, exclude_cookies bool) (map[string]string, map[string][]string) {
+
+	headers := make(map[string]string)
+	multi_value_headers := make(map[string][]string)
+
+	for k, v := range req.Header {
+
+		if exclude_cookies && k == "Cookie" {
+			continue
+		}
+
+		k = strings.ToLower(k)
+
+		headers[k] = strings.Join(v, ",")
+		multi_value_headers[k] = v
+	}
+
+	if req.Host != "" {
+		headers["host"] = req.Host
+		multi_value_headers["host"] = []string{req.Host}
+	}
+
+	return headers, multi_value_headers
+}
+
+// newLambdaEventQueryParameters returns the query parameters of 'req' with multiple values joined by a comma.
+func newLambdaEventQueryParameters(req *http.Request) map[string]string {
+
+	query := req.URL.Query()
+
+	if len(query) == 0 {
+		return nil
+	}
+
+	params := make(map[string]string)
+
+	for k, v := range query {
+		params[k] = strings.Join(v, ",")
+	}
+
+	return params
+}
+
+// lambdaEventCookies returns the individual cookies in the "Cookie" headers of 'req'.
+func lambdaEventCookies(req *http.Request) []string {
+
+	var cookies []string
+
+	for _, v := range req.Header.Values("Cookie") {
+
+		for _, c := range strings.Split(v, ";") {
+
+			c = strings.TrimSpace(c)
+
+			if c != "" {
+				cookies = append(cookies, c)
+			}
+		}
+	}
+
+	return cookies
+}
+
+// lambdaEventSourceIP returns the IP address of the client that sent 'req'.
+func lambdaEventSourceIP(req *http.Request) string {
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// lambdaEventDomainName returns the host name, without a port, that 'req' was sent to.
+func lambdaEventDomainName(req *http.Request) string {
+
+	host, _, err := net.SplitHostPort(req.Host)
+
+	if err != nil {
+		return req.Host
+	}
+
+	return host
+}
+
+// newLambdaEventRequestID returns a new random request ID.
+func newLambdaEventRequestID() string {
+
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	id := hex.EncodeToString(b)
+	return strings.Join([]string{id[0:8], id[8:12], id[12:16], id[16:20], id[20:]}, "-")
+}
+
+// writeLambdaHTTPResponse writes 'rsp' to 'wr'.
+func writeLambdaHTTPResponse(wr http.ResponseWriter, rsp *http.Response) error {
+
+	for k, v := range rsp.Header {
+		wr.Header()[k] = v
+	}
+
+	if rsp.Header.Get("Content-Length") == "" {
+		wr.Header().Set("Content-Length", strconv.FormatInt(rsp.ContentLength, 10))
+	}
+
+	wr.WriteHeader(rsp.StatusCode)
+
+	_, err := io.Copy(wr, rsp.Body)
+	return err
+}
@@ -3,10 +3,14 @@ package server
 // https://github.com/aws/aws-lambda-go/blob/main/events/README_Lambda.md
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -24,17 +28,45 @@ func init() {
 // LambdaFunctionURLServer implements the `Server` interface for a use in a AWS LambdaFunctionURL + API Gateway context.
 type LambdaFunctionURLServer struct {
 	Server
-	handler            http.Handler
-	binaryContentTypes map[string]bool
+	handler     http.Handler
+	encoder     *lambdaResponseEncoder
+	invoke_mode string
+	warmer      *lambdaWarmer
+	path_prefix *lambdaPathPrefix
+	logger      *slog.Logger
 }
 
+// The default (buffered) invoke mode for Lambda Function URLs.
+const FUNCTIONURL_INVOKE_MODE_BUFFERED string = "buffered"
+
+// The response streaming invoke mode for Lambda Function URLs.
+const FUNCTIONURL_INVOKE_MODE_RESPONSE_STREAM string = "response_stream"
+
 // NewLambdaFunctionURLServer returns a new `LambdaFunctionURLServer` instance configured by 'uri' which is
 // expected to be defined in the form of:
 //
 //	functionurl://?{PARAMETERS}
 //
 // Valid parameters are:
-// * `binary_type={MIMETYPE}` One or more mimetypes to be served by AWS FunctionURLs as binary content types.
+//   - `binary_type={MIMETYPE}` One or more mimetypes to be served by AWS FunctionURLs as binary content types. Parameters
+//     are ignored when matching and mimetypes may be wildcards in the form of "{TYPE}/*" or "*/*".
+//   - `binary_auto={BOOLEAN}` If true any response body that isn't valid UTF-8 or has a non-text content type will be served
+//     as binary content.
+//   - `compress={BOOLEAN}` If true textual response bodies are compressed using "br" or "gzip" when the client's "Accept-Encoding" header
+//     allows it. Default is false.
+//   - `max_payload_size={BYTES}` The maximum size of a request or response payload. Larger requests are rejected with a 413 Request Entity
+//     Too Large error and larger responses are replaced by a 502 Bad Gateway error. Default is 6MB.
+//   - `invoke_mode={MODE}` The invoke mode of the Function URL. Valid options are "buffered" and "response_stream". In "response_stream"
+//     mode responses are sent to the client as they are written, handlers may use `http.Flusher` and responses are not
+//     limited to 6MB; response bodies are never base64-encoded or compressed so `binary_type`, `binary_auto`, `compress` and
+//     `max_payload_size` are ignored. Response streaming requires compiling
+//     with `-tags lambda.norpc` or using the `provided.al2` or `provided.al2023` runtimes. Default is "buffered".
+//   - `warmer={BOOLEAN}` If true warm-up pings (events with a "warmer" property set to true, "serverless-plugin-warmup" events
+//     and scheduled events) are answered without serving a request. If the handler implements the `Warmer` interface its `Warm`
+//     method is invoked, as are those of any `Warmer` instances registered using `WithWarmers`. Default is false.
+//   - `strip_prefix={PATH}` A path prefix to remove from request paths, if present, before they are routed. The prefix is available
+//     using `PublicPrefixFromContext`. Function URLs don't have stages so, unlike `lambda://` servers, the `stage_prefix` parameter
+//     is not supported and returns an error.
 func NewLambdaFunctionURLServer(ctx context.Context, uri string) (Server, error) {
 
 	u, err := url.Parse(uri)
@@ -45,14 +77,52 @@ func NewLambdaFunctionURLServer(ctx context.Context, uri string) (Server, error)
 
 	q := u.Query()
 
-	binary_types := make(map[string]bool)
+	encoder, err := newLambdaResponseEncoder(q)
+
+	if err != nil {
+		return nil, err
+	}
+
+	invoke_mode := FUNCTIONURL_INVOKE_MODE_BUFFERED
+
+	if q.Has("invoke_mode") {
 
-	for _, t := range q["binary_type"] {
-		binary_types[t] = true
+		invoke_mode = strings.ToLower(q.Get("invoke_mode"))
+
+		switch invoke_mode {
+		case FUNCTIONURL_INVOKE_MODE_BUFFERED, FUNCTIONURL_INVOKE_MODE_RESPONSE_STREAM:
+			// pass
+		default:
+			return nil, fmt.Errorf("Invalid invoke_mode parameter, %s", invoke_mode)
+		}
+	}
+
+	// Function URLs don't have stages so the stage_prefix parameter would silently do nothing
+
+	if q.Has("stage_prefix") {
+		return nil, fmt.Errorf("Invalid stage_prefix parameter, %s (not supported by functionurl:// servers)", q.Get("stage_prefix"))
+	}
+
+	path_prefix, err := newLambdaPathPrefix(q)
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger := LoggerFromContext(ctx)
+
+	warmer, err := newLambdaWarmer(ctx, q, logger)
+
+	if err != nil {
+		return nil, err
 	}
 
 	server := LambdaFunctionURLServer{
-		binaryContentTypes: binary_types,
+		encoder:     encoder,
+		invoke_mode: invoke_mode,
+		warmer:      warmer,
+		path_prefix: path_prefix,
+		logger:      logger,
 	}
 
 	return &server, nil
@@ -65,16 +135,101 @@ func (s *LambdaFunctionURLServer) Address() string {
 
 // ListenAndServe starts the serve and listens for requests using 'mux' for routing.
 func (s *LambdaFunctionURLServer) ListenAndServe(ctx context.Context, mux http.Handler) error {
-	s.handler = mux
-	lambda.Start(s.handleRequest)
+	s.setHandler(mux)
+
+	lambda_ctx := WithLogger(ctx, s.logger)
+
+	lambda.StartWithOptions(s.lambdaHandlerFunc(mux), lambda.WithContext(lambda_ctx))
 	return nil
 }
 
+// LambdaHandler returns the `lambda.Handler` instance used by 's' to translate Function URL events in to HTTP requests
+// served by 'mux' and the resulting HTTP responses back in to Lambda responses. In "response_stream" mode the response
+// payload is the streaming response prelude followed by the entire response body.
+func (s *LambdaFunctionURLServer) LambdaHandler(mux http.Handler) lambda.Handler {
+	s.setHandler(mux)
+	return lambda.NewHandler(s.lambdaHandlerFunc(mux))
+}
+
+// setHandler assigns 'mux' as the handler used to serve requests, rewriting request paths first if 's' was
+// configured with the `strip_prefix` parameter.
+func (s *LambdaFunctionURLServer) setHandler(mux http.Handler) {
+
+	if s.path_prefix != nil {
+		mux = s.path_prefix.handler(mux)
+	}
+
+	s.handler = mux
+}
+
+// lambdaHandlerFunc returns the Lambda handler function for the invoke mode of 's'. If 's' was configured with
+// the `warmer` parameter warm-up pings are handled by the function itself rather than being served by 'mux'. Functions
+// queued with `AfterResponse` are run after the response has been posted to the Lambda Runtime API.
+func (s *LambdaFunctionURLServer) lambdaHandlerFunc(mux http.Handler) any {
+
+	switch s.invoke_mode {
+	case FUNCTIONURL_INVOKE_MODE_RESPONSE_STREAM:
+
+		if s.warmer == nil {
+			return withAfterResponse(s.handleStreamingRequest, s.logger)
+		}
+
+		warm_rsp := func() *events.LambdaFunctionURLStreamingResponse {
+			return &events.LambdaFunctionURLStreamingResponse{
+				StatusCode: http.StatusOK,
+				Body:       strings.NewReader(""),
+			}
+		}
+
+		return withAfterResponse(withFunctionURLWarmer(s.warmer, mux, s.handleStreamingRequest, warm_rsp), s.logger)
+
+	default:
+
+		if s.warmer == nil {
+			return withAfterResponse(s.handleRequest, s.logger)
+		}
+
+		warm_rsp := func() events.LambdaFunctionURLResponse {
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusOK,
+			}
+		}
+
+		return withAfterResponse(withFunctionURLWarmer(s.warmer, mux, s.handleRequest, warm_rsp), s.logger)
+	}
+}
+
+// LambdaEventTypes returns the list of event types that 's' can handle.
+func (s *LambdaFunctionURLServer) LambdaEventTypes() []string {
+	return []string{
+		LAMBDA_EVENT_FUNCTIONURL,
+	}
+}
+
 func (s *LambdaFunctionURLServer) handleRequest(ctx context.Context, request events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
 
+	// The event has already been decoded so its body, which accounts for almost all of the payload, stands in for its size
+
+	if len(request.Body) > s.encoder.max_payload_size {
+
+		s.logger.Error("Request payload exceeds maximum size", "method", request.RequestContext.HTTP.Method, "path", request.RawPath, "size", len(request.Body), "max_payload_size", s.encoder.max_payload_size)
+
+		status, header, body := s.encoder.oversizeRequest(len(request.Body))
+		headers, _ := newFunctionURLResponseHeaders(header)
+
+		event_rsp := events.LambdaFunctionURLResponse{
+			StatusCode: status,
+			Headers:    headers,
+			Body:       body,
+		}
+
+		return event_rsp, nil
+	}
+
 	req, err := newHTTPRequest(ctx, request)
 
 	if err != nil {
+		s.logger.Error("Failed to create HTTP request from event", "path", request.RawPath, "error", err)
 		return events.LambdaFunctionURLResponse{Body: err.Error(), StatusCode: 500}, nil
 	}
 
@@ -83,29 +238,68 @@ func (s *LambdaFunctionURLServer) handleRequest(ctx context.Context, request eve
 
 	rsp := rec.Result()
 
-	event_rsp_headers := make(map[string]string)
+	body, is_base64, err := s.encoder.encodeBody(req.Header.Get("Accept-Encoding"), rsp.Header, rec.Body.Bytes())
 
-	for k, v := range rsp.Header {
-		event_rsp_headers[k] = strings.Join(v, ",")
+	if err != nil {
+		s.logger.Error("Failed to encode response body", "method", req.Method, "path", req.URL.Path, "error", err)
+		return events.LambdaFunctionURLResponse{Body: "Internal server error", StatusCode: 500}, nil
 	}
 
+	event_rsp_headers, event_rsp_cookies := newFunctionURLResponseHeaders(rsp.Header)
+
 	event_rsp := events.LambdaFunctionURLResponse{
-		StatusCode: rsp.StatusCode,
-		Headers:    event_rsp_headers,
+		StatusCode:      rsp.StatusCode,
+		Headers:         event_rsp_headers,
+		Cookies:         event_rsp_cookies,
+		Body:            body,
+		IsBase64Encoded: is_base64,
+	}
+
+	enc_rsp, err := json.Marshal(event_rsp)
+
+	if err != nil {
+		s.logger.Error("Failed to marshal response", "method", req.Method, "path", req.URL.Path, "error", err)
+		return events.LambdaFunctionURLResponse{Body: "Internal server error", StatusCode: 500}, nil
 	}
 
-	content_type := rsp.Header.Get("Content-Type")
+	if len(enc_rsp) > s.encoder.max_payload_size {
+
+		s.logger.Error("Response payload exceeds maximum size", "method", req.Method, "path", req.URL.Path, "status", rsp.StatusCode, "size", len(enc_rsp), "max_payload_size", s.encoder.max_payload_size)
+
+		status, header, body := s.encoder.oversizeResponse(len(enc_rsp))
+		headers, _ := newFunctionURLResponseHeaders(header)
 
-	if s.binaryContentTypes[content_type] {
-		event_rsp.Body = base64.StdEncoding.EncodeToString(rec.Body.Bytes())
-		event_rsp.IsBase64Encoded = true
-	} else {
-		event_rsp.Body = rec.Body.String()
+		event_rsp = events.LambdaFunctionURLResponse{
+			StatusCode: status,
+			Headers:    headers,
+			Body:       body,
+		}
 	}
 
 	return event_rsp, nil
 }
 
+// newFunctionURLResponseHeaders converts 'header' in to the headers and cookies of a Lambda Function URL response.
+// Function URL responses do not support multi-value headers so, with the exception of "Set-Cookie" headers which
+// are returned as cookies, multiple values are joined with a comma.
+func newFunctionURLResponseHeaders(header http.Header) (map[string]string, []string) {
+
+	headers := make(map[string]string)
+	var cookies []string
+
+	for k, v := range header {
+
+		if k == "Set-Cookie" {
+			cookies = v
+			continue
+		}
+
+		headers[k] = strings.Join(v, ",")
+	}
+
+	return headers, cookies
+}
+
 // This was clone and modified as necessary from https://github.com/akrylysov/algnhsa/blob/master/request.go#L30
 // so there may still be issues.
 
@@ -120,10 +314,17 @@ func newHTTPRequest(ctx context.Context, event events.LambdaFunctionURLRequest)
 
 	if len(rawQuery) == 0 {
 
+		// Function URLs combine query parameters with multiple values in to a single comma-separated
+		// value. This is ambiguous for values that contain commas which is why RawQueryString, which
+		// Function URLs always populate, is preferred.
+
 		params := url.Values{}
 
 		for k, v := range event.QueryStringParameters {
-			params.Set(k, v)
+
+			for _, part := range strings.Split(v, ",") {
+				params.Add(k, part)
+			}
 		}
 
 		rawQuery = params.Encode()
@@ -132,7 +333,20 @@ func newHTTPRequest(ctx context.Context, event events.LambdaFunctionURLRequest)
 	headers := make(http.Header)
 
 	for k, v := range event.Headers {
-		headers.Set(k, v)
+		headers.Add(k, v)
+	}
+
+	// Function URLs move "Cookie" headers in to the Cookies property of the event
+
+	if len(event.Cookies) > 0 {
+
+		cookies := event.Cookies
+
+		if headers.Get("Cookie") != "" {
+			cookies = append([]string{headers.Get("Cookie")}, cookies...)
+		}
+
+		headers.Set("Cookie", strings.Join(cookies, "; "))
 	}
 
 	unescapedPath, err := url.PathUnescape(event.RawPath)
@@ -140,29 +354,97 @@ func newHTTPRequest(ctx context.Context, event events.LambdaFunctionURLRequest)
 	if err != nil {
 		return nil, err
 	}
+
+	req_context := event.RequestContext
+
+	// Function URLs are only ever served over HTTPS, on port 443, and Lambda doesn't set the X-Forwarded-Proto or
+	// X-Forwarded-Port headers so any values in the event were sent by the client and must not be trusted.
+
+	scheme := "https"
+
+	headers.Set("X-Forwarded-Proto", scheme)
+	headers.Set("X-Forwarded-Port", "443")
+
+	// Events that weren't sent by a Function URL, for example those written by hand, may not have a domain name
+
+	host := req_context.DomainName
+
+	if host == "" {
+		host = headers.Get("Host")
+	}
+
 	u := url.URL{
-		Host:     headers.Get("Host"),
+		Scheme:   scheme,
+		Host:     host,
 		Path:     unescapedPath,
+		RawPath:  event.RawPath,
 		RawQuery: rawQuery,
 	}
 
-	// Handle base64 encoded body.
+	// Handle base64 encoded body. Decode it up front so that the request has a known content length.
 
-	var body io.Reader = strings.NewReader(event.Body)
+	body := []byte(event.Body)
 
 	if event.IsBase64Encoded {
-		body = base64.NewDecoder(base64.StdEncoding, body)
+
+		decoded, err := base64.StdEncoding.DecodeString(event.Body)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode request body, %w", err)
+		}
+
+		body = decoded
 	}
 
-	req_context := event.RequestContext
+	ctx, err = withLambdaEvent(ctx, event)
 
-	r, err := http.NewRequestWithContext(ctx, req_context.HTTP.Method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive Lambda identity, %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, req_context.HTTP.Method, u.String(), bytes.NewReader(body))
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create new HTTP request, %w", err)
 	}
 
-	r.RemoteAddr = req_context.HTTP.SourceIP
+	proto := req_context.HTTP.Protocol
+
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+
+	major, minor, ok := http.ParseHTTPVersion(proto)
+
+	if ok {
+		r.Proto = proto
+		r.ProtoMajor = major
+		r.ProtoMinor = minor
+	}
+
+	r.TLS = &tls.ConnectionState{
+		HandshakeComplete: true,
+		ServerName:        host,
+	}
+
+	// Like net/http the Host header is removed and its value assigned to the request's Host property
+
+	headers.Del("Host")
+	r.Host = host
+
+	// The client's port is not included in Function URL events but handlers (and net/http) expect RemoteAddr
+	// to be in the form of "{IP}:{PORT}" so use port 0.
+
+	source_ip := req_context.HTTP.SourceIP
+
+	if source_ip == "" {
+		source_ip = strings.TrimSpace(strings.Split(headers.Get("X-Forwarded-For"), ",")[0])
+	}
+
+	if source_ip != "" {
+		r.RemoteAddr = net.JoinHostPort(source_ip, "0")
+	}
+
 	r.RequestURI = u.RequestURI()
 
 	r.Header = headers
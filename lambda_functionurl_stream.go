@@ -0,0 +1,158 @@
+package server
+
+// https://docs.aws.amazon.com/lambda/latest/dg/configuration-response-streaming.html
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// The size of the buffer used to accumulate writes before they are sent to the Lambda runtime.
+const streamingBufferSize int = 4096
+
+func (s *LambdaFunctionURLServer) handleStreamingRequest(ctx context.Context, request events.LambdaFunctionURLRequest) (*events.LambdaFunctionURLStreamingResponse, error) {
+
+	req, err := newHTTPRequest(ctx, request)
+
+	if err != nil {
+		s.logger.Error("Failed to create HTTP request from event", "path", request.RawPath, "error", err)
+		return nil, fmt.Errorf("Failed to create HTTP request from event, %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	rsp := newStreamingResponseWriter(pw)
+
+	go func() {
+
+		defer func() {
+
+			if r := recover(); r != nil {
+
+				s.logger.Error("Handler panicked", "path", req.URL.Path, "error", r)
+
+				if !rsp.wroteHeader() {
+					http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+				}
+
+				rsp.close(fmt.Errorf("Handler panicked, %v", r))
+				return
+			}
+
+			rsp.close(nil)
+		}()
+
+		s.handler.ServeHTTP(rsp, req)
+	}()
+
+	// Wait for the handler to write the response headers, or return
+
+	<-rsp.ready
+
+	headers, cookies := newFunctionURLResponseHeaders(rsp.sent_header)
+
+	event_rsp := &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: rsp.status,
+		Headers:    headers,
+		Cookies:    cookies,
+		Body:       pr,
+	}
+
+	return event_rsp, nil
+}
+
+// streamingResponseWriter implements the `http.ResponseWriter` and `http.Flusher` interfaces writing the
+// response body to an `io.Pipe` which is read by the Lambda runtime as the handler writes to it.
+type streamingResponseWriter struct {
+	header      http.Header
+	sent_header http.Header
+	status      int
+	pipe        *io.PipeWriter
+	buf         *bufio.Writer
+	ready       chan struct{}
+	ready_once  sync.Once
+}
+
+func newStreamingResponseWriter(pw *io.PipeWriter) *streamingResponseWriter {
+
+	w := &streamingResponseWriter{
+		header: make(http.Header),
+		pipe:   pw,
+		buf:    bufio.NewWriterSize(pw, streamingBufferSize),
+		ready:  make(chan struct{}),
+	}
+
+	return w
+}
+
+func (w *streamingResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader records the status code and a snapshot of the response headers and signals that the response
+// can start being sent. Like `net/http` only the first call has any effect.
+func (w *streamingResponseWriter) WriteHeader(status int) {
+
+	w.ready_once.Do(func() {
+		w.status = status
+		w.sent_header = w.header.Clone()
+		close(w.ready)
+	})
+}
+
+func (w *streamingResponseWriter) Write(b []byte) (int, error) {
+
+	if !w.wroteHeader() {
+
+		// Like net/http sniff the content type if it has not been set
+
+		if w.header.Get("Content-Type") == "" && w.header.Get("Transfer-Encoding") == "" {
+			w.header.Set("Content-Type", http.DetectContentType(b))
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.buf.Write(b)
+}
+
+// Flush sends any buffered data to the Lambda runtime.
+func (w *streamingResponseWriter) Flush() {
+
+	if !w.wroteHeader() {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	w.buf.Flush()
+}
+
+func (w *streamingResponseWriter) wroteHeader() bool {
+
+	select {
+	case <-w.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// close flushes any buffered data and closes the pipe, with 'err' if not nil.
+func (w *streamingResponseWriter) close(err error) {
+
+	if !w.wroteHeader() {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	flush_err := w.buf.Flush()
+
+	if err == nil {
+		err = flush_err
+	}
+
+	w.pipe.CloseWithError(err)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestLambdaFunctionURLServerStreaming(t *testing.T) {
+
+	ctx := context.Background()
+
+	_, err := NewServer(ctx, "functionurl://?invoke_mode=invalid")
+
+	if err == nil {
+		t.Fatalf("Expected invalid invoke mode to fail")
+	}
+
+	s, err := NewServer(ctx, "functionurl://?invoke_mode=response_stream")
+
+	if err != nil {
+		t.Fatalf("Failed to create new server, %v", err)
+	}
+
+	next_ch := make(chan struct{})
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		rsp.Header().Set("Content-Type", "text/event-stream")
+		http.SetCookie(rsp, &http.Cookie{Name: "a", Value: "1"})
+
+		rsp.Write([]byte("data: first\n\n"))
+		rsp.(http.Flusher).Flush()
+
+		<-next_ch
+
+		rsp.Write([]byte("data: second\n\n"))
+	}
+
+	fu_s := s.(*LambdaFunctionURLServer)
+	fu_s.handler = http.HandlerFunc(handler)
+
+	event := events.LambdaFunctionURLRequest{
+		RawPath: "/events",
+	}
+
+	event.RequestContext.HTTP.Method = http.MethodGet
+
+	rsp, err := fu_s.handleStreamingRequest(ctx, event)
+
+	if err != nil {
+		t.Fatalf("Failed to handle request, %v", err)
+	}
+
+	defer rsp.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", rsp.StatusCode)
+	}
+
+	if rsp.Headers["Content-Type"] != "text/event-stream" {
+		t.Fatalf("Unexpected content type: %s", rsp.Headers["Content-Type"])
+	}
+
+	if len(rsp.Cookies) != 1 || rsp.Cookies[0] != "a=1" {
+		t.Fatalf("Unexpected cookies: %v", rsp.Cookies)
+	}
+
+	first := make([]byte, len("data: first\n\n"))
+
+	read_ch := make(chan error)
+
+	go func() {
+		_, err := io.ReadFull(rsp.Body, first)
+		read_ch <- err
+	}()
+
+	select {
+	case err := <-read_ch:
+
+		if err != nil {
+			t.Fatalf("Failed to read first event, %v", err)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for first event; response was not streamed")
+	}
+
+	if string(first) != "data: first\n\n" {
+		t.Fatalf("Unexpected first event: '%s'", string(first))
+	}
+
+	close(next_ch)
+
+	rest, err := io.ReadAll(rsp.Body)
+
+	if err != nil {
+		t.Fatalf("Failed to read second event, %v", err)
+	}
+
+	if string(rest) != "data: second\n\n" {
+		t.Fatalf("Unexpected second event: '%s'", string(rest))
+	}
+}
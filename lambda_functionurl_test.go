@@ -2,7 +2,11 @@ package server
 
 import (
 	"context"
+	"net/http"
+	"strings"
 	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
 )
 
 func TestLambdaFunctionURLServer(t *testing.T) {
@@ -19,3 +23,172 @@ func TestLambdaFunctionURLServer(t *testing.T) {
 		t.Fatalf("Unexpected address: %s", s.Address())
 	}
 }
+
+func TestLambdaFunctionURLServerHeaders(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "functionurl://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new server, %v", err)
+	}
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		a, err := req.Cookie("a")
+
+		if err != nil || a.Value != "1" {
+			http.Error(rsp, "Missing cookie a", http.StatusBadRequest)
+			return
+		}
+
+		b, err := req.Cookie("b")
+
+		if err != nil || b.Value != "2" {
+			http.Error(rsp, "Missing cookie b", http.StatusBadRequest)
+			return
+		}
+
+		http.SetCookie(rsp, &http.Cookie{Name: "c", Value: "3"})
+		http.SetCookie(rsp, &http.Cookie{Name: "d", Value: "4"})
+
+		rsp.Header().Add("X-Multi", "one")
+		rsp.Header().Add("X-Multi", "two")
+
+		rsp.Write([]byte(strings.Join(req.URL.Query()["q"], "|")))
+	}
+
+	fu_s := s.(*LambdaFunctionURLServer)
+	fu_s.handler = http.HandlerFunc(handler)
+
+	tests := []events.LambdaFunctionURLRequest{
+		{
+			RawPath:        "/",
+			RawQueryString: "q=x&q=y",
+			Cookies:        []string{"a=1", "b=2"},
+		},
+		{
+			RawPath:               "/",
+			QueryStringParameters: map[string]string{"q": "x,y"},
+			Cookies:               []string{"a=1", "b=2"},
+		},
+	}
+
+	for idx, event := range tests {
+
+		event.RequestContext.HTTP.Method = http.MethodGet
+
+		rsp, err := fu_s.handleRequest(ctx, event)
+
+		if err != nil {
+			t.Fatalf("Failed to handle request %d, %v", idx, err)
+		}
+
+		if rsp.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected status code for request %d: %d (%s)", idx, rsp.StatusCode, rsp.Body)
+		}
+
+		if rsp.Body != "x|y" {
+			t.Fatalf("Unexpected query values for request %d: '%s'", idx, rsp.Body)
+		}
+
+		if len(rsp.Cookies) != 2 || rsp.Cookies[0] != "c=3" || rsp.Cookies[1] != "d=4" {
+			t.Fatalf("Unexpected cookies for request %d: %v", idx, rsp.Cookies)
+		}
+
+		_, exists := rsp.Headers["Set-Cookie"]
+
+		if exists {
+			t.Fatalf("Unexpected Set-Cookie header for request %d", idx)
+		}
+
+		if rsp.Headers["X-Multi"] != "one,two" {
+			t.Fatalf("Unexpected X-Multi header for request %d: '%s'", idx, rsp.Headers["X-Multi"])
+		}
+	}
+}
+
+func TestLambdaFunctionURLRequest(t *testing.T) {
+
+	ctx := context.Background()
+
+	event := events.LambdaFunctionURLRequest{
+		RawPath:        "/foo/bar%2Fbaz",
+		RawQueryString: "a=b",
+		Headers: map[string]string{
+			"host":            "abc123.lambda-url.us-east-1.on.aws",
+			"x-forwarded-for": "203.0.113.1",
+		},
+		Body:            "aGVsbG8=",
+		IsBase64Encoded: true,
+	}
+
+	event.RequestContext.DomainName = "abc123.lambda-url.us-east-1.on.aws"
+	event.RequestContext.HTTP.Method = http.MethodPost
+	event.RequestContext.HTTP.Protocol = "HTTP/1.1"
+	event.RequestContext.HTTP.SourceIP = "203.0.113.1"
+
+	req, err := newHTTPRequest(ctx, event)
+
+	if err != nil {
+		t.Fatalf("Failed to create request, %v", err)
+	}
+
+	expected_url := "https://abc123.lambda-url.us-east-1.on.aws/foo/bar%2Fbaz?a=b"
+
+	if req.URL.String() != expected_url {
+		t.Fatalf("Unexpected URL: %s", req.URL.String())
+	}
+
+	if req.URL.Path != "/foo/bar/baz" {
+		t.Fatalf("Unexpected path: %s", req.URL.Path)
+	}
+
+	if req.Host != "abc123.lambda-url.us-east-1.on.aws" {
+		t.Fatalf("Unexpected host: %s", req.Host)
+	}
+
+	if req.TLS == nil {
+		t.Fatalf("Expected TLS connection state")
+	}
+
+	if req.Header.Get("X-Forwarded-Proto") != "https" {
+		t.Fatalf("Unexpected X-Forwarded-Proto header: %s", req.Header.Get("X-Forwarded-Proto"))
+	}
+
+	if req.Proto != "HTTP/1.1" || req.ProtoMajor != 1 || req.ProtoMinor != 1 {
+		t.Fatalf("Unexpected protocol: %s", req.Proto)
+	}
+
+	if req.RemoteAddr != "203.0.113.1:0" {
+		t.Fatalf("Unexpected remote address: %s", req.RemoteAddr)
+	}
+
+	if req.RequestURI != "/foo/bar%2Fbaz?a=b" {
+		t.Fatalf("Unexpected request URI: %s", req.RequestURI)
+	}
+
+	if req.ContentLength != 5 {
+		t.Fatalf("Unexpected content length: %d", req.ContentLength)
+	}
+
+	// Forwarded headers sent by the client are overwritten
+
+	event.Headers["x-forwarded-proto"] = "http"
+	event.Headers["x-forwarded-port"] = "8080"
+
+	req, err = newHTTPRequest(ctx, event)
+
+	if err != nil {
+		t.Fatalf("Failed to create request, %v", err)
+	}
+
+	if req.URL.Scheme != "https" || req.TLS == nil || req.Host != "abc123.lambda-url.us-east-1.on.aws" {
+		t.Fatalf("Unexpected URL for request with forwarded headers: %s", req.URL.String())
+	}
+
+	if req.Header.Get("X-Forwarded-Proto") != "https" || req.Header.Get("X-Forwarded-Port") != "443" {
+		t.Fatalf("Expected forwarded headers to be overwritten: %v", req.Header)
+	}
+}
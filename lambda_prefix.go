@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Regular expression to match "{name}" and "{name+}" style path parameters in API Gateway resources.
+var re_resource_param = regexp.MustCompile(`\{[^\}]+\}`)
+
+// publicPrefixContextKey is the key used to store the public path prefix of a request in a `context.Context`.
+type publicPrefixContextKey struct{}
+
+// lambdaPathPrefix rewrites the paths of requests derived from Lambda events, removing API Gateway stages, custom
+// domain base path mappings and other prefixes before they are routed.
+type lambdaPathPrefix struct {
+	strip_prefix string
+	stage_auto   bool
+}
+
+// newLambdaPathPrefix returns a new `lambdaPathPrefix` instance configured by the `strip_prefix` and `stage_prefix`
+// parameters in 'q'. If neither parameter is present it returns nil.
+func newLambdaPathPrefix(q url.Values) (*lambdaPathPrefix, error) {
+
+	if !q.Has("strip_prefix") && !q.Has("stage_prefix") {
+		return nil, nil
+	}
+
+	p := &lambdaPathPrefix{}
+
+	if q.Has("strip_prefix") {
+
+		strip_prefix := strings.TrimRight(q.Get("strip_prefix"), "/")
+
+		if !strings.HasPrefix(strip_prefix, "/") {
+			return nil, fmt.Errorf("Invalid strip_prefix parameter, %s (must start with '/')", q.Get("strip_prefix"))
+		}
+
+		p.strip_prefix = strip_prefix
+	}
+
+	if q.Has("stage_prefix") {
+
+		switch q.Get("stage_prefix") {
+		case "auto":
+			p.stage_auto = true
+		default:
+			return nil, fmt.Errorf("Invalid stage_prefix parameter, %s (valid options are: auto)", q.Get("stage_prefix"))
+		}
+	}
+
+	return p, nil
+}
+
+// handler returns an `http.Handler` that rewrites the path of each request, storing the prefixes that were removed (or
+// that the client used but that are not present in the path) in the request context, before serving 'next'. It is
+// expected to be wrapped by a handler that stores the original Lambda event in the request context.
+func (p *lambdaPathPrefix) handler(next http.Handler) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		public_prefix := ""
+		strip := make([]string, 0)
+
+		if p.stage_auto {
+
+			ev, _ := LambdaEventFromContext(ctx)
+
+			switch ev := ev.(type) {
+			case events.APIGatewayProxyRequest:
+
+				resource_path := lambdaResourcePath(ev.Resource, ev.PathParameters)
+
+				if resource_path != "" && resource_path != ev.Path && strings.HasSuffix(ev.Path, resource_path) {
+
+					// Custom domain base path mappings are included in the event's path
+
+					prefix := strings.TrimRight(strings.TrimSuffix(ev.Path, resource_path), "/")
+
+					if prefix != "" && hasPathPrefix(req.URL.Path, prefix) {
+						public_prefix = prefix
+						strip = append(strip, prefix)
+					}
+
+				} else if req_path := ev.RequestContext.Path; req_path != "" && req_path != ev.Path && strings.HasSuffix(req_path, ev.Path) {
+
+					// Stages are omitted from the event's path but the request context's path is the path the
+					// client requested, including the stage
+
+					public_prefix = strings.TrimRight(strings.TrimSuffix(req_path, ev.Path), "/")
+				}
+
+			case events.APIGatewayV2HTTPRequest:
+
+				stage := ev.RequestContext.Stage
+
+				if stage != "" && stage != "$default" && hasPathPrefix(req.URL.Path, "/"+stage) {
+					public_prefix = "/" + stage
+					strip = append(strip, public_prefix)
+				}
+			}
+		}
+
+		if p.strip_prefix != "" {
+
+			req_path := req.URL.Path
+
+			if len(strip) > 0 {
+				req_path = trimPathPrefix(req_path, strip[0])
+			}
+
+			if hasPathPrefix(req_path, p.strip_prefix) {
+				public_prefix = public_prefix + p.strip_prefix
+				strip = append(strip, p.strip_prefix)
+			}
+		}
+
+		if public_prefix != "" {
+			ctx = context.WithValue(ctx, publicPrefixContextKey{}, public_prefix)
+		}
+
+		if len(strip) > 0 {
+			req = stripRequestPrefix(req.WithContext(ctx), strings.Join(strip, ""))
+		} else if public_prefix != "" {
+			req = req.WithContext(ctx)
+		}
+
+		next.ServeHTTP(rsp, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// PublicPrefixFromContext returns the path prefix that the client used to reach the request associated with 'ctx' but that was
+// removed, or was never present, in the request's path. For example, the API Gateway stage or custom domain base path mapping and
+// any prefix removed with the `strip_prefix` parameter of the `lambda://` and `functionurl://` servers. It returns an empty string
+// if there is no prefix.
+func PublicPrefixFromContext(ctx context.Context) string {
+	prefix, _ := ctx.Value(publicPrefixContextKey{}).(string)
+	return prefix
+}
+
+// PublicPath returns 'p', a path as seen by the `http.Handler`, with the public prefix of the request associated with 'ctx'
+// prepended, suitable for use in links sent to the client.
+func PublicPath(ctx context.Context, p string) string {
+
+	prefix := PublicPrefixFromContext(ctx)
+
+	if prefix == "" {
+		return p
+	}
+
+	public_path := path.Join(prefix, p)
+
+	if strings.HasSuffix(p, "/") && !strings.HasSuffix(public_path, "/") {
+		public_path = public_path + "/"
+	}
+
+	return public_path
+}
+
+// hasPathPrefix returns true if 'p' is equal to 'prefix' or starts with 'prefix' followed by a "/".
+func hasPathPrefix(p string, prefix string) bool {
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// trimPathPrefix returns 'p' without 'prefix', ensuring that the result starts with a "/".
+func trimPathPrefix(p string, prefix string) string {
+
+	p = strings.TrimPrefix(p, prefix)
+
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+
+	return p
+}
+
+// stripRequestPrefix returns a shallow copy of 'req' with 'prefix' removed from its path.
+func stripRequestPrefix(req *http.Request, prefix string) *http.Request {
+
+	req2 := new(http.Request)
+	*req2 = *req
+
+	u := new(url.URL)
+	*u = *req.URL
+
+	u.Path = trimPathPrefix(u.Path, prefix)
+
+	if u.RawPath != "" {
+
+		if hasPathPrefix(u.RawPath, prefix) {
+			u.RawPath = trimPathPrefix(u.RawPath, prefix)
+		} else {
+			u.RawPath = ""
+		}
+	}
+
+	req2.URL = u
+	req2.RequestURI = u.RequestURI()
+
+	return req2
+}
+
+// lambdaResourcePath returns the API Gateway resource 'resource' (for example "/things/{id}" or "/{proxy+}") with its path
+// parameters replaced by the values in 'params'. It returns an empty string if any parameters are missing.
+func lambdaResourcePath(resource string, params map[string]string) string {
+
+	if resource == "" {
+		return ""
+	}
+
+	missing := false
+
+	resource_path := re_resource_param.ReplaceAllStringFunc(resource, func(s string) string {
+
+		name := strings.TrimSuffix(strings.Trim(s, "{}"), "+")
+		v, ok := params[name]
+
+		if !ok {
+			missing = true
+		}
+
+		return v
+	})
+
+	if missing {
+		return ""
+	}
+
+	return resource_path
+}
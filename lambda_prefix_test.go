@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLambdaPathPrefix(t *testing.T) {
+
+	ctx := context.Background()
+
+	mux := http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+		rsp.Header().Set("Content-Type", "text/plain")
+		rsp.Write([]byte(req.URL.Path + " " + PublicPrefixFromContext(req.Context()) + " " + PublicPath(req.Context(), "/foo/")))
+	})
+
+	tests := []struct {
+		ServerURI string
+		Event     string
+		Expected  string
+	}{
+		// API Gateway v2 non-default stage
+		{
+			ServerURI: "lambda://?stage_prefix=auto",
+			Event:     `{"version":"2.0","rawPath":"/prod/api/foo","requestContext":{"stage":"prod","http":{"method":"GET"}}}`,
+			Expected:  "/api/foo /prod /prod/foo/",
+		},
+		// API Gateway v2 default stage
+		{
+			ServerURI: "lambda://?stage_prefix=auto",
+			Event:     `{"version":"2.0","rawPath":"/api/foo","requestContext":{"stage":"$default","http":{"method":"GET"}}}`,
+			Expected:  "/api/foo  /foo/",
+		},
+		// API Gateway v2 stage and prefix
+		{
+			ServerURI: "lambda://?stage_prefix=auto&strip_prefix=/api/",
+			Event:     `{"version":"2.0","rawPath":"/prod/api/foo","requestContext":{"stage":"prod","http":{"method":"GET"}}}`,
+			Expected:  "/foo /prod/api /prod/api/foo/",
+		},
+		// API Gateway v1 execute-api domain: the stage is not in the path
+		{
+			ServerURI: "lambda://?stage_prefix=auto",
+			Event:     `{"httpMethod":"GET","path":"/api/foo","resource":"/{proxy+}","pathParameters":{"proxy":"api/foo"},"requestContext":{"accountId":"123456789012","stage":"prod","path":"/prod/api/foo"}}`,
+			Expected:  "/api/foo /prod /prod/foo/",
+		},
+		// API Gateway v1 custom domain base path mapping
+		{
+			ServerURI: "lambda://?stage_prefix=auto",
+			Event:     `{"httpMethod":"GET","path":"/v2/foo","resource":"/{proxy+}","pathParameters":{"proxy":"foo"},"requestContext":{"accountId":"123456789012","stage":"prod","path":"/v2/foo"}}`,
+			Expected:  "/foo /v2 /v2/foo/",
+		},
+		// Prefix that is not present
+		{
+			ServerURI: "lambda://?strip_prefix=/v2",
+			Event:     `{"version":"2.0","rawPath":"/v3/foo","requestContext":{"http":{"method":"GET"}}}`,
+			Expected:  "/v3/foo  /foo/",
+		},
+		// Prefix is the entire path
+		{
+			ServerURI: "functionurl://?strip_prefix=/v2",
+			Event:     `{"version":"2.0","rawPath":"/v2","requestContext":{"http":{"method":"GET"}}}`,
+			Expected:  "/ /v2 /v2/foo/",
+		},
+		// Prefix must match a complete path segment
+		{
+			ServerURI: "functionurl://?strip_prefix=/v2",
+			Event:     `{"version":"2.0","rawPath":"/v2foo","requestContext":{"http":{"method":"GET"}}}`,
+			Expected:  "/v2foo  /foo/",
+		},
+	}
+
+	for _, test := range tests {
+
+		s, err := NewServer(ctx, test.ServerURI)
+
+		if err != nil {
+			t.Fatalf("Failed to create server %s, %v", test.ServerURI, err)
+		}
+
+		lambda_handler := s.(LambdaHandlerServer).LambdaHandler(mux)
+
+		rsp, err := InvokeLambdaEvent(ctx, lambda_handler, []byte(test.Event))
+
+		if err != nil {
+			t.Fatalf("Failed to invoke event for %s, %v", test.ServerURI, err)
+		}
+
+		if !strings.Contains(string(rsp), `"body": "`+test.Expected+`"`) {
+			t.Fatalf("Unexpected response for %s (%s): %s", test.ServerURI, test.Event, rsp)
+		}
+	}
+}
+
+func TestLambdaPathPrefixInvalid(t *testing.T) {
+
+	ctx := context.Background()
+
+	for _, uri := range []string{"lambda://?strip_prefix=v2", "lambda://?stage_prefix=yes", "functionurl://?strip_prefix=v2", "functionurl://?stage_prefix=auto"} {
+
+		_, err := NewServer(ctx, uri)
+
+		if err == nil {
+			t.Fatalf("Expected %s to fail", uri)
+		}
+	}
+}
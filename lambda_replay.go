@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// InvokeLambdaEvent invokes 'lambda_handler', typically the handler returned by a `LambdaHandlerServer` instance's
+// `LambdaHandler` method, with the Lambda event in 'payload' and returns the response normalized using
+// `NormalizeLambdaResponse`. If 'ctx' does not contain a `lambdacontext.LambdaContext` instance one is added.
+func InvokeLambdaEvent(ctx context.Context, lambda_handler lambda.Handler, payload []byte) ([]byte, error) {
+
+	_, ok := lambdacontext.FromContext(ctx)
+
+	if !ok {
+
+		ctx = lambdacontext.NewContext(ctx, &lambdacontext.LambdaContext{
+			AwsRequestID:       newLambdaEventRequestID(),
+			InvokedFunctionArn: LAMBDA_EMULATOR_FUNCTION_ARN,
+		})
+	}
+
+	rsp, err := lambda_handler.Invoke(ctx, payload)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to invoke Lambda handler, %w", err)
+	}
+
+	return NormalizeLambdaResponse(rsp)
+}
+
+// NormalizeLambdaResponse returns the Lambda response in 'payload' as indented JSON with sorted keys, suitable for
+// comparing against golden files. Streaming (Function URL) responses are converted in to the equivalent buffered
+// response with the body base64-encoded if it isn't valid UTF-8.
+func NormalizeLambdaResponse(payload []byte) ([]byte, error) {
+
+	rsp, body, err := parseLambdaResponse(payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var v any
+
+	err = json.Unmarshal(payload, &v)
+
+	if err != nil {
+
+		// Streaming response
+
+		if utf8.Valid(body) {
+			rsp.Body = string(body)
+			rsp.IsBase64Encoded = false
+		} else {
+			rsp.Body = base64.StdEncoding.EncodeToString(body)
+			rsp.IsBase64Encoded = true
+		}
+
+		// Round-trip the response so that its keys are sorted like those of buffered responses
+
+		enc, err := json.Marshal(rsp)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal response, %w", err)
+		}
+
+		err = json.Unmarshal(enc, &v)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal response, %w", err)
+		}
+	}
+
+	enc, err := json.MarshalIndent(v, "", "  ")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal response, %w", err)
+	}
+
+	return append(enc, '\n'), nil
+}
+
+// LambdaReplayOptions is a struct containing configuration details for the `ReplayLambdaFixtures` method.
+type LambdaReplayOptions struct {
+	// ServerURI is the `lambda://` or `functionurl://` URI of the server whose code path events are sent through. Default is "lambda://".
+	ServerURI string
+	// GoldenDir is the directory containing golden response files. If empty golden files are expected to be in the same
+	// directory as their event files. See `LambdaGoldenPath` for details.
+	GoldenDir string
+	// Update, if true, writes each response to its golden file rather than comparing them.
+	Update bool
+}
+
+// LambdaReplayResult is the outcome of replaying a single Lambda event fixture.
+type LambdaReplayResult struct {
+	// Path is the path of the event file.
+	Path string
+	// GoldenPath is the path of the golden file for the event.
+	GoldenPath string
+	// Response is the normalized Lambda response (see `NormalizeLambdaResponse`) produced by the event.
+	Response []byte
+	// Golden is true if the event has a golden file (or one was written because `LambdaReplayOptions.Update` was true).
+	Golden bool
+	// Matched is true if the event has a golden file and 'Response' matches it.
+	Matched bool
+	// Diff is a line-based diff of the golden file and 'Response' if they do not match.
+	Diff string
+}
+
+// ReplayLambdaFixtures sends the Lambda events in the files listed in 'paths' through 'mux', using the same code path as
+// the server defined by 'opts.ServerURI', and compares the responses against their golden files or, if 'opts.Update' is
+// true, writes them to their golden files. Applications can use this method to write regression tests for their own
+// handlers against captured production payloads (see `NewLambdaCaptureHandler`). An error is only returned if an event
+// could not be replayed; responses which don't match their golden files are reported in the results.
+func ReplayLambdaFixtures(ctx context.Context, mux http.Handler, paths []string, opts *LambdaReplayOptions) ([]*LambdaReplayResult, error) {
+
+	server_uri := opts.ServerURI
+
+	if server_uri == "" {
+		server_uri = "lambda://"
+	}
+
+	s, err := NewServer(ctx, server_uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create server (%s), %w", server_uri, err)
+	}
+
+	lambda_server, ok := s.(LambdaHandlerServer)
+
+	if !ok {
+		return nil, fmt.Errorf("Server (%s) does not support Lambda events", server_uri)
+	}
+
+	lambda_handler := lambda_server.LambdaHandler(mux)
+
+	results := make([]*LambdaReplayResult, len(paths))
+
+	for i, path := range paths {
+
+		payload, err := os.ReadFile(path)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s, %w", path, err)
+		}
+
+		rsp, err := InvokeLambdaEvent(ctx, lambda_handler, payload)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to replay %s, %w", path, err)
+		}
+
+		r := &LambdaReplayResult{
+			Path:       path,
+			GoldenPath: LambdaGoldenPath(path, opts.GoldenDir),
+			Response:   rsp,
+		}
+
+		results[i] = r
+
+		if opts.Update {
+
+			err := os.WriteFile(r.GoldenPath, rsp, 0644)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to write %s, %w", r.GoldenPath, err)
+			}
+
+			r.Golden = true
+			r.Matched = true
+			continue
+		}
+
+		expected, err := os.ReadFile(r.GoldenPath)
+
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s, %w", r.GoldenPath, err)
+		}
+
+		r.Golden = true
+		r.Matched = bytes.Equal(expected, rsp)
+
+		if !r.Matched {
+			r.Diff = diffLines(r.GoldenPath, expected, path, rsp)
+		}
+	}
+
+	return results, nil
+}
+
+// LambdaGoldenPath returns the path of the golden file for the Lambda event file 'path'. Golden files are named
+// "{EVENT}.golden.json", where {EVENT} is the name of the event file without its extension, and are stored in
+// 'golden_dir' or, if it is empty, the same directory as the event file.
+func LambdaGoldenPath(path string, golden_dir string) string {
+
+	fname := filepath.Base(path)
+	fname = strings.TrimSuffix(fname, filepath.Ext(fname)) + ".golden.json"
+
+	if golden_dir == "" {
+		golden_dir = filepath.Dir(path)
+	}
+
+	return filepath.Join(golden_dir, fname)
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInvokeLambdaEvent(t *testing.T) {
+
+	ctx := context.Background()
+
+	mux := http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+
+		id, _ := LambdaIdentityFromContext(req.Context())
+
+		rsp.Header().Set("Content-Type", "text/plain")
+		rsp.Write([]byte(id.EventType + " " + req.URL.Path))
+	})
+
+	tests := []struct {
+		ServerURI string
+		Event     string
+		Expected  string
+	}{
+		{
+			ServerURI: "lambda://",
+			Event:     `{"version":"2.0","rawPath":"/v2","requestContext":{"http":{"method":"GET"}}}`,
+			Expected: `{
+  "body": "apigateway_v2 /v2",
+  "headers": {
+    "Content-Type": "text/plain"
+  },
+  "statusCode": 200
+}
+`,
+		},
+		{
+			ServerURI: "functionurl://",
+			Event:     `{"version":"2.0","rawPath":"/fu","requestContext":{"http":{"method":"GET"}}}`,
+			Expected: `{
+  "body": "functionurl /fu",
+  "cookies": null,
+  "headers": {
+    "Content-Type": "text/plain"
+  },
+  "isBase64Encoded": false,
+  "statusCode": 200
+}
+`,
+		},
+		{
+			ServerURI: "functionurl://?invoke_mode=response_stream",
+			Event:     `{"version":"2.0","rawPath":"/stream","requestContext":{"http":{"method":"GET"}}}`,
+			Expected: `{
+  "body": "functionurl /stream",
+  "headers": {
+    "Content-Type": "text/plain"
+  },
+  "statusCode": 200
+}
+`,
+		},
+	}
+
+	for _, test := range tests {
+
+		s, err := NewServer(ctx, test.ServerURI)
+
+		if err != nil {
+			t.Fatalf("Failed to create server %s, %v", test.ServerURI, err)
+		}
+
+		lambda_handler := s.(LambdaHandlerServer).LambdaHandler(mux)
+
+		rsp, err := InvokeLambdaEvent(ctx, lambda_handler, []byte(test.Event))
+
+		if err != nil {
+			t.Fatalf("Failed to invoke event for %s, %v", test.ServerURI, err)
+		}
+
+		if string(rsp) != test.Expected {
+			t.Fatalf("Unexpected response for %s:\n%s\nexpected:\n%s", test.ServerURI, rsp, test.Expected)
+		}
+	}
+}
+
+func TestReplayLambdaFixtures(t *testing.T) {
+
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	event_path := filepath.Join(dir, "hello.json")
+
+	err := os.WriteFile(event_path, []byte(`{"version":"2.0","rawPath":"/hello","requestContext":{"http":{"method":"GET"}}}`), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write event, %v", err)
+	}
+
+	new_handler := func(greeting string) http.Handler {
+		return http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+			rsp.Header().Set("Content-Type", "text/plain")
+			rsp.Write([]byte(greeting + " " + req.URL.Path))
+		})
+	}
+
+	opts := &LambdaReplayOptions{
+		ServerURI: "functionurl://",
+	}
+
+	// Without a golden file the response is returned but not compared
+
+	results, err := ReplayLambdaFixtures(ctx, new_handler("Hello"), []string{event_path}, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to replay fixtures, %v", err)
+	}
+
+	if len(results) != 1 || results[0].Golden || !strings.Contains(string(results[0].Response), "Hello /hello") {
+		t.Fatalf("Unexpected result without golden file, %v", results[0])
+	}
+
+	opts.Update = true
+
+	results, err = ReplayLambdaFixtures(ctx, new_handler("Hello"), []string{event_path}, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to update golden files, %v", err)
+	}
+
+	golden_path := filepath.Join(dir, "hello.golden.json")
+
+	if results[0].GoldenPath != golden_path {
+		t.Fatalf("Unexpected golden path, %s", results[0].GoldenPath)
+	}
+
+	_, err = os.Stat(golden_path)
+
+	if err != nil {
+		t.Fatalf("Expected golden file to be written, %v", err)
+	}
+
+	opts.Update = false
+
+	results, err = ReplayLambdaFixtures(ctx, new_handler("Hello"), []string{event_path}, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to replay fixtures, %v", err)
+	}
+
+	if !results[0].Golden || !results[0].Matched || results[0].Diff != "" {
+		t.Fatalf("Expected response to match golden file, %s", results[0].Diff)
+	}
+
+	// A handler whose response has changed is reported with a diff
+
+	results, err = ReplayLambdaFixtures(ctx, new_handler("Goodbye"), []string{event_path}, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to replay fixtures, %v", err)
+	}
+
+	if results[0].Matched || !strings.Contains(results[0].Diff, `-  "body": "Hello /hello"`) || !strings.Contains(results[0].Diff, `+  "body": "Goodbye /hello"`) {
+		t.Fatalf("Unexpected diff, %s", results[0].Diff)
+	}
+}
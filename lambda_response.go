@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// The maximum size, in bytes, of a Lambda request or (buffered) response payload.
+const LAMBDA_MAX_PAYLOAD_SIZE int = 6 * 1024 * 1024
+
+// The minimum size, in bytes, of a response body before it will be compressed.
+const lambdaCompressMinSize int = 1024
+
+// lambdaResponseEncoder encodes the bodies of HTTP responses for inclusion in Lambda response payloads,
+// compressing and base64-encoding them as necessary and ensuring the payload will not exceed Lambda's limits.
+type lambdaResponseEncoder struct {
+	binary_types     *binaryContentTypes
+	compress         bool
+	max_payload_size int
+}
+
+// newLambdaResponseEncoder returns a new `lambdaResponseEncoder` instance derived from the `binary_type`, `binary_auto`,
+// `compress` and `max_payload_size` parameters in 'q'.
+func newLambdaResponseEncoder(q url.Values) (*lambdaResponseEncoder, error) {
+
+	binary_types, err := newBinaryContentTypes(q)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e := &lambdaResponseEncoder{
+		binary_types:     binary_types,
+		compress:         false,
+		max_payload_size: LAMBDA_MAX_PAYLOAD_SIZE,
+	}
+
+	if q.Has("compress") {
+
+		compress, err := strconv.ParseBool(q.Get("compress"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid compress parameter, %w", err)
+		}
+
+		e.compress = compress
+	}
+
+	if q.Has("max_payload_size") {
+
+		sz, err := strconv.Atoi(q.Get("max_payload_size"))
+
+		if err != nil || sz <= 0 {
+			return nil, fmt.Errorf("Invalid max_payload_size parameter, %s", q.Get("max_payload_size"))
+		}
+
+		e.max_payload_size = sz
+	}
+
+	return e, nil
+}
+
+// encodeBody returns 'body' encoded for inclusion in a Lambda response payload and whether it has been base64-encoded.
+// If compression is enabled and the body's content type is compressible 'header' will be updated with a "Vary: Accept-Encoding"
+// header, whether or not the body is compressed, so that caches do not serve one client's variant to another. If the client's
+// 'accept_encoding' header allows it and the body is large enough it will be compressed and 'header' will be updated with the
+// relevant "Content-Encoding" header.
+func (e *lambdaResponseEncoder) encodeBody(accept_encoding string, header http.Header, body []byte) (string, bool, error) {
+
+	if e.compress && isCompressible(header) {
+
+		header.Add("Vary", "Accept-Encoding")
+
+		encoding := ""
+
+		if len(body) >= lambdaCompressMinSize {
+			encoding = negotiateContentEncoding(accept_encoding)
+		}
+
+		if encoding != "" {
+
+			compressed, err := compressBody(encoding, body)
+
+			if err != nil {
+				return "", false, fmt.Errorf("Failed to compress response body, %w", err)
+			}
+
+			header.Set("Content-Encoding", encoding)
+			header.Del("Content-Length")
+
+			// Compressed bodies are always binary
+			return base64.StdEncoding.EncodeToString(compressed), true, nil
+		}
+	}
+
+	if e.binary_types.isBinary(header.Get("Content-Type"), body) {
+		return base64.StdEncoding.EncodeToString(body), true, nil
+	}
+
+	return string(body), false, nil
+}
+
+// isCompressible returns true if the response described by 'header' has a textual content type and has not already been encoded.
+func isCompressible(header http.Header) bool {
+
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	mt := parseMediaType(header.Get("Content-Type"))
+
+	if mt == "" || !isTextMediaType(mt) {
+		return false
+	}
+
+	return true
+}
+
+// oversizeRequest returns the status code, headers and body to send instead of invoking the handler for a request whose payload exceeds the maximum payload size.
+func (e *lambdaResponseEncoder) oversizeRequest(size int) (int, http.Header, string) {
+	body := fmt.Sprintf("Request payload (%d bytes) exceeds the maximum Lambda request size (%d bytes)\n", size, e.max_payload_size)
+	return http.StatusRequestEntityTooLarge, oversizeHeader(), body
+}
+
+// oversizeResponse returns the status code, headers and body to send instead of a response whose payload exceeds the maximum payload size.
+func (e *lambdaResponseEncoder) oversizeResponse(size int) (int, http.Header, string) {
+	body := fmt.Sprintf("Response payload (%d bytes) exceeds the maximum Lambda response size (%d bytes)\n", size, e.max_payload_size)
+	return http.StatusBadGateway, oversizeHeader(), body
+}
+
+// oversizeHeader returns the headers for the plain text error sent in place of an oversize request or response.
+func oversizeHeader() http.Header {
+
+	header := make(http.Header)
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	return header
+}
+
+// negotiateContentEncoding returns the preferred content encoding ("br" or "gzip") allowed by 'accept_encoding', or an empty string.
+func negotiateContentEncoding(accept_encoding string) string {
+
+	weights := make(map[string]float64)
+
+	for _, part := range strings.Split(accept_encoding, ",") {
+
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+
+		for _, p := range strings.Split(params, ";") {
+
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+
+			if ok && strings.TrimSpace(k) == "q" {
+
+				f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+
+				if err == nil {
+					q = f
+				}
+			}
+		}
+
+		weights[coding] = q
+	}
+
+	q_for := func(coding string) float64 {
+
+		q, ok := weights[coding]
+
+		if ok {
+			return q
+		}
+
+		q, ok = weights["*"]
+
+		if ok {
+			return q
+		}
+
+		return 0
+	}
+
+	br := q_for("br")
+	gz := q_for("gzip")
+
+	switch {
+	case br > 0 && br >= gz:
+		return "br"
+	case gz > 0:
+		return "gzip"
+	default:
+		return ""
+	}
+}
+
+// compressBody compresses 'body' using 'encoding' which is expected to be "br" or "gzip".
+func compressBody(encoding string, body []byte) ([]byte, error) {
+
+	var buf bytes.Buffer
+	var wr io.WriteCloser
+
+	switch encoding {
+	case "br":
+		wr = brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	case "gzip":
+		wr = gzip.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("Unsupported content encoding, %s", encoding)
+	}
+
+	_, err := wr.Write(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = wr.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/akrylysov/algnhsa"
+	"github.com/andybalholm/brotli"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestNegotiateContentEncoding(t *testing.T) {
+
+	tests := map[string]string{
+		"":                        "",
+		"identity":                "",
+		"gzip":                    "gzip",
+		"gzip, deflate, br":       "br",
+		"br;q=0.5, gzip":          "gzip",
+		"br;q=0, gzip;q=0":        "",
+		"*":                       "br",
+		"*;q=0.1, gzip;q=0.5":     "gzip",
+		"GZIP;q=1.0, BR;q=1.0":    "br",
+		"deflate, compress;q=0.5": "",
+	}
+
+	for accept_encoding, expected := range tests {
+
+		encoding := negotiateContentEncoding(accept_encoding)
+
+		if encoding != expected {
+			t.Fatalf("Unexpected encoding for '%s': '%s', expected '%s'", accept_encoding, encoding, expected)
+		}
+	}
+}
+
+func TestLambdaResponseEncoder(t *testing.T) {
+
+	e, err := newLambdaResponseEncoder(url.Values{})
+
+	if err != nil {
+		t.Fatalf("Failed to create response encoder, %v", err)
+	}
+
+	if e.compress {
+		t.Fatalf("Did not expect compression to be enabled by default")
+	}
+
+	q := url.Values{}
+	q.Set("compress", "true")
+
+	e, err = newLambdaResponseEncoder(q)
+
+	if err != nil {
+		t.Fatalf("Failed to create response encoder, %v", err)
+	}
+
+	text := []byte(strings.Repeat("hello world ", 1000))
+
+	for _, encoding := range []string{"br", "gzip"} {
+
+		header := make(http.Header)
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		header.Set("Content-Length", "12000")
+
+		body, is_base64, err := e.encodeBody(encoding, header, text)
+
+		if err != nil {
+			t.Fatalf("Failed to encode body, %v", err)
+		}
+
+		if !is_base64 {
+			t.Fatalf("Expected compressed body to be base64-encoded")
+		}
+
+		if header.Get("Content-Encoding") != encoding || header.Get("Vary") != "Accept-Encoding" || header.Get("Content-Length") != "" {
+			t.Fatalf("Unexpected headers for %s: %v", encoding, header)
+		}
+
+		compressed, err := base64.StdEncoding.DecodeString(body)
+
+		if err != nil {
+			t.Fatalf("Failed to decode body, %v", err)
+		}
+
+		var r io.Reader
+
+		switch encoding {
+		case "br":
+			r = brotli.NewReader(bytes.NewReader(compressed))
+		default:
+			r, err = gzip.NewReader(bytes.NewReader(compressed))
+
+			if err != nil {
+				t.Fatalf("Failed to create gzip reader, %v", err)
+			}
+		}
+
+		decompressed, err := io.ReadAll(r)
+
+		if err != nil {
+			t.Fatalf("Failed to decompress body, %v", err)
+		}
+
+		if !bytes.Equal(decompressed, text) {
+			t.Fatalf("Unexpected decompressed body for %s", encoding)
+		}
+	}
+
+	// Small, non-text or already encoded bodies are not compressed
+
+	skip := []http.Header{
+		{"Content-Type": []string{"image/png"}},
+		{"Content-Type": []string{"text/plain"}, "Content-Encoding": []string{"gzip"}},
+	}
+
+	for _, header := range skip {
+
+		_, _, err := e.encodeBody("gzip", header, text)
+
+		if err != nil {
+			t.Fatalf("Failed to encode body, %v", err)
+		}
+
+		if header.Get("Vary") != "" {
+			t.Fatalf("Did not expect body to be compressed: %v", header)
+		}
+	}
+
+	header := http.Header{"Content-Type": []string{"text/plain"}}
+
+	body, _, err := e.encodeBody("gzip", header, []byte("hello"))
+
+	if err != nil || body != "hello" || header.Get("Content-Encoding") != "" {
+		t.Fatalf("Did not expect small body to be compressed")
+	}
+
+	// Compressible responses always vary by Accept-Encoding, even when they are not compressed
+
+	if header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Expected Vary header for small body: %v", header)
+	}
+
+	header = http.Header{"Content-Type": []string{"text/plain"}}
+
+	body, is_base64, err := e.encodeBody("", header, text)
+
+	if err != nil || is_base64 || body != string(text) || header.Get("Content-Encoding") != "" {
+		t.Fatalf("Did not expect body to be compressed without Accept-Encoding header")
+	}
+
+	if header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Expected Vary header without Accept-Encoding header: %v", header)
+	}
+
+	q = url.Values{}
+	q.Set("compress", "false")
+
+	e, err = newLambdaResponseEncoder(q)
+
+	if err != nil {
+		t.Fatalf("Failed to create response encoder, %v", err)
+	}
+
+	header = http.Header{"Content-Type": []string{"text/plain"}}
+
+	_, is_base64, err = e.encodeBody("gzip", header, text)
+
+	if err != nil || is_base64 || header.Get("Content-Encoding") != "" || header.Get("Vary") != "" {
+		t.Fatalf("Did not expect body to be compressed when compression is disabled")
+	}
+
+	q = url.Values{}
+	q.Set("max_payload_size", "-1")
+
+	_, err = newLambdaResponseEncoder(q)
+
+	if err == nil {
+		t.Fatalf("Expected invalid max_payload_size parameter to fail")
+	}
+}
+
+func TestLambdaResponseHandlerPayloadSize(t *testing.T) {
+
+	ctx := context.Background()
+
+	q := url.Values{}
+	q.Set("max_payload_size", "1024")
+	q.Set("compress", "false")
+
+	e, err := newLambdaResponseEncoder(q)
+
+	if err != nil {
+		t.Fatalf("Failed to create response encoder, %v", err)
+	}
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+		rsp.Header().Set("Content-Type", "text/plain")
+		rsp.Write([]byte(strings.Repeat("a", 2048)))
+	}
+
+	h := &lambdaResponseHandler{
+		handler: algnhsa.New(http.HandlerFunc(handler), &algnhsa.Options{BinaryContentTypes: []string{"*/*"}}),
+		encoder: e,
+		logger:  slog.Default(),
+	}
+
+	event := events.APIGatewayV2HTTPRequest{
+		Version: "2.0",
+		RawPath: "/large",
+	}
+
+	event.RequestContext.HTTP.Method = http.MethodGet
+
+	payload, err := json.Marshal(event)
+
+	if err != nil {
+		t.Fatalf("Failed to marshal event, %v", err)
+	}
+
+	rsp_body, err := h.Invoke(ctx, payload)
+
+	if err != nil {
+		t.Fatalf("Failed to invoke handler, %v", err)
+	}
+
+	var rsp events.APIGatewayV2HTTPResponse
+
+	err = json.Unmarshal(rsp_body, &rsp)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response, %v", err)
+	}
+
+	if rsp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("Unexpected status code: %d", rsp.StatusCode)
+	}
+
+	if !strings.Contains(rsp.Body, "exceeds the maximum Lambda response size") {
+		t.Fatalf("Unexpected body: %s", rsp.Body)
+	}
+
+	// Requests larger than max_payload_size are rejected without invoking the handler
+
+	event.Body = strings.Repeat("b", 2048)
+
+	payload, err = json.Marshal(event)
+
+	if err != nil {
+		t.Fatalf("Failed to marshal event, %v", err)
+	}
+
+	rsp_body, err = h.Invoke(ctx, payload)
+
+	if err != nil {
+		t.Fatalf("Failed to invoke handler, %v", err)
+	}
+
+	rsp = events.APIGatewayV2HTTPResponse{}
+
+	err = json.Unmarshal(rsp_body, &rsp)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response, %v", err)
+	}
+
+	if rsp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Unexpected status code: %d", rsp.StatusCode)
+	}
+
+	if !strings.Contains(rsp.Body, "exceeds the maximum Lambda request size") {
+		t.Fatalf("Unexpected body: %s", rsp.Body)
+	}
+}
+
+func TestLambdaFunctionURLServerCompression(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "functionurl://?compress=true&max_payload_size=4096")
+
+	if err != nil {
+		t.Fatalf("Failed to create new server, %v", err)
+	}
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+		rsp.Header().Set("Content-Type", "text/html")
+
+		switch req.URL.Path {
+		case "/large":
+			rsp.Write([]byte(strings.Repeat("<p>", 10000)))
+		default:
+			rsp.Write([]byte(strings.Repeat("<p>", 1000)))
+		}
+	}
+
+	fu_s := s.(*LambdaFunctionURLServer)
+	fu_s.handler = http.HandlerFunc(handler)
+
+	event := events.LambdaFunctionURLRequest{
+		RawPath: "/",
+		Headers: map[string]string{
+			"accept-encoding": "gzip",
+		},
+	}
+
+	event.RequestContext.HTTP.Method = http.MethodGet
+
+	rsp, err := fu_s.handleRequest(ctx, event)
+
+	if err != nil {
+		t.Fatalf("Failed to handle request, %v", err)
+	}
+
+	if rsp.Headers["Content-Encoding"] != "gzip" || !rsp.IsBase64Encoded {
+		t.Fatalf("Expected gzip encoded response, %v", rsp.Headers)
+	}
+
+	// Uncompressed this is larger than max_payload_size
+
+	event.Headers = nil
+	event.RawPath = "/large"
+
+	rsp, err = fu_s.handleRequest(ctx, event)
+
+	if err != nil {
+		t.Fatalf("Failed to handle request, %v", err)
+	}
+
+	if rsp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("Unexpected status code: %d", rsp.StatusCode)
+	}
+
+	// Requests larger than max_payload_size are rejected
+
+	event.RawPath = "/"
+	event.Body = strings.Repeat("b", 8192)
+
+	rsp, err = fu_s.handleRequest(ctx, event)
+
+	if err != nil {
+		t.Fatalf("Failed to handle request, %v", err)
+	}
+
+	if rsp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Unexpected status code: %d", rsp.StatusCode)
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// Warmer is an optional interface for `http.Handler` instances that can do work, like initializing resources that
+// would otherwise be initialized on first use, in response to a warm-up ping. The `lambda://` and `functionurl://`
+// servers invoke the `Warm` method of the handler passed to `ListenAndServe` when they receive a warm-up ping and
+// the `warmer` parameter is enabled. The `handler.RouteHandlerWithOptions` handler implements this interface. Handlers
+// which are mounted inside another handler, like an `http.ServeMux` or middleware, are hidden from the server and should
+// be registered using `WithWarmers` instead.
+type Warmer interface {
+	// Warm does any work necessary to prepare the handler to serve requests.
+	Warm(context.Context) error
+}
+
+// warmersContextKey is the key used to store a list of `Warmer` instances in a `context.Context`.
+type warmersContextKey struct{}
+
+// WithWarmers returns a copy of 'ctx' that stores 'warmers', in addition to any already stored in 'ctx'. The `lambda://`
+// and `functionurl://` servers created with the resulting context, using `NewServer`, invoke the `Warm` method of each
+// of them, as well as that of the handler passed to `ListenAndServe`, when they receive a warm-up ping. This is how
+// handlers which are mounted inside another handler are warmed. For example:
+//
+//	route_handler, err := handler.RouteHandlerWithOptions(opts)
+//	mux.Handle("/", route_handler)
+//
+//	ctx = server.WithWarmers(ctx, route_handler.(server.Warmer))
+//	s, err := server.NewServer(ctx, "lambda://?warmer=true")
+func WithWarmers(ctx context.Context, warmers ...Warmer) context.Context {
+	warmers = append(slices.Clone(WarmersFromContext(ctx)), warmers...)
+	return context.WithValue(ctx, warmersContextKey{}, warmers)
+}
+
+// WarmersFromContext returns the list of `Warmer` instances stored in 'ctx' by `WithWarmers`.
+func WarmersFromContext(ctx context.Context) []Warmer {
+
+	warmers, ok := ctx.Value(warmersContextKey{}).([]Warmer)
+
+	if !ok {
+		return nil
+	}
+
+	return warmers
+}
+
+// lambdaWarmer recognizes warm-up pings and handles them without invoking the `http.Handler`.
+type lambdaWarmer struct {
+	schedule bool
+	// warmers are the `Warmer` instances registered using `WithWarmers`.
+	warmers []Warmer
+	logger  *slog.Logger
+}
+
+// lambdaWarmerProbe contains the properties needed to determine whether a Lambda event is a warm-up ping.
+type lambdaWarmerProbe struct {
+	Warmer     bool   `json:"warmer"`
+	Source     string `json:"source"`
+	DetailType string `json:"detail-type"`
+}
+
+// newLambdaWarmer returns a new `lambdaWarmer` instance, which warms the `Warmer` instances stored in 'ctx', if the `warmer`
+// parameter in 'q' is true and nil otherwise.
+func newLambdaWarmer(ctx context.Context, q url.Values, logger *slog.Logger) (*lambdaWarmer, error) {
+
+	if !q.Has("warmer") {
+		return nil, nil
+	}
+
+	enabled, err := strconv.ParseBool(q.Get("warmer"))
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid warmer parameter, %w", err)
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+
+	w := &lambdaWarmer{
+		schedule: true,
+		warmers:  WarmersFromContext(ctx),
+		logger:   logger,
+	}
+
+	return w, nil
+}
+
+// isWarmUp returns true if 'payload' is a warm-up ping. Warm-up pings are events with a "warmer" property set to true
+// (as sent by the `lambda-warmer` package), events whose "source" is "serverless-plugin-warmup" and, unless they are
+// being routed to the `http.Handler`, scheduled (EventBridge or CloudWatch Events) events.
+func (w *lambdaWarmer) isWarmUp(payload []byte) bool {
+
+	var probe lambdaWarmerProbe
+
+	err := json.Unmarshal(payload, &probe)
+
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case probe.Warmer:
+		return true
+	case probe.Source == "serverless-plugin-warmup":
+		return true
+	case w.schedule && probe.DetailType != "" && lambdaEventBridgeType(probe.Source, probe.DetailType) == LAMBDA_EVENT_SCHEDULE:
+		return true
+	default:
+		return false
+	}
+}
+
+// warm invokes the `Warm` method of 'mux', if it implements the `Warmer` interface, and of each of the `Warmer` instances
+// registered using `WithWarmers`, logging how long each took. Since warm-up pings are only answered when the `warmer`
+// parameter is enabled a ping which doesn't warm anything, for example because the `Warmer` is mounted inside 'mux' and
+// hasn't been registered, is logged as a warning.
+func (w *lambdaWarmer) warm(ctx context.Context, mux http.Handler) {
+
+	warmers := slices.Clone(w.warmers)
+
+	mux_warmer, ok := mux.(Warmer)
+
+	if ok && !slices.ContainsFunc(warmers, func(other Warmer) bool { return sameWarmer(other, mux_warmer) }) {
+		warmers = append([]Warmer{mux_warmer}, warmers...)
+	}
+
+	if len(warmers) == 0 {
+		w.logger.Warn("No Warmer instances to warm, answering warm-up ping without warming anything. Register handlers mounted inside the handler passed to ListenAndServe using WithWarmers", "handler", fmt.Sprintf("%T", mux))
+		return
+	}
+
+	for _, warmer := range warmers {
+
+		t1 := time.Now()
+
+		err := warmer.Warm(ctx)
+
+		if err != nil {
+			w.logger.Error("Failed to warm handler", "handler", fmt.Sprintf("%T", warmer), "duration", time.Since(t1), "error", err)
+			continue
+		}
+
+		w.logger.Info("Warmed handler", "handler", fmt.Sprintf("%T", warmer), "duration", time.Since(t1))
+	}
+}
+
+// sameWarmer reports whether 'a' and 'b' are the same `Warmer` instance. Warmers whose types can't be compared, like
+// functions, are never the same.
+func sameWarmer(a Warmer, b Warmer) bool {
+
+	t := reflect.TypeOf(a)
+
+	if t != reflect.TypeOf(b) || !t.Comparable() {
+		return false
+	}
+
+	return a == b
+}
+
+// lambdaWarmerHandler implements the `lambda.Handler` interface handling warm-up pings itself and passing all other
+// events to the underlying handler.
+type lambdaWarmerHandler struct {
+	handler lambda.Handler
+	mux     http.Handler
+	warmer  *lambdaWarmer
+}
+
+// Invoke handles 'payload' if it is a warm-up ping and invokes the underlying handler otherwise.
+func (h *lambdaWarmerHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+
+	if !h.warmer.isWarmUp(payload) {
+		return h.handler.Invoke(ctx, payload)
+	}
+
+	h.warmer.warm(ctx, h.mux)
+	return []byte("null"), nil
+}
+
+// withFunctionURLWarmer returns a Lambda handler function that handles warm-up pings itself, returning the response
+// produced by 'warm_rsp', and passes all other events to 'fn'. Unlike `lambdaWarmerHandler` this preserves the
+// (streaming) response types of the Function URL handler functions.
+func withFunctionURLWarmer[T any](w *lambdaWarmer, mux http.Handler, fn func(context.Context, events.LambdaFunctionURLRequest) (T, error), warm_rsp func() T) func(context.Context, json.RawMessage) (T, error) {
+
+	return func(ctx context.Context, payload json.RawMessage) (T, error) {
+
+		if w.isWarmUp(payload) {
+			w.warm(ctx, mux)
+			return warm_rsp(), nil
+		}
+
+		var request events.LambdaFunctionURLRequest
+
+		err := json.Unmarshal(payload, &request)
+
+		if err != nil {
+			var rsp T
+			return rsp, fmt.Errorf("Failed to unmarshal Function URL event, %w", err)
+		}
+
+		return fn(ctx, request)
+	}
+}
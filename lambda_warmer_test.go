@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type warmerTestHandler struct {
+	served *atomic.Int32
+	warmed *atomic.Int32
+}
+
+func (h *warmerTestHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+	h.served.Add(1)
+	rsp.Write([]byte("served"))
+}
+
+func (h *warmerTestHandler) Warm(ctx context.Context) error {
+	h.warmed.Add(1)
+	return nil
+}
+
+func TestLambdaWarmer(t *testing.T) {
+
+	ctx := context.Background()
+
+	warm_up := []string{
+		`{"warmer":true,"concurrency":1}`,
+		`{"source":"serverless-plugin-warmup"}`,
+		`{"id":"1","source":"aws.events","detail-type":"Scheduled Event","detail":{}}`,
+	}
+
+	for _, uri := range []string{"lambda://?warmer=true", "functionurl://?warmer=true", "functionurl://?warmer=true&invoke_mode=response_stream"} {
+
+		s, err := NewServer(ctx, uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create server %s, %v", uri, err)
+		}
+
+		mux := &warmerTestHandler{
+			served: new(atomic.Int32),
+			warmed: new(atomic.Int32),
+		}
+
+		lambda_handler := s.(LambdaHandlerServer).LambdaHandler(mux)
+
+		for _, payload := range warm_up {
+
+			_, err := lambda_handler.Invoke(ctx, []byte(payload))
+
+			if err != nil {
+				t.Fatalf("Failed to invoke %s with %s, %v", uri, payload, err)
+			}
+		}
+
+		if mux.served.Load() != 0 || mux.warmed.Load() != int32(len(warm_up)) {
+			t.Fatalf("Unexpected counts for %s: served %d warmed %d", uri, mux.served.Load(), mux.warmed.Load())
+		}
+
+		// Ordinary requests are still served
+
+		rsp, err := InvokeLambdaEvent(ctx, lambda_handler, []byte(`{"version":"2.0","rawPath":"/","requestContext":{"http":{"method":"GET"}}}`))
+
+		if err != nil {
+			t.Fatalf("Failed to invoke %s, %v", uri, err)
+		}
+
+		if !strings.Contains(string(rsp), `"body": "served"`) || mux.served.Load() != 1 {
+			t.Fatalf("Unexpected response for %s: %s", uri, rsp)
+		}
+	}
+}
+
+func TestLambdaWarmerScheduleEvents(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "lambda://?warmer=true&events=schedule")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	mux := &warmerTestHandler{
+		served: new(atomic.Int32),
+		warmed: new(atomic.Int32),
+	}
+
+	lambda_handler := s.(LambdaHandlerServer).LambdaHandler(mux)
+
+	// Scheduled events are routed to the handler rather than treated as warm-up pings
+
+	_, err = lambda_handler.Invoke(ctx, []byte(`{"id":"1","source":"aws.events","detail-type":"Scheduled Event","detail":{}}`))
+
+	if err != nil {
+		t.Fatalf("Failed to invoke scheduled event, %v", err)
+	}
+
+	_, err = lambda_handler.Invoke(ctx, []byte(`{"warmer":true}`))
+
+	if err != nil {
+		t.Fatalf("Failed to invoke warm-up ping, %v", err)
+	}
+
+	if mux.served.Load() != 1 || mux.warmed.Load() != 1 {
+		t.Fatalf("Unexpected counts: served %d warmed %d", mux.served.Load(), mux.warmed.Load())
+	}
+}
+
+func TestLambdaWarmerDisabled(t *testing.T) {
+
+	ctx := context.Background()
+
+	_, err := NewServer(ctx, "lambda://?warmer=maybe")
+
+	if err == nil {
+		t.Fatalf("Expected invalid warmer parameter to fail")
+	}
+
+	s, err := NewServer(ctx, "lambda://?warmer=false")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	mux := &warmerTestHandler{
+		served: new(atomic.Int32),
+		warmed: new(atomic.Int32),
+	}
+
+	lambda_handler := s.(LambdaHandlerServer).LambdaHandler(mux)
+
+	_, err = lambda_handler.Invoke(ctx, []byte(`{"warmer":true}`))
+
+	if err == nil {
+		t.Fatalf("Expected warm-up ping to fail when warmer is disabled")
+	}
+
+	if mux.warmed.Load() != 0 {
+		t.Fatalf("Expected handler not to be warmed")
+	}
+}
+
+func TestLambdaWarmerWrappedHandler(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	ctx := WithLogger(context.Background(), logger)
+
+	s, err := NewServer(ctx, "lambda://?warmer=true")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	mux := &warmerTestHandler{
+		served: new(atomic.Int32),
+		warmed: new(atomic.Int32),
+	}
+
+	// Wrapping the handler hides its Warm method
+
+	wrapped := http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+		mux.ServeHTTP(rsp, req)
+	})
+
+	lambda_handler := s.(LambdaHandlerServer).LambdaHandler(wrapped)
+
+	_, err = lambda_handler.Invoke(ctx, []byte(`{"warmer":true}`))
+
+	if err != nil {
+		t.Fatalf("Failed to invoke warm-up ping, %v", err)
+	}
+
+	if mux.served.Load() != 0 || mux.warmed.Load() != 0 {
+		t.Fatalf("Unexpected counts: served %d warmed %d", mux.served.Load(), mux.warmed.Load())
+	}
+
+	if !strings.Contains(buf.String(), "level=WARN") || !strings.Contains(buf.String(), "WithWarmers") {
+		t.Fatalf("Expected a warning about nothing being warmed, %s", buf.String())
+	}
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// The event type for requests derived from API Gateway WebSocket API events.
+const LAMBDA_EVENT_WEBSOCKET string = "websocket"
+
+// The route key for API Gateway WebSocket API connect events.
+const WEBSOCKET_ROUTE_CONNECT string = "$connect"
+
+// The route key for API Gateway WebSocket API disconnect events.
+const WEBSOCKET_ROUTE_DISCONNECT string = "$disconnect"
+
+// The route key for API Gateway WebSocket API messages that do not match any other route.
+const WEBSOCKET_ROUTE_DEFAULT string = "$default"
+
+// The header containing the connection ID of requests derived from API Gateway WebSocket API events.
+const WEBSOCKET_CONNECTION_ID_HEADER string = "X-Lambda-WebSocket-Connection-Id"
+
+// The header containing the route key of requests derived from API Gateway WebSocket API events.
+const WEBSOCKET_ROUTE_KEY_HEADER string = "X-Lambda-WebSocket-Route-Key"
+
+// The header containing the event type ("CONNECT", "MESSAGE" or "DISCONNECT") of requests derived from API Gateway WebSocket API events.
+const WEBSOCKET_EVENT_TYPE_HEADER string = "X-Lambda-WebSocket-Event-Type"
+
+// webSocketSenderContextKey is the key used to store a `WebSocketSender` instance in a `context.Context`.
+type webSocketSenderContextKey struct{}
+
+func init() {
+	ctx := context.Background()
+	RegisterServer(ctx, "lambdawebsocket", NewLambdaWebSocketServer)
+}
+
+// LambdaWebSocketServer implements the `Server` interface for a use in a AWS Lambda + API Gateway WebSocket API context.
+// WebSocket events are translated in to HTTP requests, on configurable paths, and the resulting HTTP responses back in to
+// WebSocket route responses.
+type LambdaWebSocketServer struct {
+	Server
+	url             *url.URL
+	connect_path    string
+	disconnect_path string
+	default_path    string
+	route_path      string
+	sender          WebSocketSender
+	logger          *slog.Logger
+}
+
+// NewLambdaWebSocketServer returns a new `LambdaWebSocketServer` instance configured by 'uri' which is
+// expected to be defined in the form of:
+//
+//	lambdawebsocket://?{PARAMETERS}
+//
+// Valid parameters are:
+// * `connect_path={PATH}` The path that "$connect" events are sent to, as GET requests. Default is "/websocket/connect".
+// * `disconnect_path={PATH}` The path that "$disconnect" events are sent to, as POST requests. Default is "/websocket/disconnect".
+// * `default_path={PATH}` The path that "$default" events are sent to, as POST requests. Default is "/websocket/default".
+// * `route_path={PATH}` The path prefix that events for custom routes are sent to, as POST requests to "{PATH}/{ROUTE_KEY}". Default is "/websocket/routes".
+// * `sender={URI}` The URI of the `WebSocketSender` instance, created using `NewWebSocketSender`, made available to handlers using `WebSocketSenderFromContext`.
+func NewLambdaWebSocketServer(ctx context.Context, uri string) (Server, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	server := LambdaWebSocketServer{
+		url:             u,
+		connect_path:    "/websocket/connect",
+		disconnect_path: "/websocket/disconnect",
+		default_path:    "/websocket/default",
+		route_path:      "/websocket/routes",
+		logger:          LoggerFromContext(ctx),
+	}
+
+	paths := map[string]*string{
+		"connect_path":    &server.connect_path,
+		"disconnect_path": &server.disconnect_path,
+		"default_path":    &server.default_path,
+		"route_path":      &server.route_path,
+	}
+
+	for k, path := range paths {
+
+		if !q.Has(k) {
+			continue
+		}
+
+		v := q.Get(k)
+
+		if !strings.HasPrefix(v, "/") {
+			return nil, fmt.Errorf("Invalid %s parameter, %s (must start with '/')", k, v)
+		}
+
+		*path = v
+	}
+
+	server.route_path = strings.TrimRight(server.route_path, "/")
+
+	if q.Has("sender") {
+
+		sender, err := NewWebSocketSender(ctx, q.Get("sender"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create WebSocket sender, %w", err)
+		}
+
+		server.sender = sender
+	}
+
+	return &server, nil
+}
+
+// Address returns the fully-qualified URL used to instantiate 's'.
+func (s *LambdaWebSocketServer) Address() string {
+	return s.url.String()
+}
+
+// ListenAndServe starts the serve and listens for requests using 'mux' for routing.
+func (s *LambdaWebSocketServer) ListenAndServe(ctx context.Context, mux http.Handler) error {
+
+	lambda_ctx := WithLogger(ctx, s.logger)
+
+	lambda.StartWithOptions(s.lambdaHandlerFunc(mux), lambda.WithContext(lambda_ctx))
+	return nil
+}
+
+// LambdaHandler returns the `lambda.Handler` instance used by 's' to translate API Gateway WebSocket API events
+// in to HTTP requests served by 'mux' and the resulting HTTP responses back in to Lambda responses.
+func (s *LambdaWebSocketServer) LambdaHandler(mux http.Handler) lambda.Handler {
+	return lambda.NewHandler(s.lambdaHandlerFunc(mux))
+}
+
+// lambdaHandlerFunc returns the Lambda handler function for 's' which runs any functions queued with `AfterResponse`
+// after the response has been posted to the Lambda Runtime API.
+func (s *LambdaWebSocketServer) lambdaHandlerFunc(mux http.Handler) func(context.Context, events.APIGatewayWebsocketProxyRequest) (io.Reader, error) {
+
+	fn := func(ctx context.Context, event events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return s.handleRequest(ctx, mux, event)
+	}
+
+	return withAfterResponse(fn, s.logger)
+}
+
+// Path returns the path that events for 'route_key' are sent to.
+func (s *LambdaWebSocketServer) Path(route_key string) string {
+
+	switch route_key {
+	case WEBSOCKET_ROUTE_CONNECT:
+		return s.connect_path
+	case WEBSOCKET_ROUTE_DISCONNECT:
+		return s.disconnect_path
+	case WEBSOCKET_ROUTE_DEFAULT:
+		return s.default_path
+	default:
+		return s.route_path + "/" + url.PathEscape(route_key)
+	}
+}
+
+// handleRequest serves the HTTP request derived from 'event' using 'mux' and returns the resulting route response. Responses
+// to "$connect" events with a status code outside of the 2xx range reject the connection.
+func (s *LambdaWebSocketServer) handleRequest(ctx context.Context, mux http.Handler, event events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	var rsp events.APIGatewayProxyResponse
+
+	req_ctx := event.RequestContext
+
+	if req_ctx.ConnectionID == "" || req_ctx.RouteKey == "" {
+		return rsp, fmt.Errorf("Unsupported event, missing WebSocket connection ID or route key")
+	}
+
+	req, err := s.newHTTPRequest(ctx, event)
+
+	if err != nil {
+		return rsp, err
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	rsp.StatusCode = rec.Code
+	rsp.MultiValueHeaders = rec.Header()
+
+	body := rec.Body.Bytes()
+
+	if utf8.Valid(body) {
+		rsp.Body = string(body)
+	} else {
+		rsp.Body = base64.StdEncoding.EncodeToString(body)
+		rsp.IsBase64Encoded = true
+	}
+
+	if rsp.StatusCode >= 400 {
+		s.logger.Warn("WebSocket route returned an error", "route_key", req_ctx.RouteKey, "connection_id", req_ctx.ConnectionID, "path", req.URL.Path, "status", rsp.StatusCode)
+	}
+
+	return rsp, nil
+}
+
+// newHTTPRequest returns a new `http.Request` derived from 'event'.
+func (s *LambdaWebSocketServer) newHTTPRequest(ctx context.Context, event events.APIGatewayWebsocketProxyRequest) (*http.Request, error) {
+
+	req_ctx := event.RequestContext
+
+	ctx, err := withLambdaEvent(ctx, event)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.sender != nil {
+		ctx = context.WithValue(ctx, webSocketSenderContextKey{}, s.sender)
+	}
+
+	u := &url.URL{
+		Path: s.Path(req_ctx.RouteKey),
+	}
+
+	q := url.Values{}
+
+	for k, v := range event.QueryStringParameters {
+		q.Set(k, v)
+	}
+
+	for k, values := range event.MultiValueQueryStringParameters {
+		q[k] = values
+	}
+
+	u.RawQuery = q.Encode()
+
+	body := []byte(event.Body)
+
+	if event.IsBase64Encoded {
+
+		body, err = base64.StdEncoding.DecodeString(event.Body)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode body, %w", err)
+		}
+	}
+
+	method := http.MethodPost
+
+	if req_ctx.RouteKey == WEBSOCKET_ROUTE_CONNECT {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request, %w", err)
+	}
+
+	req.RequestURI = u.RequestURI()
+	req.Header = newLambdaHeader(event.Headers, event.MultiValueHeaders)
+	req.Host = req_ctx.DomainName
+
+	if req.Header.Get("Host") != "" {
+		req.Host = req.Header.Get("Host")
+		req.Header.Del("Host")
+	}
+
+	if req.Header.Get("Content-Type") == "" && len(body) > 0 {
+		req.Header.Set("Content-Type", lambdaEventContentType(body))
+	}
+
+	// The client's port is not included in WebSocket events but handlers (and net/http) expect RemoteAddr
+	// to be in the form of "{IP}:{PORT}" so use port 0.
+
+	if req_ctx.Identity.SourceIP != "" {
+		req.RemoteAddr = net.JoinHostPort(req_ctx.Identity.SourceIP, "0")
+	}
+
+	req.Header.Set(WEBSOCKET_CONNECTION_ID_HEADER, req_ctx.ConnectionID)
+	req.Header.Set(WEBSOCKET_ROUTE_KEY_HEADER, req_ctx.RouteKey)
+	req.Header.Set(WEBSOCKET_EVENT_TYPE_HEADER, req_ctx.EventType)
+
+	return req, nil
+}
+
+// WebSocketRequestFromContext returns the API Gateway WebSocket API event that the request associated with 'ctx' was derived from.
+func WebSocketRequestFromContext(ctx context.Context) (events.APIGatewayWebsocketProxyRequest, bool) {
+	ev, ok := ctx.Value(lambdaEventContextKey{}).(events.APIGatewayWebsocketProxyRequest)
+	return ev, ok
+}
+
+// WebSocketConnectionIDFromContext returns the API Gateway WebSocket API connection ID of the request associated with 'ctx'.
+func WebSocketConnectionIDFromContext(ctx context.Context) (string, bool) {
+
+	ev, ok := WebSocketRequestFromContext(ctx)
+
+	if !ok || ev.RequestContext.ConnectionID == "" {
+		return "", false
+	}
+
+	return ev.RequestContext.ConnectionID, true
+}
+
+// WebSocketSenderFromContext returns the `WebSocketSender` instance that the `lambdawebsocket://` server serving the request
+// associated with 'ctx' was configured with, using the `sender` parameter.
+func WebSocketSenderFromContext(ctx context.Context) (WebSocketSender, bool) {
+	sender, ok := ctx.Value(webSocketSenderContextKey{}).(WebSocketSender)
+	return sender, ok
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestLambdaWebSocketServer(t *testing.T) {
+
+	ctx := context.Background()
+
+	// Memory senders with the same name are shared so use a unique name in case the test is run more than once
+
+	sender_uri := "memory://TestLambdaWebSocketServer" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	s, err := NewServer(ctx, "lambdawebsocket://?route_path=/ws/&sender="+sender_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /websocket/connect", func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.URL.Query().Get("token") != "secret" {
+			http.Error(rsp, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if req.RemoteAddr != "203.0.113.1:0" {
+			http.Error(rsp, "Unexpected remote address "+req.RemoteAddr, http.StatusInternalServerError)
+			return
+		}
+
+		rsp.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc("POST /ws/sendMessage", func(rsp http.ResponseWriter, req *http.Request) {
+
+		connection_id, ok := WebSocketConnectionIDFromContext(req.Context())
+
+		if !ok || connection_id != req.Header.Get(WEBSOCKET_CONNECTION_ID_HEADER) {
+			http.Error(rsp, "Missing connection ID", http.StatusInternalServerError)
+			return
+		}
+
+		sender, ok := WebSocketSenderFromContext(req.Context())
+
+		if !ok {
+			http.Error(rsp, "Missing sender", http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := io.ReadAll(req.Body)
+
+		err := sender.PostToConnection(req.Context(), connection_id, body)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		rsp.Write([]byte("sent"))
+	})
+
+	mux.HandleFunc("POST /websocket/disconnect", func(rsp http.ResponseWriter, req *http.Request) {
+
+		id, ok := LambdaIdentityFromContext(req.Context())
+
+		if !ok || id.EventType != LAMBDA_EVENT_WEBSOCKET || id.ConnectionID != "abc=" {
+			http.Error(rsp, "Invalid identity", http.StatusInternalServerError)
+			return
+		}
+
+		rsp.WriteHeader(http.StatusNoContent)
+	})
+
+	lambda_handler := s.(*LambdaWebSocketServer).LambdaHandler(mux)
+
+	newEvent := func(route_key string, event_type string, body string, query map[string]string) []byte {
+
+		ev := events.APIGatewayWebsocketProxyRequest{
+			Body:                  body,
+			QueryStringParameters: query,
+		}
+
+		ev.RequestContext.ConnectionID = "abc="
+		ev.RequestContext.RouteKey = route_key
+		ev.RequestContext.EventType = event_type
+		ev.RequestContext.DomainName = "example.execute-api.us-east-1.amazonaws.com"
+		ev.RequestContext.Identity.SourceIP = "203.0.113.1"
+
+		enc, _ := json.Marshal(ev)
+		return enc
+	}
+
+	tests := []struct {
+		Event  []byte
+		Status int
+		Body   string
+	}{
+		{newEvent(WEBSOCKET_ROUTE_CONNECT, "CONNECT", "", nil), http.StatusForbidden, "Forbidden\n"},
+		{newEvent(WEBSOCKET_ROUTE_CONNECT, "CONNECT", "", map[string]string{"token": "secret"}), http.StatusOK, ""},
+		{newEvent("sendMessage", "MESSAGE", "hello", nil), http.StatusOK, "sent"},
+		{newEvent(WEBSOCKET_ROUTE_DEFAULT, "MESSAGE", "hello", nil), http.StatusNotFound, "404 page not found\n"},
+		{newEvent(WEBSOCKET_ROUTE_DISCONNECT, "DISCONNECT", "", nil), http.StatusNoContent, ""},
+	}
+
+	for i, test := range tests {
+
+		rsp_body, err := lambda_handler.Invoke(ctx, test.Event)
+
+		if err != nil {
+			t.Fatalf("Failed to invoke handler for test %d, %v", i, err)
+		}
+
+		var rsp events.APIGatewayProxyResponse
+
+		err = json.Unmarshal(rsp_body, &rsp)
+
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response for test %d, %v", i, err)
+		}
+
+		if rsp.StatusCode != test.Status || rsp.Body != test.Body {
+			t.Fatalf("Unexpected response for test %d: %d '%s'", i, rsp.StatusCode, rsp.Body)
+		}
+	}
+
+	sender, _ := NewWebSocketSender(ctx, sender_uri)
+	messages := sender.(*MemoryWebSocketSender).Messages("abc=")
+
+	if len(messages) != 1 || string(messages[0]) != "hello" {
+		t.Fatalf("Unexpected messages: %v", messages)
+	}
+
+	_, err = lambda_handler.Invoke(ctx, []byte(`{"version":"2.0","rawPath":"/"}`))
+
+	if err == nil {
+		t.Fatalf("Expected non-WebSocket event to fail")
+	}
+}
@@ -0,0 +1,371 @@
+// Package lambdatest provides a local stand-in for the AWS Lambda Runtime API so that the `ListenAndServe` methods
+// of the `lambda://` and `functionurl://` servers can be tested without AWS. For example:
+//
+//	func TestMyHandler(t *testing.T) {
+//
+//		rt := lambdatest.NewRuntime(t)
+//
+//		s, _ := server.NewServer(ctx, "functionurl://")
+//		go s.ListenAndServe(ctx, mux)
+//
+//		inv, _ := rt.Invoke(ctx, []byte(`{"version":"2.0","rawPath":"/", ...}`))
+//		rsp, _ := inv.HTTPResponse()
+//	}
+//
+// The Lambda runtime loop started by `ListenAndServe` exits the process if the Runtime API ever returns an error or can not
+// be reached, so a `Runtime` never returns errors and keeps listening until the test binary exits. Once a test completes any
+// outstanding request for the next event is left blocked, which leaves the runtime loop (and its goroutine) parked.
+package lambdatest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aaronland/go-http-server/v2"
+)
+
+// The version of the Lambda Runtime API implemented by `Runtime`.
+const API_VERSION string = "2018-06-01"
+
+// The default function ARN assigned to invocations.
+const FUNCTION_ARN string = "arn:aws:lambda:local:000000000000:function:lambdatest"
+
+// The default amount of time a function has to handle an invocation.
+const DEFAULT_TIMEOUT time.Duration = 30 * time.Second
+
+// Runtime implements a local stand-in for the AWS Lambda Runtime API. It queues events, hands them to the Lambda
+// runtime loop when it asks for the next invocation and collects the responses (or errors) that are posted back.
+type Runtime struct {
+	// FunctionARN is the function ARN assigned to invocations. Default is `FUNCTION_ARN`.
+	FunctionARN string
+	// Timeout is the amount of time a function has to handle an invocation, which determines the deadline of the context
+	// passed to the handler. Default is `DEFAULT_TIMEOUT`.
+	Timeout     time.Duration
+	listener    net.Listener
+	http_server *http.Server
+	queue       chan *Invocation
+	mu          *sync.Mutex
+	pending     map[string]*Invocation
+	completed   []*Invocation
+	init_error  []byte
+}
+
+// Invocation is a single event sent to the Lambda runtime loop by a `Runtime` instance and the result of handling it.
+type Invocation struct {
+	// ID is the AWS request ID of the invocation.
+	ID string
+	// Payload is the event sent to the function.
+	Payload []byte
+	// Response is the body of the response posted by the function. It is empty if the function returned an error.
+	Response []byte
+	// ContentType is the content type of the response, or error, posted by the function.
+	ContentType string
+	// Error is the body of the error posted by the function, if any.
+	Error []byte
+	// ErrorType is the type of the error posted by the function, if any. It is derived from the "Lambda-Runtime-Function-Error-Type"
+	// header or trailer (for errors which occur while streaming a response) or the "errorType" property of the error.
+	ErrorType string
+	// Started is the time the runtime loop received the invocation.
+	Started time.Time
+	// Completed is the time the runtime loop posted the response, or error, for the invocation.
+	Completed time.Time
+	// NextPoll is the time the runtime loop asked for the next event after completing the invocation. The Lambda
+	// service considers an invocation finished, and may freeze the function, at this point rather than when the
+	// response is posted.
+	NextPoll time.Time
+	done     chan struct{}
+	polled   chan struct{}
+}
+
+// NewRuntime starts a new `Runtime` instance, listening on a random local port, and sets the `AWS_LAMBDA_RUNTIME_API`
+// environment variable for the duration of 't' so that the Lambda runtime loop started by `lambda.Start` (and by the
+// `ListenAndServe` methods of the `lambda://` and `functionurl://` servers) connects to it.
+func NewRuntime(t testing.TB) *Runtime {
+
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Failed to create listener for Lambda runtime, %v", err)
+	}
+
+	rt := &Runtime{
+		FunctionARN: FUNCTION_ARN,
+		Timeout:     DEFAULT_TIMEOUT,
+		listener:    l,
+		queue:       make(chan *Invocation, 1024),
+		mu:          new(sync.Mutex),
+		pending:     make(map[string]*Invocation),
+		completed:   make([]*Invocation, 0),
+	}
+
+	prefix := "/" + API_VERSION + "/runtime"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+prefix+"/invocation/next", rt.handleNext)
+	mux.HandleFunc("POST "+prefix+"/invocation/{id}/response", rt.handleResponse)
+	mux.HandleFunc("POST "+prefix+"/invocation/{id}/error", rt.handleError)
+	mux.HandleFunc("POST "+prefix+"/init/error", rt.handleInitError)
+
+	rt.http_server = &http.Server{
+		Handler: mux,
+	}
+
+	go rt.http_server.Serve(l)
+
+	t.Setenv("AWS_LAMBDA_RUNTIME_API", rt.Address())
+
+	// Ensure the (RPC) runtime loop for the legacy Go runtime is not used instead
+
+	t.Setenv("_LAMBDA_SERVER_PORT", "")
+
+	return rt
+}
+
+// Address returns the host and port, suitable for use as the value of `AWS_LAMBDA_RUNTIME_API`, that 'rt' listens on.
+func (rt *Runtime) Address() string {
+	return rt.listener.Addr().String()
+}
+
+// Enqueue adds 'payload' to the queue of events to send to the runtime loop and returns the corresponding `Invocation`.
+func (rt *Runtime) Enqueue(payload []byte) *Invocation {
+
+	inv := &Invocation{
+		ID:      newRequestID(),
+		Payload: payload,
+		done:    make(chan struct{}),
+		polled:  make(chan struct{}),
+	}
+
+	rt.queue <- inv
+	return inv
+}
+
+// Invoke adds 'payload' to the queue of events to send to the runtime loop and waits for the function to
+// post a response, or an error, for it.
+func (rt *Runtime) Invoke(ctx context.Context, payload []byte) (*Invocation, error) {
+
+	inv := rt.Enqueue(payload)
+
+	err := inv.Wait(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+// Completed returns the list of invocations that have been completed, in the order they were completed.
+func (rt *Runtime) Completed() []*Invocation {
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	completed := make([]*Invocation, len(rt.completed))
+	copy(completed, rt.completed)
+
+	return completed
+}
+
+// InitError returns the body of the initialization error posted by the function, if any.
+func (rt *Runtime) InitError() []byte {
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	return rt.init_error
+}
+
+// Wait waits for the function to post a response, or an error, for 'inv'.
+func (inv *Invocation) Wait(ctx context.Context) error {
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("Invocation %s did not complete, %w", inv.ID, ctx.Err())
+	case <-inv.done:
+		return nil
+	}
+}
+
+// WaitForNextPoll waits for the runtime loop to ask for the next event after completing 'inv'. Since the
+// runtime loop only asks for the next event once it has finished with the current one this can be used
+// to check that work is completed before the function would be frozen.
+func (inv *Invocation) WaitForNextPoll(ctx context.Context) error {
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("Runtime loop did not poll for the next event after invocation %s, %w", inv.ID, ctx.Err())
+	case <-inv.polled:
+		return nil
+	}
+}
+
+// HTTPResponse returns the response posted by the function as an `http.Response` instance. The response is expected
+// to be for an API Gateway v1, v2, ALB or Function URL (buffered or streaming) event.
+func (inv *Invocation) HTTPResponse() (*http.Response, error) {
+
+	if inv.Error != nil {
+		return nil, fmt.Errorf("Invocation %s failed (%s), %s", inv.ID, inv.ErrorType, inv.Error)
+	}
+
+	return server.NewLambdaHTTPResponse(inv.Response)
+}
+
+func (rt *Runtime) handleNext(rsp http.ResponseWriter, req *http.Request) {
+
+	// Asking for the next event completes any invocations that have already posted a response
+
+	now := time.Now()
+
+	rt.mu.Lock()
+
+	for _, inv := range rt.completed {
+
+		if inv.NextPoll.IsZero() {
+			inv.NextPoll = now
+			close(inv.polled)
+		}
+	}
+
+	rt.mu.Unlock()
+
+	// Block until there is an event to send. If the runtime has been closed this will block forever
+	// (or at least until the test binary exits) which is the point: returning an error would cause
+	// the Lambda runtime loop to exit the process.
+
+	inv := <-rt.queue
+
+	inv.Started = time.Now()
+	deadline := inv.Started.Add(rt.Timeout)
+
+	rt.mu.Lock()
+	rt.pending[inv.ID] = inv
+	rt.mu.Unlock()
+
+	trace_id := newRequestID()
+
+	rsp.Header().Set("Lambda-Runtime-Aws-Request-Id", inv.ID)
+	rsp.Header().Set("Lambda-Runtime-Deadline-Ms", strconv.FormatInt(deadline.UnixMilli(), 10))
+	rsp.Header().Set("Lambda-Runtime-Invoked-Function-Arn", rt.FunctionARN)
+	rsp.Header().Set("Lambda-Runtime-Trace-Id", fmt.Sprintf("Root=1-%x-%s;Sampled=0", inv.Started.Unix(), strings.ReplaceAll(trace_id, "-", "")[0:24]))
+	rsp.Header().Set("Content-Type", "application/json")
+
+	rsp.WriteHeader(http.StatusOK)
+	rsp.Write(inv.Payload)
+}
+
+func (rt *Runtime) handleResponse(rsp http.ResponseWriter, req *http.Request) {
+
+	body, err := io.ReadAll(req.Body)
+
+	if err != nil {
+		body = []byte(err.Error())
+	}
+
+	rt.complete(req.PathValue("id"), func(inv *Invocation) {
+
+		inv.ContentType = req.Header.Get("Content-Type")
+
+		// Errors which occur while streaming a response are reported using trailers
+
+		error_type := req.Trailer.Get("Lambda-Runtime-Function-Error-Type")
+
+		if error_type != "" {
+			inv.ErrorType = error_type
+			inv.Error = []byte(req.Trailer.Get("Lambda-Runtime-Function-Error-Body"))
+			return
+		}
+
+		inv.Response = body
+	})
+
+	rsp.WriteHeader(http.StatusAccepted)
+}
+
+func (rt *Runtime) handleError(rsp http.ResponseWriter, req *http.Request) {
+
+	body, err := io.ReadAll(req.Body)
+
+	if err != nil {
+		body = []byte(err.Error())
+	}
+
+	rt.complete(req.PathValue("id"), func(inv *Invocation) {
+		inv.ContentType = req.Header.Get("Content-Type")
+		inv.ErrorType = req.Header.Get("Lambda-Runtime-Function-Error-Type")
+		inv.Error = body
+
+		if inv.ErrorType == "" {
+
+			var lambda_err struct {
+				ErrorType string `json:"errorType"`
+			}
+
+			if json.Unmarshal(body, &lambda_err) == nil {
+				inv.ErrorType = lambda_err.ErrorType
+			}
+		}
+	})
+
+	rsp.WriteHeader(http.StatusAccepted)
+}
+
+func (rt *Runtime) handleInitError(rsp http.ResponseWriter, req *http.Request) {
+
+	body, err := io.ReadAll(req.Body)
+
+	if err != nil {
+		body = []byte(err.Error())
+	}
+
+	rt.mu.Lock()
+	rt.init_error = body
+	rt.mu.Unlock()
+
+	rsp.WriteHeader(http.StatusAccepted)
+}
+
+// complete applies 'fn' to the pending invocation with 'id' and marks it as completed. Unknown
+// invocations are ignored rather than reported as errors, for the reasons described in handleNext.
+func (rt *Runtime) complete(id string, fn func(inv *Invocation)) {
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	inv, ok := rt.pending[id]
+
+	if !ok {
+		return
+	}
+
+	delete(rt.pending, id)
+
+	fn(inv)
+
+	inv.Completed = time.Now()
+	rt.completed = append(rt.completed, inv)
+
+	close(inv.done)
+}
+
+func newRequestID() string {
+
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	id := hex.EncodeToString(b)
+	return strings.Join([]string{id[0:8], id[8:12], id[12:16], id[16:20], id[20:]}, "-")
+}
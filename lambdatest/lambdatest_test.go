@@ -0,0 +1,234 @@
+package lambdatest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aaronland/go-http-server/v2"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func newHandler() http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		id, ok := server.LambdaIdentityFromContext(req.Context())
+
+		if !ok {
+			http.Error(rsp, "Missing identity", http.StatusInternalServerError)
+			return
+		}
+
+		rsp.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(rsp, "%s %s %s", id.EventType, req.URL.Path, id.InvokedFunctionARN)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func TestRuntime(t *testing.T) {
+
+	v2 := events.APIGatewayV2HTTPRequest{
+		Version: "2.0",
+		RawPath: "/v2",
+	}
+
+	v2.RequestContext.HTTP.Method = http.MethodGet
+
+	fu := events.LambdaFunctionURLRequest{
+		Version: "2.0",
+		RawPath: "/fu",
+	}
+
+	fu.RequestContext.HTTP.Method = http.MethodGet
+
+	tests := []struct {
+		ServerURI string
+		Event     any
+		Expected  string
+	}{
+		{"lambda://", v2, "apigateway_v2 /v2 " + FUNCTION_ARN},
+		{"functionurl://", fu, "functionurl /fu " + FUNCTION_ARN},
+		{"functionurl://?invoke_mode=response_stream", fu, "functionurl /fu " + FUNCTION_ARN},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.ServerURI, func(t *testing.T) {
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			rt := NewRuntime(t)
+
+			s, err := server.NewServer(ctx, test.ServerURI)
+
+			if err != nil {
+				t.Fatalf("Failed to create server, %v", err)
+			}
+
+			go s.ListenAndServe(ctx, newHandler())
+
+			payload, err := json.Marshal(test.Event)
+
+			if err != nil {
+				t.Fatalf("Failed to marshal event, %v", err)
+			}
+
+			// Send two events to make sure the runtime loop keeps polling
+
+			for i := 0; i < 2; i++ {
+
+				inv, err := rt.Invoke(ctx, payload)
+
+				if err != nil {
+					t.Fatalf("Failed to invoke function, %v", err)
+				}
+
+				rsp, err := inv.HTTPResponse()
+
+				if err != nil {
+					t.Fatalf("Failed to derive HTTP response, %v", err)
+				}
+
+				body, err := io.ReadAll(rsp.Body)
+
+				if err != nil {
+					t.Fatalf("Failed to read body, %v", err)
+				}
+
+				if rsp.StatusCode != http.StatusOK || string(body) != test.Expected {
+					t.Fatalf("Unexpected response: %d '%s'", rsp.StatusCode, body)
+				}
+
+				err = inv.WaitForNextPoll(ctx)
+
+				if err != nil {
+					t.Fatalf("Failed to wait for next poll, %v", err)
+				}
+
+				if inv.NextPoll.Before(inv.Completed) {
+					t.Fatalf("Expected next poll to follow completion")
+				}
+			}
+
+			if len(rt.Completed()) != 2 {
+				t.Fatalf("Unexpected number of completed invocations: %d", len(rt.Completed()))
+			}
+		})
+	}
+}
+
+func TestRuntimeError(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rt := NewRuntime(t)
+
+	s, err := server.NewServer(ctx, "lambda://")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	go s.ListenAndServe(ctx, newHandler())
+
+	// Not a supported event type so algnhsa will return an error
+
+	inv, err := rt.Invoke(ctx, []byte(`{"hello":"world"}`))
+
+	if err != nil {
+		t.Fatalf("Failed to invoke function, %v", err)
+	}
+
+	if inv.Error == nil || inv.ErrorType == "" {
+		t.Fatalf("Expected invocation to fail")
+	}
+
+	_, err = inv.HTTPResponse()
+
+	if err == nil {
+		t.Fatalf("Expected HTTPResponse to fail")
+	}
+}
+
+func TestRuntimeAfterResponse(t *testing.T) {
+
+	fu := events.LambdaFunctionURLRequest{
+		Version: "2.0",
+		RawPath: "/fu",
+	}
+
+	fu.RequestContext.HTTP.Method = http.MethodGet
+
+	for _, uri := range []string{"lambda://", "functionurl://", "functionurl://?invoke_mode=response_stream"} {
+
+		t.Run(uri, func(t *testing.T) {
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			rt := NewRuntime(t)
+
+			s, err := server.NewServer(ctx, uri)
+
+			if err != nil {
+				t.Fatalf("Failed to create server, %v", err)
+			}
+
+			finished := make(chan time.Time, 1)
+
+			handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+				server.AfterResponse(req.Context(), func(ctx context.Context) {
+					time.Sleep(100 * time.Millisecond)
+					finished <- time.Now()
+				})
+
+				rsp.Write([]byte("ok"))
+			}
+
+			go s.ListenAndServe(ctx, http.HandlerFunc(handler))
+
+			payload, err := json.Marshal(fu)
+
+			if err != nil {
+				t.Fatalf("Failed to marshal event, %v", err)
+			}
+
+			inv, err := rt.Invoke(ctx, payload)
+
+			if err != nil {
+				t.Fatalf("Failed to invoke function, %v", err)
+			}
+
+			if inv.Error != nil {
+				t.Fatalf("Invocation failed, %s", inv.Error)
+			}
+
+			err = inv.WaitForNextPoll(ctx)
+
+			if err != nil {
+				t.Fatalf("Failed to wait for next poll, %v", err)
+			}
+
+			var t_finished time.Time
+
+			select {
+			case t_finished = <-finished:
+			default:
+				t.Fatalf("Expected after response function to complete before the next poll")
+			}
+
+			if t_finished.Before(inv.Completed) || t_finished.After(inv.NextPoll) {
+				t.Fatalf("Expected after response function to complete after the response was posted (%v) and before the next poll (%v), completed %v", inv.Completed, inv.NextPoll, t_finished)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"log"
+	"log/slog"
+)
+
+// loggerContextKey is the key used to store a `*slog.Logger` instance in a `context.Context`.
+type loggerContextKey struct{}
+
+// WithLogger returns a copy of 'ctx' that stores 'logger'. Servers created with the resulting context, using `NewServer`,
+// will use 'logger' for all of their logging and will make it available to the handlers they serve through the request
+// context. For example:
+//
+//	ctx = server.WithLogger(ctx, slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+//	s, err := server.NewServer(ctx, "http://localhost:8080")
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// LoggerFromContext returns the `*slog.Logger` instance stored in 'ctx' by `WithLogger`. If there is no logger
+// then the value of `slog.Default()` is returned.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+
+	logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger)
+
+	if !ok || logger == nil {
+		return slog.Default()
+	}
+
+	return logger
+}
+
+// newErrorLog returns a `*log.Logger` instance, suitable for use as the `ErrorLog` property of an `http.Server`
+// instance, which writes to 'logger' at the error level.
+func newErrorLog(logger *slog.Logger) *log.Logger {
+	return slog.NewLogLogger(logger.Handler(), slog.LevelError)
+}
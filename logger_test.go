@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLoggerFromContext(t *testing.T) {
+
+	ctx := context.Background()
+
+	if LoggerFromContext(ctx) != slog.Default() {
+		t.Fatalf("Expected default logger")
+	}
+
+	logger := slog.New(slog.NewTextHandler(new(bytes.Buffer), nil))
+	ctx = WithLogger(ctx, logger)
+
+	if LoggerFromContext(ctx) != logger {
+		t.Fatalf("Expected logger from context")
+	}
+}
+
+func TestServerLogger(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctx = WithLogger(ctx, logger)
+
+	s, err := NewServer(ctx, "memory://logger")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	// Ensure that errors logged by the underlying http.Server end up in the structured logs
+
+	s.(*MemoryServer).http_server.ErrorLog.Print("http: TLS handshake error")
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+		LoggerFromContext(req.Context()).Info("Handle request", "path", req.URL.Path)
+	}
+
+	go s.ListenAndServe(ctx, http.HandlerFunc(handler))
+
+	rsp, err := NewMemoryClient("logger").Get("http://logger/test")
+
+	if err != nil {
+		t.Fatalf("Failed to GET request, %v", err)
+	}
+
+	rsp.Body.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != 2 {
+		t.Fatalf("Unexpected number of log records: %d", len(lines))
+	}
+
+	expected := []map[string]string{
+		{"level": "ERROR", "msg": "http: TLS handshake error"},
+		{"level": "INFO", "msg": "Handle request", "path": "/test"},
+	}
+
+	for idx, ln := range lines {
+
+		var rec map[string]any
+
+		err := json.Unmarshal([]byte(ln), &rec)
+
+		if err != nil {
+			t.Fatalf("Failed to unmarshal log record, %v", err)
+		}
+
+		for k, v := range expected[idx] {
+
+			if rec[k] != v {
+				t.Fatalf("Unexpected value for '%s' in log record %d: %v", k, idx, rec[k])
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+func init() {
+	ctx := context.Background()
+	RegisterServer(ctx, "memory", NewMemoryServer)
+}
+
+// ErrMemoryServerNotFound is returned when dialing a `MemoryServer` name that has not been registered.
+var ErrMemoryServerNotFound = errors.New("Memory server not found")
+
+// Local registry of in-memory listeners, keyed by name
+var memory_listeners = new(sync.Map)
+
+// MemoryServer implements the `Server` interface for an HTTP server that listens for connections
+// over an in-process listener, rather than a network socket. It is principally meant for testing
+// and should be used with a client created by `NewMemoryClient` or `NewMemoryTransport`.
+type MemoryServer struct {
+	Server
+	url         *url.URL
+	name        string
+	listener    *memoryListener
+	http_server *http.Server
+	logger      *slog.Logger
+	// mu guards 'serving' and 'closed'.
+	mu      sync.Mutex
+	serving bool
+	closed  bool
+	// close_ch is closed by `Close` to shut down a server that is being served.
+	close_ch   chan struct{}
+	close_once sync.Once
+}
+
+// NewMemoryServer returns a new `MemoryServer` instance configured by 'uri' which is
+// expected to be defined in the form of:
+//
+//	memory://{NAME}?{PARAMETERS}
+//
+// Where {NAME} is a unique name used by clients to connect to the server. Valid parameters are the same
+// timeout parameters defined by `NewHTTPServer`.
+//
+// The name is reserved as soon as the server is created, so clients may connect before `ListenAndServe`
+// is invoked; those connections will be served once it has been. The name is released when `ListenAndServe`
+// returns or when the server's `Close` method is invoked, whichever happens first. It is always safe to `defer s.Close()`.
+func NewMemoryServer(ctx context.Context, uri string) (Server, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	name := u.Host
+
+	if name == "" {
+		return nil, errors.New("Missing memory server name")
+	}
+
+	logger := LoggerFromContext(ctx)
+
+	srv, err := newHTTPServer(u, logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	listener := newMemoryListener(name)
+
+	_, exists := memory_listeners.LoadOrStore(name, listener)
+
+	if exists {
+		return nil, fmt.Errorf("Memory server '%s' already exists", name)
+	}
+
+	server := MemoryServer{
+		url:         u,
+		name:        name,
+		listener:    listener,
+		http_server: srv,
+		logger:      logger,
+		close_ch:    make(chan struct{}),
+	}
+
+	return &server, nil
+}
+
+// Address returns the fully-qualified URI where the server instance can be contacted.
+func (s *MemoryServer) Address() string {
+	return fmt.Sprintf("memory://%s", s.name)
+}
+
+// ListenAndServe starts the server and listens for requests using 'mux' for routing.
+func (s *MemoryServer) ListenAndServe(ctx context.Context, mux http.Handler) error {
+
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return fmt.Errorf("Memory server '%s' has been closed", s.name)
+	}
+
+	s.serving = true
+	s.mu.Unlock()
+
+	defer memory_listeners.CompareAndDelete(s.name, s.listener)
+	defer s.listener.Close()
+
+	done_ch := make(chan struct{})
+	defer close(done_ch)
+
+	shutdown_ch := make(chan struct{})
+
+	go func() {
+
+		sigint := make(chan os.Signal, 1)
+		signal.Notify(sigint, os.Interrupt)
+		defer signal.Stop(sigint)
+
+		select {
+		case <-sigint:
+		case <-ctx.Done():
+		case <-s.close_ch:
+		case <-done_ch:
+			return
+		}
+
+		// We received an interrupt signal, shut down.
+
+		err := s.http_server.Shutdown(context.Background())
+
+		if err != nil {
+			s.logger.Error("Failed to shut down server", "address", s.Address(), "error", err)
+		}
+
+		close(shutdown_ch)
+	}()
+
+	s.http_server.Handler = mux
+
+	err := s.http_server.Serve(s.listener)
+
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	// Wait for in-flight requests to drain
+
+	<-shutdown_ch
+	return nil
+}
+
+// Close releases the name reserved by 's' so that it may be used by another server. Clients waiting to connect
+// to 's' receive an `ErrMemoryServerNotFound` error. If 's' is being served it is shut down, as though the context
+// passed to `ListenAndServe` had been cancelled, and `ListenAndServe` returns once in-flight requests have completed.
+// Close may be invoked more than once and at any time.
+func (s *MemoryServer) Close() error {
+
+	s.mu.Lock()
+	s.closed = true
+	serving := s.serving
+	s.mu.Unlock()
+
+	memory_listeners.CompareAndDelete(s.name, s.listener)
+
+	s.close_once.Do(func() {
+		close(s.close_ch)
+	})
+
+	// A server that is being served closes its listener when it shuts down
+
+	if !serving {
+		return s.listener.Close()
+	}
+
+	return nil
+}
+
+// DialMemory opens a new connection to the `MemoryServer` instance registered as 'name'.
+func DialMemory(ctx context.Context, name string) (net.Conn, error) {
+
+	v, exists := memory_listeners.Load(name)
+
+	if !exists {
+		return nil, fmt.Errorf("%w, %s", ErrMemoryServerNotFound, name)
+	}
+
+	return v.(*memoryListener).dial(ctx)
+}
+
+// NewMemoryTransport returns a new `http.RoundTripper` instance that sends all requests, regardless of
+// their host, to the `MemoryServer` instance registered as 'name'.
+func NewMemoryTransport(name string) http.RoundTripper {
+
+	dial := func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		return DialMemory(ctx, name)
+	}
+
+	tr := &http.Transport{
+		DialContext: dial,
+	}
+
+	return tr
+}
+
+// NewMemoryClient returns a new `http.Client` instance that sends all requests, regardless of their host,
+// to the `MemoryServer` instance registered as 'name'. For example:
+//
+//	cl := server.NewMemoryClient("example")
+//	rsp, err := cl.Get("http://example/")
+func NewMemoryClient(name string) *http.Client {
+
+	cl := &http.Client{
+		Transport: NewMemoryTransport(name),
+	}
+
+	return cl
+}
+
+// memoryAddr implements the `net.Addr` interface for in-memory connections.
+type memoryAddr struct {
+	name string
+}
+
+func (a memoryAddr) Network() string {
+	return "memory"
+}
+
+func (a memoryAddr) String() string {
+	return a.name
+}
+
+// memoryListener implements the `net.Listener` interface for connections created by `net.Pipe`.
+type memoryListener struct {
+	name       string
+	conns      chan net.Conn
+	close_once sync.Once
+	closed     chan struct{}
+}
+
+func newMemoryListener(name string) *memoryListener {
+
+	l := &memoryListener{
+		name:   name,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+
+	return l
+}
+
+// dial creates a new `net.Pipe` pair and hands the server side to `Accept`, returning the client side.
+func (l *memoryListener) dial(ctx context.Context) (net.Conn, error) {
+
+	server_conn, client_conn := net.Pipe()
+
+	select {
+	case l.conns <- server_conn:
+		return client_conn, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("%w, %s", ErrMemoryServerNotFound, l.name)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+
+	l.close_once.Do(func() {
+		close(l.closed)
+	})
+
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return memoryAddr{name: l.name}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMemoryServer(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := NewServer(ctx, "memory://test")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	if s.Address() != "memory://test" {
+		t.Fatalf("Unexpected address: %s", s.Address())
+	}
+
+	_, err = NewServer(ctx, "memory://test")
+
+	if err == nil {
+		t.Fatalf("Expected duplicate memory server name to fail")
+	}
+
+	done_ch := make(chan error)
+
+	go func() {
+		done_ch <- s.ListenAndServe(ctx, testHandler())
+	}()
+
+	cl := NewMemoryClient("test")
+
+	for i := 0; i < 3; i++ {
+
+		rsp, err := cl.Get("http://test/")
+
+		if err != nil {
+			t.Fatalf("Failed to GET request, %v", err)
+		}
+
+		body, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to read response, %v", err)
+		}
+
+		if string(body) != "Hello world" {
+			t.Fatalf("Unexpected response: '%s'", string(body))
+		}
+	}
+
+	cancel()
+
+	select {
+	case err := <-done_ch:
+
+		if err != nil {
+			t.Fatalf("Server returned an error, %v", err)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for server to shut down")
+	}
+
+	_, err = DialMemory(context.Background(), "test")
+
+	if !errors.Is(err, ErrMemoryServerNotFound) {
+		t.Fatalf("Expected memory server to be unregistered, %v", err)
+	}
+}
+
+func TestMemoryServerClose(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "memory://close")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	err = s.(io.Closer).Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close server, %v", err)
+	}
+
+	_, err = DialMemory(ctx, "close")
+
+	if !errors.Is(err, ErrMemoryServerNotFound) {
+		t.Fatalf("Expected memory server to be unregistered, %v", err)
+	}
+
+	// The name can be reused once it has been released
+
+	s, err = NewServer(ctx, "memory://close")
+
+	if err != nil {
+		t.Fatalf("Failed to create server with released name, %v", err)
+	}
+
+	done_ch := make(chan error)
+
+	go func() {
+		done_ch <- s.ListenAndServe(ctx, testHandler())
+	}()
+
+	cl := NewMemoryClient("close")
+
+	rsp, err := cl.Get("http://close/")
+
+	if err != nil {
+		t.Fatalf("Failed to GET request, %v", err)
+	}
+
+	rsp.Body.Close()
+
+	// Closing a server that is being served shuts it down
+
+	err = s.(io.Closer).Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close server, %v", err)
+	}
+
+	select {
+	case err := <-done_ch:
+
+		if err != nil {
+			t.Fatalf("Server returned an error, %v", err)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for server to shut down")
+	}
+
+	err = s.(io.Closer).Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close server twice, %v", err)
+	}
+
+	err = s.ListenAndServe(ctx, testHandler())
+
+	if err == nil {
+		t.Fatalf("Expected closed server to fail to serve")
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/url"
 	"os"
 	"os/exec"
@@ -73,7 +73,9 @@ func NewMkCertServer(ctx context.Context, uri string) (Server, error) {
 		return nil, err
 	}
 
-	tls_cert, tls_key, err := mkCert(server_u, root)
+	logger := LoggerFromContext(ctx)
+
+	tls_cert, tls_key, err := mkCert(logger, server_u, root)
 
 	if err != nil {
 		return nil, err
@@ -90,9 +92,9 @@ func NewMkCertServer(ctx context.Context, uri string) (Server, error) {
 }
 
 // mkCert creates a new TLS certificate and key using the `mkcert` binary stored in 'root'.
-func mkCert(u *url.URL, root string) (string, string, error) {
+func mkCert(logger *slog.Logger, u *url.URL, root string) (string, string, error) {
 
-	err := mkCertInstall()
+	err := mkCertInstall(logger)
 
 	if err != nil {
 		return "", "", err
@@ -115,6 +117,8 @@ func mkCert(u *url.URL, root string) (string, string, error) {
 		host,
 	}
 
+	logger.Debug("Create TLS certificate", "host", host, "cert", cert_path, "key", key_path)
+
 	cmd := exec.Command(MKCERT, args...)
 	err = cmd.Run()
 
@@ -126,14 +130,14 @@ func mkCert(u *url.URL, root string) (string, string, error) {
 }
 
 // mkCertInstall ensures that the `mkcert` binary is present and can be executed.
-func mkCertInstall() error {
+func mkCertInstall(logger *slog.Logger) error {
 
 	// unfortunately there is no way from the CLI tool to check whether
 	// mkcert is installed and some of the built-in methods for testing
 	// state (in mkcert.go) are marked as private so there's no way to
 	// access them... (20200420/thisisaaronland)
 
-	log.Println("Checking whether mkcert is installed. If it is not you may be prompted for your password (in order to install certificate files")
+	logger.Info("Checking whether mkcert is installed. If it is not you may be prompted for your password (in order to install certificate files)")
 
 	cmd := exec.Command(MKCERT, "-install")
 	return cmd.Run()
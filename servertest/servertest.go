@@ -0,0 +1,573 @@
+// Package servertest provides a conformance test suite for `server.Server` implementations. It is meant to be
+// used by third-party implementations registered with `server.RegisterServer` to demonstrate that they behave
+// like the built-in implementations. For example:
+//
+//	func TestMyServer(t *testing.T) {
+//
+//		opts := &servertest.Options{
+//			URI: func(t *testing.T, params url.Values) string {
+//				return "my://localhost:9000?" + params.Encode()
+//			},
+//		}
+//
+//		servertest.RunSuite(t, NewMyServer, opts)
+//	}
+package servertest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aaronland/go-http-server/v2"
+)
+
+// The body returned by the handler for the "/" path.
+const HelloWorld string = "Hello world"
+
+// The size of the request body sent by the "LargeBody" check.
+const LargeBodySize int = 8 * 1024 * 1024
+
+// How long to wait for a server to start or stop before failing.
+const waitTimeout time.Duration = 10 * time.Second
+
+// Options is a struct containing configuration details for the `RunSuite` method.
+type Options struct {
+	// URI is a function that returns a new server URI for each check in the suite. Implementations should append
+	// 'params', which may contain timeout parameters defined by `server.NewHTTPServer`, to the URI's query string.
+	// It is invoked once per check so implementations that bind to a fixed resource (like a port or a name) should
+	// return a new value each time.
+	URI func(t *testing.T, params url.Values) string
+	// Client is an optional function that returns the `http.Client` instance and the base URL to use when sending
+	// requests to 's'. If nil a new `http.Client` instance and `s.Address()` are used.
+	Client func(t *testing.T, s server.Server) (*http.Client, string)
+	// ExpectedAddress is an optional function that returns the value `Address()` is expected to return for a server
+	// created using 'uri'. If nil the "Address" check only verifies that `Address()` returns a valid URI with the same
+	// scheme as 'uri'.
+	ExpectedAddress func(uri string) string
+	// SkipTimeouts disables the "Timeouts" check, for implementations that do not support timeout parameters.
+	SkipTimeouts bool
+	// Close is an optional function that releases any resources, like a port or a name, reserved by 's' when it was
+	// created. It is invoked for every server created by the suite, whether or not it was served, once the check that
+	// created it has completed. If nil and 's' implements the `io.Closer` interface its `Close` method is used.
+	Close func(t *testing.T, s server.Server)
+}
+
+// RunSuite runs the conformance suite for servers created by 'f' as subtests of 't'. The checks are:
+//
+//   - "Handler" verifies that the handler passed to `ListenAndServe` is served.
+//   - "Address" verifies that `Address()` returns the correct URI.
+//   - "Shutdown" verifies that cancelling the context passed to `ListenAndServe` shuts down the server after draining in-flight requests.
+//   - "Timeouts" verifies that the "write_timeout" parameter is applied.
+//   - "Streaming" verifies that flushed response data reaches the client before the handler completes.
+//   - "LargeBody" verifies that large request and response bodies pass through unaltered.
+//
+// Every server created by the suite is released (see `Options.Close`) so the suite can be run repeatedly in the same process.
+func RunSuite(t *testing.T, f server.ServerInitializeFunc, opts *Options) {
+
+	t.Run("Handler", func(t *testing.T) {
+		testHandler(t, f, opts)
+	})
+
+	t.Run("Address", func(t *testing.T) {
+		testAddress(t, f, opts)
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		testShutdown(t, f, opts)
+	})
+
+	t.Run("Timeouts", func(t *testing.T) {
+
+		if opts.SkipTimeouts {
+			t.Skip("Timeouts check disabled")
+		}
+
+		testTimeouts(t, f, opts)
+	})
+
+	t.Run("Streaming", func(t *testing.T) {
+		testStreaming(t, f, opts)
+	})
+
+	t.Run("LargeBody", func(t *testing.T) {
+		testLargeBody(t, f, opts)
+	})
+}
+
+func testHandler(t *testing.T, f server.ServerInitializeFunc, opts *Options) {
+
+	ts := startServer(t, f, opts, nil, http.NotFoundHandler())
+	defer ts.Stop(t)
+
+	rsp, err := ts.client.Get(ts.base_url + "/")
+
+	if err != nil {
+		t.Fatalf("Failed to GET request, %v", err)
+	}
+
+	defer rsp.Body.Close()
+
+	body, err := io.ReadAll(rsp.Body)
+
+	if err != nil {
+		t.Fatalf("Failed to read response, %v", err)
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", rsp.StatusCode)
+	}
+
+	if string(body) != HelloWorld {
+		t.Fatalf("Unexpected response: '%s'", string(body))
+	}
+}
+
+func testAddress(t *testing.T, f server.ServerInitializeFunc, opts *Options) {
+
+	ctx := context.Background()
+	uri := opts.URI(t, url.Values{})
+
+	s, err := f(ctx, uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create server for %s, %v", uri, err)
+	}
+
+	releaseServer(t, s, opts)
+
+	addr := s.Address()
+
+	if opts.ExpectedAddress != nil {
+
+		expected := opts.ExpectedAddress(uri)
+
+		if addr != expected {
+			t.Fatalf("Unexpected address for %s. Expected '%s' but got '%s'", uri, expected, addr)
+		}
+
+		return
+	}
+
+	uri_u, err := url.Parse(uri)
+
+	if err != nil {
+		t.Fatalf("Failed to parse %s, %v", uri, err)
+	}
+
+	addr_u, err := url.Parse(addr)
+
+	if err != nil {
+		t.Fatalf("Failed to parse address '%s', %v", addr, err)
+	}
+
+	if addr_u.Scheme != uri_u.Scheme {
+		t.Fatalf("Unexpected scheme for address '%s'. Expected '%s'", addr, uri_u.Scheme)
+	}
+}
+
+func testShutdown(t *testing.T, f server.ServerInitializeFunc, opts *Options) {
+
+	started_ch := make(chan struct{})
+	release_ch := make(chan struct{})
+
+	block := func(rsp http.ResponseWriter, req *http.Request) {
+		close(started_ch)
+		<-release_ch
+		rsp.Write([]byte("drained"))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/block", block)
+
+	ts := startServer(t, f, opts, nil, mux)
+
+	type result struct {
+		body string
+		err  error
+	}
+
+	result_ch := make(chan result)
+
+	go func() {
+
+		rsp, err := ts.client.Get(ts.base_url + "/block")
+
+		if err != nil {
+			result_ch <- result{err: err}
+			return
+		}
+
+		defer rsp.Body.Close()
+
+		body, err := io.ReadAll(rsp.Body)
+		result_ch <- result{body: string(body), err: err}
+	}()
+
+	select {
+	case <-started_ch:
+	case <-time.After(waitTimeout):
+		t.Fatalf("Timed out waiting for request to start")
+	}
+
+	ts.cancel()
+
+	// The server should not stop while a request is still in flight
+
+	select {
+	case err := <-ts.done_ch:
+		t.Fatalf("Server stopped before in-flight request completed, %v", err)
+	case <-time.After(250 * time.Millisecond):
+	}
+
+	close(release_ch)
+
+	select {
+	case r := <-result_ch:
+
+		if r.err != nil {
+			t.Fatalf("In-flight request failed during shutdown, %v", r.err)
+		}
+
+		if r.body != "drained" {
+			t.Fatalf("Unexpected response for in-flight request: '%s'", r.body)
+		}
+
+	case <-time.After(waitTimeout):
+		t.Fatalf("Timed out waiting for in-flight request to complete")
+	}
+
+	ts.Wait(t)
+}
+
+func testTimeouts(t *testing.T, f server.ServerInitializeFunc, opts *Options) {
+
+	slow := func(rsp http.ResponseWriter, req *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+		rsp.Write([]byte("too late"))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", slow)
+
+	params := url.Values{}
+	params.Set("write_timeout", "1")
+
+	ts := startServer(t, f, opts, params, mux)
+	defer ts.Stop(t)
+
+	rsp, err := ts.client.Get(ts.base_url + "/slow")
+
+	if err != nil {
+		// This is what we expect
+		return
+	}
+
+	defer rsp.Body.Close()
+
+	body, err := io.ReadAll(rsp.Body)
+
+	if err == nil && string(body) == "too late" {
+		t.Fatalf("Expected write timeout to be applied")
+	}
+}
+
+func testStreaming(t *testing.T, f server.ServerInitializeFunc, opts *Options) {
+
+	next_ch := make(chan struct{})
+
+	stream := func(rsp http.ResponseWriter, req *http.Request) {
+
+		flusher, ok := rsp.(http.Flusher)
+
+		if !ok {
+			http.Error(rsp, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		rsp.Write([]byte("first\n"))
+		flusher.Flush()
+
+		select {
+		case <-next_ch:
+		case <-req.Context().Done():
+			return
+		}
+
+		rsp.Write([]byte("second\n"))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", stream)
+
+	ts := startServer(t, f, opts, nil, mux)
+	defer ts.Stop(t)
+
+	rsp, err := ts.client.Get(ts.base_url + "/stream")
+
+	if err != nil {
+		t.Fatalf("Failed to GET request, %v", err)
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", rsp.StatusCode)
+	}
+
+	first := make([]byte, len("first\n"))
+
+	read_ch := make(chan error)
+
+	go func() {
+		_, err := io.ReadFull(rsp.Body, first)
+		read_ch <- err
+	}()
+
+	select {
+	case err := <-read_ch:
+
+		if err != nil {
+			t.Fatalf("Failed to read first chunk, %v", err)
+		}
+
+	case <-time.After(waitTimeout):
+		close(next_ch)
+		t.Fatalf("Timed out waiting for first chunk; response was not streamed")
+	}
+
+	if string(first) != "first\n" {
+		t.Fatalf("Unexpected first chunk: '%s'", string(first))
+	}
+
+	close(next_ch)
+
+	rest, err := io.ReadAll(rsp.Body)
+
+	if err != nil {
+		t.Fatalf("Failed to read second chunk, %v", err)
+	}
+
+	if string(rest) != "second\n" {
+		t.Fatalf("Unexpected second chunk: '%s'", string(rest))
+	}
+}
+
+func testLargeBody(t *testing.T, f server.ServerInitializeFunc, opts *Options) {
+
+	echo := func(rsp http.ResponseWriter, req *http.Request) {
+
+		body, err := io.ReadAll(req.Body)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		sum := sha256.Sum256(body)
+
+		rsp.Header().Set("X-Body-SHA256", hex.EncodeToString(sum[:]))
+		rsp.Header().Set("Content-Type", "application/octet-stream")
+		rsp.Write(body)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", echo)
+
+	ts := startServer(t, f, opts, nil, mux)
+	defer ts.Stop(t)
+
+	body := make([]byte, LargeBodySize)
+
+	_, err := rand.Read(body)
+
+	if err != nil {
+		t.Fatalf("Failed to generate body, %v", err)
+	}
+
+	sum := sha256.Sum256(body)
+	expected := hex.EncodeToString(sum[:])
+
+	rsp, err := ts.client.Post(ts.base_url+"/echo", "application/octet-stream", bytes.NewReader(body))
+
+	if err != nil {
+		t.Fatalf("Failed to POST request, %v", err)
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", rsp.StatusCode)
+	}
+
+	if rsp.Header.Get("X-Body-SHA256") != expected {
+		t.Fatalf("Request body was altered in transit")
+	}
+
+	rsp_body, err := io.ReadAll(rsp.Body)
+
+	if err != nil {
+		t.Fatalf("Failed to read response, %v", err)
+	}
+
+	if !bytes.Equal(rsp_body, body) {
+		t.Fatalf("Response body was altered in transit (%d bytes received)", len(rsp_body))
+	}
+}
+
+// testServer is a running server under test.
+type testServer struct {
+	server   server.Server
+	client   *http.Client
+	base_url string
+	cancel   context.CancelFunc
+	done_ch  chan error
+}
+
+// startServer creates a new server using 'f' and starts it, serving 'mux' for all paths except "/", which
+// returns `HelloWorld`. It waits until the server is serving requests before returning.
+func startServer(t *testing.T, f server.ServerInitializeFunc, opts *Options, params url.Values, mux http.Handler) *testServer {
+
+	if params == nil {
+		params = url.Values{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	uri := opts.URI(t, params)
+
+	s, err := f(ctx, uri)
+
+	if err != nil {
+		cancel()
+		t.Fatalf("Failed to create server for %s, %v", uri, err)
+	}
+
+	// Cleanup functions run in reverse order so the server is released after it has been stopped
+
+	releaseServer(t, s, opts)
+	t.Cleanup(cancel)
+
+	var client *http.Client
+	var base_url string
+
+	if opts.Client != nil {
+		client, base_url = opts.Client(t, s)
+	} else {
+		client = &http.Client{
+			Transport: &http.Transport{},
+		}
+		base_url = s.Address()
+	}
+
+	hello := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.URL.Path != "/" {
+			mux.ServeHTTP(rsp, req)
+			return
+		}
+
+		rsp.Write([]byte(HelloWorld))
+	}
+
+	done_ch := make(chan error, 1)
+
+	go func() {
+		done_ch <- s.ListenAndServe(ctx, http.HandlerFunc(hello))
+	}()
+
+	ts := &testServer{
+		server:   s,
+		client:   client,
+		base_url: base_url,
+		cancel:   cancel,
+		done_ch:  done_ch,
+	}
+
+	// Wait for the server to start serving requests
+
+	deadline := time.Now().Add(waitTimeout)
+
+	for {
+
+		rsp, err := client.Get(base_url + "/")
+
+		if err == nil {
+			rsp.Body.Close()
+			break
+		}
+
+		select {
+		case err := <-done_ch:
+			cancel()
+			t.Fatalf("Server for %s stopped before serving requests, %v", uri, err)
+		default:
+		}
+
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatalf("Timed out waiting for server %s to start, %v", uri, err)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return ts
+}
+
+// releaseServer registers a cleanup function with 't' which releases the resources reserved by 's' using 'opts.Close'
+// or, if it is nil, the `Close` method of 's' if it implements the `io.Closer` interface.
+func releaseServer(t *testing.T, s server.Server, opts *Options) {
+
+	t.Cleanup(func() {
+
+		if opts.Close != nil {
+			opts.Close(t, s)
+			return
+		}
+
+		c, ok := s.(io.Closer)
+
+		if !ok {
+			return
+		}
+
+		err := c.Close()
+
+		if err != nil {
+			t.Errorf("Failed to close server %s, %v", s.Address(), err)
+		}
+	})
+}
+
+// Stop cancels the context passed to the server's `ListenAndServe` method and waits for it to return.
+func (ts *testServer) Stop(t *testing.T) {
+	ts.cancel()
+	ts.Wait(t)
+}
+
+// Wait waits for the server's `ListenAndServe` method to return, failing if it returns an error.
+func (ts *testServer) Wait(t *testing.T) {
+
+	if tr, ok := ts.client.Transport.(interface{ CloseIdleConnections() }); ok {
+		tr.CloseIdleConnections()
+	}
+
+	select {
+	case err := <-ts.done_ch:
+
+		if err != nil {
+			t.Fatalf("Server returned an error, %v", err)
+		}
+
+	case <-time.After(waitTimeout):
+		t.Fatalf("Timed out waiting for server to shut down")
+	}
+}
@@ -0,0 +1,61 @@
+package servertest
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aaronland/go-http-server/v2"
+)
+
+func TestHTTPServer(t *testing.T) {
+
+	var host string
+
+	opts := &Options{
+		URI: func(t *testing.T, params url.Values) string {
+
+			l, err := net.Listen("tcp", "localhost:0")
+
+			if err != nil {
+				t.Fatalf("Failed to find a free port, %v", err)
+			}
+
+			host = l.Addr().String()
+			l.Close()
+
+			return fmt.Sprintf("http://%s?%s", host, params.Encode())
+		},
+		ExpectedAddress: func(uri string) string {
+			return fmt.Sprintf("http://%s", host)
+		},
+	}
+
+	RunSuite(t, server.NewHTTPServer, opts)
+}
+
+func TestMemoryServer(t *testing.T) {
+
+	var count int64
+
+	opts := &Options{
+		URI: func(t *testing.T, params url.Values) string {
+			name := fmt.Sprintf("servertest%d", atomic.AddInt64(&count, 1))
+			return fmt.Sprintf("memory://%s?%s", name, params.Encode())
+		},
+		Client: func(t *testing.T, s server.Server) (*http.Client, string) {
+			name := strings.TrimPrefix(s.Address(), "memory://")
+			return server.NewMemoryClient(name), fmt.Sprintf("http://%s", name)
+		},
+		ExpectedAddress: func(uri string) string {
+			u, _ := url.Parse(uri)
+			return fmt.Sprintf("memory://%s", u.Host)
+		},
+	}
+
+	RunSuite(t, server.NewMemoryServer, opts)
+}
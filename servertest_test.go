@@ -0,0 +1,346 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aaronland/go-http-server/v2"
+	"github.com/aaronland/go-http-server/v2/servertest"
+)
+
+func TestFCGIServerConformance(t *testing.T) {
+
+	var host string
+
+	opts := &servertest.Options{
+		URI: func(t *testing.T, params url.Values) string {
+
+			l, err := net.Listen("tcp", "localhost:0")
+
+			if err != nil {
+				t.Fatalf("Failed to find a free port, %v", err)
+			}
+
+			host = l.Addr().String()
+			l.Close()
+
+			return fmt.Sprintf("fcgi://%s?%s", host, params.Encode())
+		},
+		Client: func(t *testing.T, s server.Server) (*http.Client, string) {
+
+			u, _ := url.Parse(s.Address())
+
+			client := &http.Client{
+				Transport: &fcgiTransport{address: u.Host},
+			}
+
+			return client, fmt.Sprintf("http://%s", u.Host)
+		},
+		ExpectedAddress: func(uri string) string {
+			return fmt.Sprintf("fcgi://%s", host)
+		},
+		// FastCGI servers don't support timeout parameters
+		SkipTimeouts: true,
+	}
+
+	servertest.RunSuite(t, server.NewFCGIServer, opts)
+}
+
+func TestStdioServerConformance(t *testing.T) {
+
+	// Each server is connected to its client by one end of a pipe
+
+	conns := new(sync.Map)
+
+	new_server := func(ctx context.Context, uri string) (server.Server, error) {
+
+		s, err := server.NewStdioServer(ctx, uri)
+
+		if err != nil {
+			return nil, err
+		}
+
+		server_conn, client_conn := net.Pipe()
+		server.SetStdioServerStreams(s, server_conn, server_conn)
+
+		conns.Store(s, client_conn)
+		return s, nil
+	}
+
+	opts := &servertest.Options{
+		URI: func(t *testing.T, params url.Values) string {
+			return "stdio://"
+		},
+		Client: func(t *testing.T, s server.Server) (*http.Client, string) {
+
+			v, _ := conns.Load(s)
+			conn := v.(net.Conn)
+
+			// stdio:// servers only ever serve a single connection
+
+			dialed := false
+			mu := new(sync.Mutex)
+
+			dial := func(ctx context.Context, network string, address string) (net.Conn, error) {
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				if dialed {
+					return nil, errors.New("stdio:// servers only accept a single connection")
+				}
+
+				dialed = true
+				return conn, nil
+			}
+
+			client := &http.Client{
+				Transport: &http.Transport{
+					DialContext:     dial,
+					MaxConnsPerHost: 1,
+				},
+			}
+
+			return client, "http://stdio"
+		},
+		// stdio:// servers don't support timeout parameters
+		SkipTimeouts: true,
+	}
+
+	servertest.RunSuite(t, new_server, opts)
+}
+
+// FastCGI record types used by fcgiTransport
+const (
+	fcgiBeginRequest uint8 = 1
+	fcgiEndRequest   uint8 = 3
+	fcgiParams       uint8 = 4
+	fcgiStdin        uint8 = 5
+	fcgiStdout       uint8 = 6
+)
+
+// The maximum length of the content of a FastCGI record.
+const fcgiMaxContent int = 65535
+
+// fcgiTransport is a minimal `http.RoundTripper` which sends each request over a new FastCGI connection to 'address'.
+type fcgiTransport struct {
+	address string
+}
+
+func (tr *fcgiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	conn, err := (&net.Dialer{}).DialContext(req.Context(), "tcp", tr.address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	write_mu := new(sync.Mutex)
+
+	write := func(rec_type uint8, content []byte) error {
+
+		header := []byte{1, rec_type, 0, 1, 0, 0, 0, 0}
+		binary.BigEndian.PutUint16(header[4:], uint16(len(content)))
+
+		write_mu.Lock()
+		defer write_mu.Unlock()
+
+		_, err := conn.Write(append(header, content...))
+		return err
+	}
+
+	// Role 1 is "responder"; flags 0 means close the connection when done
+
+	err = write(fcgiBeginRequest, []byte{0, 1, 0, 0, 0, 0, 0, 0})
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	params := map[string]string{
+		"REQUEST_METHOD":  req.Method,
+		"SERVER_PROTOCOL": "HTTP/1.1",
+		"REQUEST_URI":     req.URL.RequestURI(),
+		"HTTP_HOST":       req.URL.Host,
+	}
+
+	if req.ContentLength > 0 {
+		params["CONTENT_LENGTH"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	for k, v := range req.Header {
+
+		switch k {
+		case "Content-Type":
+			params["CONTENT_TYPE"] = v[0]
+		default:
+			params["HTTP_"+strings.ReplaceAll(strings.ToUpper(k), "-", "_")] = strings.Join(v, ", ")
+		}
+	}
+
+	var buf bytes.Buffer
+
+	for k, v := range params {
+		fcgiWriteLength(&buf, len(k))
+		fcgiWriteLength(&buf, len(v))
+		buf.WriteString(k)
+		buf.WriteString(v)
+	}
+
+	for _, content := range [][]byte{buf.Bytes(), nil} {
+
+		err := write(fcgiParams, content)
+
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// Send the request body in the background so that the response can be read as it arrives
+
+	go func() {
+
+		if req.Body != nil {
+
+			defer req.Body.Close()
+
+			chunk := make([]byte, fcgiMaxContent)
+
+			for {
+
+				n, err := req.Body.Read(chunk)
+
+				if n > 0 && write(fcgiStdin, chunk[:n]) != nil {
+					return
+				}
+
+				if err != nil {
+					break
+				}
+			}
+		}
+
+		write(fcgiStdin, nil)
+	}()
+
+	stdout_r, stdout_w := io.Pipe()
+
+	go func() {
+		stdout_w.CloseWithError(fcgiReadStdout(conn, stdout_w))
+	}()
+
+	br := bufio.NewReader(stdout_r)
+
+	header, err := textproto.NewReader(br).ReadMIMEHeader()
+
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to read response headers, %w", err)
+	}
+
+	rsp := &http.Response{
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		StatusCode:    http.StatusOK,
+		Header:        http.Header(header),
+		ContentLength: -1,
+		Request:       req,
+		Body:          &fcgiResponseBody{Reader: br, conn: conn},
+	}
+
+	status := header.Get("Status")
+
+	if status != "" {
+
+		code, err := strconv.Atoi(strings.SplitN(status, " ", 2)[0])
+
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Invalid status '%s', %w", status, err)
+		}
+
+		rsp.StatusCode = code
+		rsp.Header.Del("Status")
+	}
+
+	rsp.Status = fmt.Sprintf("%d %s", rsp.StatusCode, http.StatusText(rsp.StatusCode))
+	return rsp, nil
+}
+
+// fcgiResponseBody is the body of a response read by `fcgiTransport` which closes its connection when it is closed.
+type fcgiResponseBody struct {
+	io.Reader
+	conn net.Conn
+}
+
+func (b *fcgiResponseBody) Close() error {
+	return b.conn.Close()
+}
+
+// fcgiReadStdout copies the content of the stdout records read from 'conn' to 'w' until the end of the request.
+func fcgiReadStdout(conn net.Conn, w io.Writer) error {
+
+	header := make([]byte, 8)
+
+	for {
+
+		_, err := io.ReadFull(conn, header)
+
+		if err != nil {
+			return err
+		}
+
+		content_len := int(binary.BigEndian.Uint16(header[4:]))
+		padding_len := int(header[6])
+
+		content := make([]byte, content_len+padding_len)
+
+		_, err = io.ReadFull(conn, content)
+
+		if err != nil {
+			return err
+		}
+
+		switch header[1] {
+		case fcgiStdout:
+
+			_, err := w.Write(content[:content_len])
+
+			if err != nil {
+				return err
+			}
+
+		case fcgiEndRequest:
+			return nil
+		}
+	}
+}
+
+// fcgiWriteLength writes the FastCGI name-value pair encoding of 'n' to 'buf'.
+func fcgiWriteLength(buf *bytes.Buffer, n int) {
+
+	if n < 128 {
+		buf.WriteByte(byte(n))
+		return
+	}
+
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(n)|1<<31)
+	buf.Write(b)
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+func init() {
+	ctx := context.Background()
+	RegisterServer(ctx, "stdio", NewStdioServer)
+}
+
+// StdioServer implements the `Server` interface for serving HTTP/1.1 requests over the standard input and
+// standard output of the current process, treating them as a single network connection.
+type StdioServer struct {
+	Server
+	url         *url.URL
+	http_server *http.Server
+	stdin       io.ReadCloser
+	stdout      io.WriteCloser
+	logger      *slog.Logger
+}
+
+// NewStdioServer returns a new `StdioServer` instance configured by 'uri' which is
+// expected to be defined in the form of:
+//
+//	stdio://
+//
+// This is useful for inetd, xinetd or systemd (`Accept=yes`) style deployments where a new process is
+// started for each incoming connection, or for piping requests through tools like SSH. The server will
+// serve requests until the peer closes the connection after which `ListenAndServe` will return.
+func NewStdioServer(ctx context.Context, uri string) (Server, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	logger := LoggerFromContext(ctx)
+
+	base_ctx := func(l net.Listener) context.Context {
+		return WithLogger(context.Background(), logger)
+	}
+
+	srv := &http.Server{
+		ErrorLog:    newErrorLog(logger),
+		BaseContext: base_ctx,
+	}
+
+	server := StdioServer{
+		url:         u,
+		http_server: srv,
+		stdin:       os.Stdin,
+		stdout:      os.Stdout,
+		logger:      logger,
+	}
+
+	return &server, nil
+}
+
+// Address returns the fully-qualified URI used to instantiate 's'.
+func (s *StdioServer) Address() string {
+	return "stdio://"
+}
+
+// ListenAndServe serves requests read from standard input, writing responses to standard output, using 'mux'
+// for routing. It returns when the peer closes the connection.
+func (s *StdioServer) ListenAndServe(ctx context.Context, mux http.Handler) error {
+
+	conn := newStdioConn(s.stdin, s.stdout)
+	listener := newStdioListener(conn)
+
+	done_ch := make(chan struct{})
+	defer close(done_ch)
+
+	shutdown_ch := make(chan struct{})
+
+	go func() {
+
+		sigint := make(chan os.Signal, 1)
+		signal.Notify(sigint, os.Interrupt)
+		defer signal.Stop(sigint)
+
+		select {
+		case <-sigint:
+		case <-ctx.Done():
+		case <-done_ch:
+			return
+		}
+
+		// We received an interrupt signal, shut down.
+
+		err := s.http_server.Shutdown(context.Background())
+
+		if err != nil {
+			s.logger.Error("Failed to shut down server", "address", s.Address(), "error", err)
+		}
+
+		close(shutdown_ch)
+	}()
+
+	s.http_server.Handler = mux
+
+	err := s.http_server.Serve(listener)
+
+	switch {
+	case err == http.ErrServerClosed:
+		// Wait for in-flight requests to drain
+		<-shutdown_ch
+	case errors.Is(err, net.ErrClosed):
+		// The peer closed the connection
+	case err != nil:
+		return err
+	}
+
+	return nil
+}
+
+// stdioAddr implements the `net.Addr` interface for standard input and output.
+type stdioAddr struct{}
+
+func (a stdioAddr) Network() string {
+	return "stdio"
+}
+
+func (a stdioAddr) String() string {
+	return "stdio"
+}
+
+// stdioConn implements the `net.Conn` interface reading from one stream and writing to another.
+type stdioConn struct {
+	reader     io.ReadCloser
+	writer     io.WriteCloser
+	close_once sync.Once
+	closed     chan struct{}
+}
+
+func newStdioConn(r io.ReadCloser, w io.WriteCloser) *stdioConn {
+
+	c := &stdioConn{
+		reader: r,
+		writer: w,
+		closed: make(chan struct{}),
+	}
+
+	return c
+}
+
+func (c *stdioConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *stdioConn) Write(b []byte) (int, error) {
+	return c.writer.Write(b)
+}
+
+func (c *stdioConn) Close() error {
+
+	var err error
+
+	c.close_once.Do(func() {
+
+		r_err := c.reader.Close()
+		w_err := c.writer.Close()
+
+		err = errors.Join(r_err, w_err)
+		close(c.closed)
+	})
+
+	return err
+}
+
+func (c *stdioConn) LocalAddr() net.Addr {
+	return stdioAddr{}
+}
+
+func (c *stdioConn) RemoteAddr() net.Addr {
+	return stdioAddr{}
+}
+
+func (c *stdioConn) SetDeadline(t time.Time) error {
+	return errors.Join(c.SetReadDeadline(t), c.SetWriteDeadline(t))
+}
+
+// SetReadDeadline sets the read deadline on the underlying reader if it supports deadlines (for example
+// a socket passed in by inetd) and is otherwise a no-op.
+func (c *stdioConn) SetReadDeadline(t time.Time) error {
+
+	if d, ok := c.reader.(interface{ SetReadDeadline(time.Time) error }); ok {
+
+		err := d.SetReadDeadline(t)
+
+		if err != nil && !errors.Is(err, os.ErrNoDeadline) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline on the underlying writer if it supports deadlines and is
+// otherwise a no-op.
+func (c *stdioConn) SetWriteDeadline(t time.Time) error {
+
+	if d, ok := c.writer.(interface{ SetWriteDeadline(time.Time) error }); ok {
+
+		err := d.SetWriteDeadline(t)
+
+		if err != nil && !errors.Is(err, os.ErrNoDeadline) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stdioListener implements the `net.Listener` interface returning a single `stdioConn` connection.
+type stdioListener struct {
+	conn       *stdioConn
+	accepted   bool
+	mu         *sync.Mutex
+	close_once sync.Once
+	closed     chan struct{}
+}
+
+func newStdioListener(conn *stdioConn) *stdioListener {
+
+	l := &stdioListener{
+		conn:   conn,
+		mu:     new(sync.Mutex),
+		closed: make(chan struct{}),
+	}
+
+	return l
+}
+
+// Accept returns the underlying connection the first time it is called. Subsequent calls block until
+// that connection, or the listener itself, is closed and then return `net.ErrClosed`.
+func (l *stdioListener) Accept() (net.Conn, error) {
+
+	l.mu.Lock()
+
+	if !l.accepted {
+		l.accepted = true
+		l.mu.Unlock()
+		return l.conn, nil
+	}
+
+	l.mu.Unlock()
+
+	select {
+	case <-l.conn.closed:
+	case <-l.closed:
+	}
+
+	return nil, net.ErrClosed
+}
+
+func (l *stdioListener) Close() error {
+
+	l.close_once.Do(func() {
+		close(l.closed)
+	})
+
+	return nil
+}
+
+func (l *stdioListener) Addr() net.Addr {
+	return stdioAddr{}
+}
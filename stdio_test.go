@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStdioServer(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewServer(ctx, "stdio://")
+
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+
+	if s.Address() != "stdio://" {
+		t.Fatalf("Unexpected address: %s", s.Address())
+	}
+
+	stdin_r, stdin_w := io.Pipe()
+	stdout_r, stdout_w := io.Pipe()
+
+	stdio_s := s.(*StdioServer)
+	stdio_s.stdin = stdin_r
+	stdio_s.stdout = stdout_w
+
+	done_ch := make(chan error)
+
+	go func() {
+		done_ch <- s.ListenAndServe(ctx, testHandler())
+	}()
+
+	br := bufio.NewReader(stdout_r)
+
+	// Issue two requests over the same connection
+
+	for i := 0; i < 2; i++ {
+
+		req, err := http.NewRequest(http.MethodGet, "http://localhost/", nil)
+
+		if err != nil {
+			t.Fatalf("Failed to create request, %v", err)
+		}
+
+		go req.Write(stdin_w)
+
+		rsp, err := http.ReadResponse(br, req)
+
+		if err != nil {
+			t.Fatalf("Failed to read response, %v", err)
+		}
+
+		body, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to read body, %v", err)
+		}
+
+		if string(body) != "Hello world" {
+			t.Fatalf("Unexpected response: '%s'", string(body))
+		}
+	}
+
+	// Close the peer's side of the connection which should cause the server to exit
+
+	stdin_w.Close()
+
+	select {
+	case err := <-done_ch:
+
+		if err != nil {
+			t.Fatalf("Server returned an error, %v", err)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for server to exit")
+	}
+
+	_, err = io.ReadAll(stdout_r)
+
+	if err != nil {
+		t.Fatalf("Failed to read to end of output, %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/aaronland/go-roster"
+)
+
+// ErrWebSocketConnectionGone is returned when posting to a WebSocket connection that is no longer connected.
+var ErrWebSocketConnectionGone = errors.New("WebSocket connection is gone")
+
+// type WebSocketSender is an interface for sending messages to the clients of an API Gateway WebSocket API. It mirrors
+// the "@connections" API of the API Gateway Management API.
+type WebSocketSender interface {
+	// PostToConnection sends 'data' to the client with the connection ID 'connection_id'.
+	PostToConnection(ctx context.Context, connection_id string, data []byte) error
+	// DeleteConnection disconnects the client with the connection ID 'connection_id'.
+	DeleteConnection(ctx context.Context, connection_id string) error
+}
+
+// WebSocketSenderInitializeFunc is a function used to initialize an implementation of the `WebSocketSender` interface.
+type WebSocketSenderInitializeFunc func(context.Context, string) (WebSocketSender, error)
+
+var websocket_senders roster.Roster
+
+func ensureWebSocketSenders() error {
+
+	if websocket_senders == nil {
+
+		r, err := roster.NewDefaultRoster()
+
+		if err != nil {
+			return err
+		}
+
+		websocket_senders = r
+	}
+
+	return nil
+}
+
+func init() {
+	ctx := context.Background()
+	RegisterWebSocketSender(ctx, "memory", NewMemoryWebSocketSender)
+}
+
+// RegisterWebSocketSender() associates 'scheme' with 'f' in an internal list of avilable `WebSocketSender` implementations.
+func RegisterWebSocketSender(ctx context.Context, scheme string, f WebSocketSenderInitializeFunc) error {
+
+	err := ensureWebSocketSenders()
+
+	if err != nil {
+		return err
+	}
+
+	return websocket_senders.Register(ctx, scheme, f)
+}
+
+// NewWebSocketSender() returns a new instance of `WebSocketSender` for the scheme associated with 'uri'. It is assumed that this scheme
+// will have previously been "registered" with the `RegisterWebSocketSender` method.
+func NewWebSocketSender(ctx context.Context, uri string) (WebSocketSender, error) {
+
+	err := ensureWebSocketSenders()
+
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := websocket_senders.Driver(ctx, u.Scheme)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := i.(WebSocketSenderInitializeFunc)
+	return f(ctx, uri)
+}
+
+// WebSocketSenderSchemes() returns the list of `WebSocketSender` schemes that have been "registered".
+func WebSocketSenderSchemes() []string {
+
+	ctx := context.Background()
+	drivers := websocket_senders.Drivers(ctx)
+
+	schemes := make([]string, len(drivers))
+
+	for idx, dr := range drivers {
+		schemes[idx] = fmt.Sprintf("%s://", dr)
+	}
+
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Local registry of in-memory senders, keyed by name
+var memory_websocket_senders = new(sync.Map)
+
+// MemoryWebSocketSender implements the `WebSocketSender` interface by recording messages in memory. It is principally meant for testing.
+type MemoryWebSocketSender struct {
+	WebSocketSender
+	mu       *sync.Mutex
+	messages map[string][][]byte
+	deleted  map[string]bool
+}
+
+// NewMemoryWebSocketSender returns a `MemoryWebSocketSender` instance configured by 'uri' which is
+// expected to be defined in the form of:
+//
+//	memory://{NAME}
+//
+// Where {NAME} is an optional name. Senders with the same (non-empty) name share the same instance so
+// that tests can inspect the messages sent by a server configured with the same URI.
+func NewMemoryWebSocketSender(ctx context.Context, uri string) (WebSocketSender, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	s := &MemoryWebSocketSender{
+		mu:       new(sync.Mutex),
+		messages: make(map[string][][]byte),
+		deleted:  make(map[string]bool),
+	}
+
+	if u.Host == "" {
+		return s, nil
+	}
+
+	v, _ := memory_websocket_senders.LoadOrStore(u.Host, s)
+	return v.(*MemoryWebSocketSender), nil
+}
+
+// PostToConnection records 'data' as a message sent to 'connection_id'. It returns `ErrWebSocketConnectionGone`
+// if 'connection_id' has been deleted.
+func (s *MemoryWebSocketSender) PostToConnection(ctx context.Context, connection_id string, data []byte) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.deleted[connection_id] {
+		return fmt.Errorf("Failed to post to connection %s, %w", connection_id, ErrWebSocketConnectionGone)
+	}
+
+	msg := make([]byte, len(data))
+	copy(msg, data)
+
+	s.messages[connection_id] = append(s.messages[connection_id], msg)
+	return nil
+}
+
+// DeleteConnection marks 'connection_id' as deleted.
+func (s *MemoryWebSocketSender) DeleteConnection(ctx context.Context, connection_id string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.deleted[connection_id] {
+		return fmt.Errorf("Failed to delete connection %s, %w", connection_id, ErrWebSocketConnectionGone)
+	}
+
+	s.deleted[connection_id] = true
+	return nil
+}
+
+// Messages returns the messages sent to 'connection_id', in the order they were sent.
+func (s *MemoryWebSocketSender) Messages(connection_id string) [][]byte {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make([][]byte, len(s.messages[connection_id]))
+	copy(messages, s.messages[connection_id])
+
+	return messages
+}
+
+// Deleted returns true if 'connection_id' has been deleted.
+func (s *MemoryWebSocketSender) Deleted(connection_id string) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.deleted[connection_id]
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	ctx := context.Background()
+	RegisterWebSocketSender(ctx, "apigateway", NewAPIGatewayWebSocketSender)
+}
+
+// APIGatewayWebSocketSender implements the `WebSocketSender` interface using the "@connections" API of the API Gateway Management API.
+type APIGatewayWebSocketSender struct {
+	WebSocketSender
+	endpoint *url.URL
+	region   string
+	client   *http.Client
+}
+
+// NewAPIGatewayWebSocketSender returns a `APIGatewayWebSocketSender` instance configured by 'uri' which is
+// expected to be defined in the form of:
+//
+//	apigateway://?{PARAMETERS}
+//
+// Valid parameters are:
+// * `endpoint={URL}` The optional URL of the API Gateway Management API for the WebSocket API, for example
+// "https://{API_ID}.execute-api.{REGION}.amazonaws.com/{STAGE}". If empty it is derived from the domain name and stage
+// of the WebSocket event associated with the context passed to each method, so messages can only be sent while handling
+// a request for a `lambdawebsocket://` server. Set it explicitly if the API uses a custom domain name.
+// * `region={REGION}` The optional AWS region of the WebSocket API. If empty it is derived from the endpoint, if it is an
+// "execute-api" URL, or the `AWS_REGION` environment variable.
+//
+// Requests are signed using the credentials in the `AWS_ACCESS_KEY_ID`, `AWS_SECRET_ACCESS_KEY` and `AWS_SESSION_TOKEN`
+// environment variables, which the Lambda runtime sets using the function's execution role. That role must be allowed
+// to perform the "execute-api:ManageConnections" action.
+func NewAPIGatewayWebSocketSender(ctx context.Context, uri string) (WebSocketSender, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	s := &APIGatewayWebSocketSender{
+		region: q.Get("region"),
+		client: &http.Client{},
+	}
+
+	if q.Has("endpoint") {
+
+		endpoint, err := url.Parse(q.Get("endpoint"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid endpoint parameter, %w", err)
+		}
+
+		if endpoint.Scheme != "https" && endpoint.Scheme != "http" || endpoint.Host == "" {
+			return nil, fmt.Errorf("Invalid endpoint parameter, %s", q.Get("endpoint"))
+		}
+
+		s.endpoint = endpoint
+	}
+
+	return s, nil
+}
+
+// PostToConnection sends 'data' to the client with the connection ID 'connection_id'. It returns `ErrWebSocketConnectionGone`
+// if the client is no longer connected.
+func (s *APIGatewayWebSocketSender) PostToConnection(ctx context.Context, connection_id string, data []byte) error {
+
+	err := s.do(ctx, http.MethodPost, connection_id, data)
+
+	if err != nil {
+		return fmt.Errorf("Failed to post to connection %s, %w", connection_id, err)
+	}
+
+	return nil
+}
+
+// DeleteConnection disconnects the client with the connection ID 'connection_id'. It returns `ErrWebSocketConnectionGone`
+// if the client is no longer connected.
+func (s *APIGatewayWebSocketSender) DeleteConnection(ctx context.Context, connection_id string) error {
+
+	err := s.do(ctx, http.MethodDelete, connection_id, nil)
+
+	if err != nil {
+		return fmt.Errorf("Failed to delete connection %s, %w", connection_id, err)
+	}
+
+	return nil
+}
+
+// do sends a signed 'method' request, with 'body', for 'connection_id' to the API Gateway Management API.
+func (s *APIGatewayWebSocketSender) do(ctx context.Context, method string, connection_id string, body []byte) error {
+
+	endpoint, err := s.endpointFor(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	region := s.region
+
+	if region == "" {
+		region = apiGatewayRegion(endpoint.Host)
+	}
+
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	if region == "" {
+		return fmt.Errorf("Failed to determine AWS region for %s", endpoint.Host)
+	}
+
+	creds, err := awsCredentialsFromEnv()
+
+	if err != nil {
+		return err
+	}
+
+	// Connection IDs may contain characters, like "/", which need to be escaped
+
+	u := *endpoint
+	u.Path = strings.TrimRight(endpoint.Path, "/") + "/@connections/" + connection_id
+	u.RawPath = strings.TrimRight(endpoint.EscapedPath(), "/") + "/@connections/" + url.PathEscape(connection_id)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return fmt.Errorf("Failed to create request, %w", err)
+	}
+
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	signAWSRequest(req, body, creds, region, "execute-api", time.Now())
+
+	rsp, err := s.client.Do(req)
+
+	if err != nil {
+		return fmt.Errorf("Failed to execute request, %w", err)
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode == http.StatusGone {
+		return ErrWebSocketConnectionGone
+	}
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 1024))
+		return fmt.Errorf("API Gateway returned %d %s", rsp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	_, err = io.Copy(io.Discard, rsp.Body)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read response, %w", err)
+	}
+
+	return nil
+}
+
+// endpointFor returns the URL of the API Gateway Management API to use for requests made with 'ctx'. This is either the
+// `endpoint` parameter or the URL derived from the WebSocket event associated with 'ctx'.
+func (s *APIGatewayWebSocketSender) endpointFor(ctx context.Context) (*url.URL, error) {
+
+	if s.endpoint != nil {
+		return s.endpoint, nil
+	}
+
+	ev, ok := WebSocketRequestFromContext(ctx)
+
+	if !ok || ev.RequestContext.DomainName == "" {
+		return nil, errors.New("Failed to derive API Gateway endpoint, context is not associated with a WebSocket event and no endpoint parameter was set")
+	}
+
+	u := &url.URL{
+		Scheme: "https",
+		Host:   ev.RequestContext.DomainName,
+		Path:   "/" + ev.RequestContext.Stage,
+	}
+
+	return u, nil
+}
+
+// apiGatewayRegion returns the AWS region of an "{API_ID}.execute-api.{REGION}.amazonaws.com" host, or an empty string
+// if 'host' is not in that form.
+func apiGatewayRegion(host string) string {
+
+	parts := strings.Split(host, ".")
+
+	if len(parts) < 4 || parts[1] != "execute-api" {
+		return ""
+	}
+
+	return parts[2]
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestAPIGatewayWebSocketSender(t *testing.T) {
+
+	ctx := context.Background()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "token")
+	t.Setenv("AWS_REGION", "")
+
+	type request struct {
+		Method string
+		Path   string
+		Body   string
+	}
+
+	requests := make([]request, 0)
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		body, _ := io.ReadAll(req.Body)
+
+		// Sign a copy of the request as it was received to check the signature matches
+
+		signed_at, err := time.Parse("20060102T150405Z", req.Header.Get("X-Amz-Date"))
+
+		if err != nil {
+			http.Error(rsp, "Invalid date", http.StatusForbidden)
+			return
+		}
+
+		check, _ := http.NewRequest(req.Method, "https://"+req.Host+req.URL.RequestURI(), bytes.NewReader(body))
+
+		if req.Header.Get("Content-Type") != "" {
+			check.Header.Set("Content-Type", req.Header.Get("Content-Type"))
+		}
+
+		creds := &awsCredentials{
+			access_key_id:     "AKIDEXAMPLE",
+			secret_access_key: "secret",
+			session_token:     "token",
+		}
+
+		signAWSRequest(check, body, creds, "us-east-1", "execute-api", signed_at)
+
+		if req.Header.Get("Authorization") != check.Header.Get("Authorization") || req.Header.Get("X-Amz-Security-Token") != "token" {
+			http.Error(rsp, "Invalid signature", http.StatusForbidden)
+			return
+		}
+
+		requests = append(requests, request{req.Method, req.URL.EscapedPath(), string(body)})
+
+		if strings.HasSuffix(req.URL.Path, "/gone") {
+			http.Error(rsp, "Gone", http.StatusGone)
+			return
+		}
+	}
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	ts_url, _ := url.Parse(ts.URL)
+
+	q := url.Values{}
+	q.Set("endpoint", ts.URL+"/prod")
+	q.Set("region", "us-east-1")
+
+	s, err := NewWebSocketSender(ctx, "apigateway://?"+q.Encode())
+
+	if err != nil {
+		t.Fatalf("Failed to create sender, %v", err)
+	}
+
+	s.(*APIGatewayWebSocketSender).client = ts.Client()
+
+	err = s.PostToConnection(ctx, "abc=", []byte("hello"))
+
+	if err != nil {
+		t.Fatalf("Failed to post to connection, %v", err)
+	}
+
+	err = s.DeleteConnection(ctx, "a/b")
+
+	if err != nil {
+		t.Fatalf("Failed to delete connection, %v", err)
+	}
+
+	err = s.PostToConnection(ctx, "gone", []byte("hello"))
+
+	if !errors.Is(err, ErrWebSocketConnectionGone) {
+		t.Fatalf("Expected ErrWebSocketConnectionGone, got %v", err)
+	}
+
+	// Without an endpoint it is derived from the WebSocket event, and the region from the AWS_REGION environment variable
+
+	s, err = NewWebSocketSender(ctx, "apigateway://")
+
+	if err != nil {
+		t.Fatalf("Failed to create sender, %v", err)
+	}
+
+	s.(*APIGatewayWebSocketSender).client = ts.Client()
+
+	err = s.PostToConnection(ctx, "abc=", []byte("hello"))
+
+	if err == nil {
+		t.Fatalf("Expected post without WebSocket event to fail")
+	}
+
+	ev := events.APIGatewayWebsocketProxyRequest{}
+	ev.RequestContext.DomainName = ts_url.Host
+	ev.RequestContext.Stage = "dev"
+
+	ev_ctx := context.WithValue(ctx, lambdaEventContextKey{}, ev)
+
+	err = s.PostToConnection(ev_ctx, "abc=", []byte("hello"))
+
+	if err == nil {
+		t.Fatalf("Expected post without region to fail")
+	}
+
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	err = s.PostToConnection(ev_ctx, "abc=", []byte("world"))
+
+	if err != nil {
+		t.Fatalf("Failed to post to connection with derived endpoint, %v", err)
+	}
+
+	expected := []request{
+		{http.MethodPost, "/prod/@connections/abc=", "hello"},
+		{http.MethodDelete, "/prod/@connections/a%2Fb", ""},
+		{http.MethodPost, "/prod/@connections/gone", "hello"},
+		{http.MethodPost, "/dev/@connections/abc=", "world"},
+	}
+
+	if len(requests) != len(expected) {
+		t.Fatalf("Unexpected requests: %v", requests)
+	}
+
+	for i, r := range expected {
+
+		if requests[i] != r {
+			t.Fatalf("Unexpected request %d: %v", i, requests[i])
+		}
+	}
+
+	// Missing credentials
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+
+	err = s.PostToConnection(ev_ctx, "abc=", []byte("hello"))
+
+	if err == nil {
+		t.Fatalf("Expected post without credentials to fail")
+	}
+
+	_, err = NewWebSocketSender(ctx, "apigateway://?endpoint=example.com")
+
+	if err == nil {
+		t.Fatalf("Expected invalid endpoint parameter to fail")
+	}
+}
+
+func TestAPIGatewayRegion(t *testing.T) {
+
+	tests := map[string]string{
+		"abc123.execute-api.us-west-2.amazonaws.com": "us-west-2",
+		"ws.example.com": "",
+		"localhost":      "",
+	}
+
+	for host, expected := range tests {
+
+		if v := apiGatewayRegion(host); v != expected {
+			t.Fatalf("Unexpected region for %s: '%s'", host, v)
+		}
+	}
+}
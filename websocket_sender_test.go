@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryWebSocketSender(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewWebSocketSender(ctx, "memory://")
+
+	if err != nil {
+		t.Fatalf("Failed to create sender, %v", err)
+	}
+
+	err = s.PostToConnection(ctx, "a", []byte("hello"))
+
+	if err != nil {
+		t.Fatalf("Failed to post to connection, %v", err)
+	}
+
+	err = s.DeleteConnection(ctx, "a")
+
+	if err != nil {
+		t.Fatalf("Failed to delete connection, %v", err)
+	}
+
+	err = s.PostToConnection(ctx, "a", []byte("world"))
+
+	if !errors.Is(err, ErrWebSocketConnectionGone) {
+		t.Fatalf("Expected ErrWebSocketConnectionGone, got %v", err)
+	}
+
+	mem := s.(*MemoryWebSocketSender)
+
+	if len(mem.Messages("a")) != 1 || !mem.Deleted("a") {
+		t.Fatalf("Unexpected state for connection")
+	}
+
+	// Named senders are shared
+
+	s1, _ := NewWebSocketSender(ctx, "memory://TestMemoryWebSocketSender")
+	s2, _ := NewWebSocketSender(ctx, "memory://TestMemoryWebSocketSender")
+
+	if s1 != s2 {
+		t.Fatalf("Expected named senders to be shared")
+	}
+
+	// Unnamed senders are not
+
+	s3, _ := NewWebSocketSender(ctx, "memory://")
+
+	if s3 == s {
+		t.Fatalf("Expected unnamed senders to be distinct")
+	}
+}